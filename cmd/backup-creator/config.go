@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+)
+
+func runConfig(cfg config.Config, args []string) {
+	if len(args) == 0 {
+		fatal("Usage: config validate | config print [--redacted] [--format yaml|toml]")
+	}
+
+	switch args[0] {
+	case "validate":
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+	case "print":
+		fs := flag.NewFlagSet("config print", flag.ExitOnError)
+		redacted := fs.Bool("redacted", false, "replace secrets with [REDACTED]")
+		format := fs.String("format", "yaml", "output format: yaml or toml")
+		fs.Parse(args[1:])
+
+		if *redacted {
+			cfg = cfg.Redacted()
+		}
+		data, err := cfg.Marshal(*format)
+		if err != nil {
+			fatal("Failed to print configuration", "error", err)
+		}
+		os.Stdout.Write(data)
+	default:
+		fatal("Unknown config command, expected validate or print", "command", args[0])
+	}
+}
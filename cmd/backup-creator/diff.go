@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/Feride3d/backup-creator/internal/client"
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/diff"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+func runDiff(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	live := fs.Bool("live", false, "compare the folder with the current assets in Marketing Cloud")
+	format := fs.String("format", "text", "output format: text or json")
+	folders := parseArgs(fs, args)
+
+	if *live && len(folders) != 1 || !*live && len(folders) != 2 {
+		fatal("Usage: diff <folderA> <folderB> | diff <folder> --live [--format text|json]")
+	}
+
+	ctx := context.Background()
+	objectStore := newObjectStore(cfg)
+
+	from, err := storage.LoadFolderBlocks(ctx, objectStore, folders[0])
+	if err != nil {
+		fatal("Failed to load backup", "folder", folders[0], "error", err)
+	}
+
+	var to []model.ContentBlock
+	toName := "live"
+	if *live {
+		to, err = fetchLiveBlocks(ctx, newContentClient(cfg), from)
+	} else {
+		toName = folders[1]
+		to, err = storage.LoadFolderBlocks(ctx, objectStore, folders[1])
+	}
+	if err != nil {
+		fatal("Failed to load backup", "folder", toName, "error", err)
+	}
+
+	report, err := diff.Compare(folders[0], from, toName, to)
+	if err != nil {
+		fatal("Failed to compare backups", "error", err)
+	}
+
+	switch *format {
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	case "text":
+		err = report.WriteText(os.Stdout)
+	default:
+		fatal("Unknown format", "format", *format)
+	}
+	if err != nil {
+		fatal("Failed to write report", "error", err)
+	}
+}
+
+// fetchLiveBlocks returns the current version of every backed up block that still exists
+func fetchLiveBlocks(ctx context.Context, contentClient *client.ContentClient, backedUp []model.ContentBlock) ([]model.ContentBlock, error) {
+	var live []model.ContentBlock
+	for _, b := range backedUp {
+		block, err := contentClient.GetAsset(ctx, b.ID)
+		if errors.Is(err, client.ErrAssetNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		live = append(live, block)
+	}
+	return live, nil
+}
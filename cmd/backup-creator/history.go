@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/history"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+const historyUsage = "Usage: history asset <id> | history runs [--limit N] | history rebuild, each with [--format text|json]"
+
+func runHistory(cfg config.Config, args []string) {
+	if len(args) == 0 {
+		fatal(historyUsage)
+	}
+	if cfg.HistoryFile == "off" {
+		fatal("The history index is disabled, set HISTORY_FILE to enable it")
+	}
+
+	fs := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	limit := fs.Int("limit", 20, "number of runs to list, 0 for all")
+	positional := parseArgs(fs, args[1:])
+	if *format != "text" && *format != "json" {
+		fatal("Unknown format", "format", *format)
+	}
+
+	switch args[0] {
+	case "asset":
+		if len(positional) != 1 {
+			fatal(historyUsage)
+		}
+		assetID, err := strconv.Atoi(positional[0])
+		if err != nil {
+			fatal("Invalid asset ID", "id", positional[0])
+		}
+		index := openHistory(cfg)
+		defer index.Close()
+		versions, err := index.AssetVersions(assetID)
+		if err != nil {
+			fatal("Failed to read history", "error", err)
+		}
+		if *format == "json" {
+			writeJSON(versions)
+			return
+		}
+		if len(versions) == 0 {
+			fmt.Printf("Asset %d has not been backed up\n", assetID)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FOLDER\tDESTINATION\tMODIFIED\tSHA256\tRUN\tNAME")
+		for _, v := range versions {
+			if v.DeletedAt != nil {
+				fmt.Fprintf(w, "%s\t-\t%s\tdeleted\t%s\t%s\n", v.Folder, formatTime(*v.DeletedAt), v.RunID, v.Name)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.12s\t%s\t%s\n", v.Folder, v.Destination, formatTime(v.ModifiedDate), v.SHA256, v.RunID, v.Name)
+		}
+		w.Flush()
+	case "runs":
+		index := openHistory(cfg)
+		defer index.Close()
+		runs, err := index.Runs(*limit)
+		if err != nil {
+			fatal("Failed to read history", "error", err)
+		}
+		if *format == "json" {
+			writeJSON(runs)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTARTED\tDURATION\tRESULT\tFETCHED\tSAVED\tFAILED\tFOLDER")
+		for _, r := range runs {
+			result := "success"
+			if !r.Success {
+				result = "failure"
+			}
+			duration := r.FinishedAt.Sub(r.StartedAt).Round(time.Second)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", r.RunID, formatTime(r.StartedAt), duration, result,
+				r.Progress.Fetched, r.Progress.Saved, r.Progress.Failed, r.Folder)
+		}
+		w.Flush()
+	case "rebuild":
+		objectStores, _ := newStorage(cfg)
+		sources := historySources(cfg, objectStores)
+		if len(sources) == 0 {
+			fatal("The selected storage does not support reading backups")
+		}
+		index, err := history.Open(cfg.HistoryFile)
+		if err != nil {
+			fatal("Failed to open history index", "error", err)
+		}
+		defer index.Close()
+		stats, err := history.Rebuild(context.Background(), index, sources)
+		if err != nil {
+			fatal("Failed to rebuild history index", "error", err)
+		}
+		if *format == "json" {
+			writeJSON(stats)
+			return
+		}
+		fmt.Printf("Indexed %d runs and %d asset versions from %d folders\n", stats.Runs, stats.Versions, stats.Folders)
+	default:
+		fatal("Unknown history command, expected asset, runs or rebuild", "command", args[0])
+	}
+}
+
+// historySources names the readable destinations returned by newStorage, which skips
+// the git destination
+func historySources(cfg config.Config, objectStores []storage.ObjectStore) []history.Source {
+	var sources []history.Source
+	for _, name := range destinationNames(cfg) {
+		if name == "git" || len(sources) == len(objectStores) {
+			continue
+		}
+		sources = append(sources, history.Source{Name: name, Store: objectStores[len(sources)]})
+	}
+	return sources
+}
+
+// openHistory opens the index for queries
+func openHistory(cfg config.Config) *history.Index {
+	if _, err := os.Stat(cfg.HistoryFile); err != nil {
+		fatal("No history index found, run history rebuild to create it from the backups", "file", cfg.HistoryFile)
+	}
+	index, err := history.OpenReadOnly(cfg.HistoryFile)
+	if err != nil {
+		fatal("Failed to open history index", "error", err)
+	}
+	return index
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fatal("Failed to write output", "error", err)
+	}
+}
@@ -1,46 +1,215 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/Feride3d/backup-creator/internal/client"
 	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/history"
+	"github.com/Feride3d/backup-creator/internal/logging"
 	"github.com/Feride3d/backup-creator/internal/scheduler"
 	"github.com/Feride3d/backup-creator/internal/service"
 	"github.com/Feride3d/backup-creator/internal/storage"
+	"github.com/Feride3d/backup-creator/internal/tracing"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
+	envErr := godotenv.Load()
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file, overridden by environment variables")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fatal("Failed to load configuration", "error", err)
 	}
-	cfg := config.Load()
 
-	authClient := client.NewAuthClient(cfg.AuthURL, cfg.ClientID, cfg.ClientSecret)
-	token, err := authClient.GetAccessToken()
+	logger, err := newLogger(cfg)
 	if err != nil {
-		log.Fatalf("Failed to get access token: %v", err)
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+	if envErr != nil {
+		slog.Info("No .env file found, using system environment variables")
 	}
 
-	contentClient := client.NewContentClient(cfg.APIURL, &token, authClient)
+	command, args := "run", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
-	var selectedStorage service.Storage
-	if cfg.S3Bucket != "" {
-		selectedStorage, err = storage.NewS3Storage(cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
-		if err != nil {
-			log.Fatalf("Failed to create S3 storage: %v", err)
+	switch command {
+	case "run":
+		if err := cfg.Validate(); err != nil {
+			fatal("Invalid configuration", "error", err)
 		}
-	} else {
-		selectedStorage = storage.NewLocalStorage(cfg.StoragePath)
+		runScheduler(cfg, *configFile)
+	case "prune":
+		runPrune(cfg, args)
+	case "diff":
+		runDiff(cfg, args)
+	case "config":
+		runConfig(cfg, args)
+	case "history":
+		runHistory(cfg, args)
+	case "restore":
+		runRestore(cfg, args)
+	default:
+		fatal("Unknown command, expected one of: run, prune, diff, config, history, restore", "command", command)
+	}
+}
+
+func runScheduler(cfg config.Config, configFile string) {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Propagate:   cfg.Tracing.Propagate,
+	})
+	if err != nil {
+		fatal("Invalid tracing configuration", "error", err)
 	}
+	contentClient := newContentClient(cfg)
+
+	objectStores, selectedStorage := newStorage(cfg)
+	sweepStaging(cfg, objectStores)
 
 	fetchService := service.NewFetchService(contentClient)
+	fetchService.Workers = cfg.FetchWorkers
 	backupService := service.NewBackupService(selectedStorage)
+	backupService.SetWorkers(cfg.SaveWorkers)
+
+	scheduler := scheduler.NewScheduler(fetchService, backupService, cfg.LastRunFile)
+	scheduler.SetLogger(slog.Default())
+	notifier, digest := newNotifier(cfg)
+	if notifier != nil {
+		scheduler.SetNotifier(notifier)
+	}
+	if digest != nil {
+		if err := scheduler.AddJob("digest", cfg.Notify.DigestCron, digest.Send); err != nil {
+			fatal("Invalid digest schedule", "error", err)
+		}
+	}
+	for _, objectStore := range objectStores {
+		scheduler.AddReportWriter(storage.NewReportWriter(objectStore, cfg.ReportHTML))
+	}
+	if cfg.HistoryFile != "off" {
+		scheduler.AddRunRecorder(history.NewRecorder(cfg.HistoryFile, historySources(cfg, objectStores)))
+	}
+	if cfg.Inventory != "off" {
+		reconciler := service.NewReconcileService(contentClient, cfg.Inventory)
+		reconciler.Interval = cfg.Reconcile
+		scheduler.SetReconciler(reconciler, cfg.DeleteAlert)
+	}
+	if len(cfg.DEKeys) > 0 || len(cfg.DEFolders) > 0 {
+		if len(objectStores) == 0 {
+			fatal("Data extensions cannot be backed up to a git repository, add another destination")
+		}
+		deClient := client.NewDataExtensionClient(contentClient, cfg.APIURL, cfg.SOAPServiceURL())
+		deService := service.NewDataExtensionService(deClient, storage.NewDataExtensionStorage(objectStores...))
+		deService.Keys, deService.Folders = cfg.DEKeys, cfg.DEFolders
+		if cfg.DEFormat != "" {
+			deService.Format = cfg.DEFormat
+		}
+		scheduler.SetDataExtensionBackup(deService)
+	}
+	if cfg.Retention.PruneAfterRun {
+		for _, objectStore := range objectStores {
+			scheduler.AddPruner(storage.NewPruner(objectStore, retentionPolicy(cfg)))
+		}
+	}
+	server := startHTTPServer(cfg.HTTPAddr, newMux(cfg, scheduler, contentClient, objectStores))
+	ctx, stopReload := context.WithCancel(context.Background())
+	go reloadSecrets(ctx, configFile, cfg)
+
+	if cfg.FullSchedule != "" {
+		if err := scheduler.ScheduleFull(cfg.FullSchedule); err != nil {
+			fatal("Invalid full snapshot schedule", "error", err)
+		}
+	}
+	if err := scheduler.Run(cfg.Schedule); err != nil {
+		fatal("Failed to start scheduler", "error", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("Shutting down scheduler")
+	stopReload()
+	<-scheduler.Stop().Done()
+	stopHTTPServer(server)
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+}
 
-	scheduler := scheduler.NewScheduler(fetchService, backupService, "lastrun.txt")
-	cronExpr := "0 0 * * *" // cron job every day at midnight
-	scheduler.Run(cronExpr)
+func newContentClient(cfg config.Config) *client.ContentClient {
+	authClient := client.NewAuthClient(cfg.TokenURL(), cfg.ClientID, cfg.ClientSecret)
+	onSecretRotation(func(cfg config.Config) {
+		authClient.SetClientSecret(cfg.ClientSecret)
+	})
+	token, err := authClient.GetAccessToken()
+	if err != nil {
+		fatal("Failed to get access token", "error", err)
+	}
+	return client.NewContentClient(cfg.AssetsURL(), &token, authClient)
+}
 
-	log.Println("Backup completed successfully!")
+// newLogger creates the application logger. Credentials from the configuration are
+// redacted wherever they appear, including rotated ones, and every record carries the
+// business unit.
+func newLogger(cfg config.Config) (*slog.Logger, error) {
+	secrets := &logging.SecretSet{}
+	secrets.Add(cfg.Secrets()...)
+	onSecretRotation(func(cfg config.Config) {
+		secrets.Add(cfg.Secrets()...)
+	})
+	logger, err := logging.New(os.Stderr, logging.Options{
+		Format:    cfg.LogFormat,
+		Level:     cfg.LogLevel,
+		SecretSet: secrets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BusinessUnit != "" {
+		logger = logger.With("bu", cfg.BusinessUnit)
+	}
+	return logger, nil
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func retentionPolicy(cfg config.Config) storage.RetentionPolicy {
+	return storage.RetentionPolicy{
+		KeepLast:    cfg.Retention.KeepLast,
+		KeepDaily:   cfg.Retention.KeepDaily,
+		KeepWeekly:  cfg.Retention.KeepWeekly,
+		KeepMonthly: cfg.Retention.KeepMonthly,
+	}
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments
+// and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/notify"
+)
+
+// newNotifier creates the notifiers of the configured webhooks and email. Webhooks and
+// per-run emails are filtered by NOTIFY_ON, while a digest collects every run and is
+// returned so that it can be sent on its own schedule. Both are nil when not configured.
+func newNotifier(cfg config.Config) (notify.Notifier, *notify.Digest) {
+	mode, err := notify.ParseMode(cfg.Notify.Mode)
+	if err != nil {
+		fatal("Invalid notification configuration", "error", err)
+	}
+	var notifiers notify.Multi
+	for _, webhook := range []struct {
+		url    string
+		format notify.Format
+	}{
+		{cfg.Notify.WebhookURL, notify.FormatJSON},
+		{cfg.Notify.SlackURL, notify.FormatSlack},
+		{cfg.Notify.TeamsURL, notify.FormatTeams},
+	} {
+		if webhook.url == "" {
+			continue
+		}
+		notifier, err := notify.NewWebhook(notify.WebhookOptions{
+			URL:      webhook.url,
+			Format:   webhook.format,
+			Template: cfg.Notify.Template,
+			Attempts: cfg.Notify.Attempts,
+		})
+		if err != nil {
+			fatal("Invalid notification configuration", "format", webhook.format, "error", err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	var digest *notify.Digest
+	if cfg.Notify.SMTPAddr != "" {
+		email, err := notify.NewEmail(notify.EmailOptions{
+			Addr:        cfg.Notify.SMTPAddr,
+			Username:    cfg.Notify.SMTPUser,
+			Password:    cfg.Notify.SMTPPass,
+			From:        cfg.Notify.SMTPFrom,
+			To:          cfg.Notify.SMTPTo,
+			TLS:         cfg.Notify.SMTPTLS,
+			FolderLinks: folderLinks(cfg),
+		})
+		if err != nil {
+			fatal("Invalid email configuration", "error", err)
+		}
+		onSecretRotation(func(cfg config.Config) {
+			email.SetPassword(cfg.Notify.SMTPPass)
+		})
+		if cfg.Notify.Digest {
+			if digest, err = notify.NewDigest(email, cfg.Notify.DigestFile); err != nil {
+				fatal("Failed to load email digest", "error", err)
+			}
+		} else {
+			notifiers = append(notifiers, email)
+		}
+	}
+
+	var notifier notify.Multi
+	if len(notifiers) > 0 {
+		notifier = append(notifier, notify.NewFilter(mode, notifiers))
+	}
+	if digest != nil {
+		notifier = append(notifier, digest)
+	}
+	if len(notifier) == 0 {
+		return nil, nil
+	}
+	return notifier, digest
+}
+
+// folderLinks returns NOTIFY_FOLDER_URL, or the location of the backup folder in each
+// destination
+func folderLinks(cfg config.Config) []string {
+	if cfg.Notify.FolderURL != "" {
+		return []string{cfg.Notify.FolderURL}
+	}
+	names := cfg.Destinations
+	if len(names) == 0 {
+		names = []string{defaultDestination(cfg)}
+	}
+	var links []string
+	for _, name := range names {
+		switch name {
+		case "local":
+			path, err := filepath.Abs(filepath.Join(cfg.StoragePath, "{{.Folder}}"))
+			if err == nil {
+				links = append(links, path)
+			}
+		case "s3":
+			links = append(links, fmt.Sprintf("s3://%s/{{.Folder}}/", cfg.S3Bucket))
+		case "sftp":
+			links = append(links, fmt.Sprintf("sftp://%s@%s/%s/{{.Folder}}/", cfg.SFTPUser, cfg.SFTPAddr, strings.Trim(cfg.SFTPBasePath, "/")))
+		}
+	}
+	return links
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+func runPrune(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the folders that would be deleted")
+	fs.Parse(args)
+
+	objectStores, _ := newStorage(cfg)
+	if len(objectStores) == 0 {
+		fatal("The selected storage does not support reading backups")
+	}
+
+	for _, objectStore := range objectStores {
+		pruner := storage.NewPruner(objectStore, retentionPolicy(cfg))
+
+		plan, err := pruner.Prune(context.Background(), *dryRun)
+		if err != nil {
+			fatal("Prune failed", "error", err)
+		}
+
+		for _, folder := range plan.Keep {
+			fmt.Printf("keep    %s (%s)\n", folder.Name, folder.Reason)
+		}
+		for _, folder := range plan.Delete {
+			fmt.Printf("delete  %s\n", folder)
+		}
+		for _, object := range plan.Objects {
+			fmt.Printf("delete  %s (unreferenced)\n", object)
+		}
+		for _, key := range plan.Locked {
+			fmt.Printf("locked  %s (retention or legal hold active)\n", key)
+		}
+	}
+	if *dryRun {
+		fmt.Println("Dry run: nothing was deleted")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+const restoreUsage = "Usage: restore <folder> | restore --at <RFC3339 time or YYYY-MM-DD>, each with [--apply | --export DIR] [--format text|json]"
+
+// runRestore restores the assets of a backup folder, or every asset as it was at a point
+// in time. Without --apply it only prints the plan.
+func runRestore(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	atFlag := fs.String("at", "", "restore the latest version of each asset modified at or before this time; a date means the end of that day in UTC")
+	apply := fs.Bool("apply", false, "write the plan to Marketing Cloud instead of only printing it")
+	exportDir := fs.String("export", "", "write the assets to this directory instead of Marketing Cloud")
+	format := fs.String("format", "text", "output format: text or json")
+	positional := parseArgs(fs, args)
+
+	if (*atFlag == "") == (len(positional) == 0) || len(positional) > 1 || *apply && *exportDir != "" {
+		fatal(restoreUsage)
+	}
+	if *format != "text" && *format != "json" {
+		fatal("Unknown format", "format", *format)
+	}
+
+	ctx := context.Background()
+	objectStore := newObjectStore(cfg)
+	var blocks []model.ContentBlock
+	var versions []storage.PointInTimeEntry
+	if *atFlag != "" {
+		at, err := parseRestoreTime(*atFlag)
+		if err != nil {
+			fatal("Invalid restore time", "at", *atFlag, "error", err)
+		}
+		if versions, err = storage.ResolvePointInTime(ctx, objectStore, at); err != nil {
+			fatal("Failed to resolve backups", "error", err)
+		}
+		if len(versions) == 0 {
+			fatal("No assets were backed up at or before the restore time", "at", at.Format(time.RFC3339))
+		}
+		if blocks, err = storage.LoadPointInTimeBlocks(ctx, objectStore, versions); err != nil {
+			fatal("Failed to load backup", "error", err)
+		}
+	} else {
+		var err error
+		if blocks, err = storage.LoadFolderBlocks(ctx, objectStore, positional[0]); err != nil {
+			fatal("Failed to load backup", "folder", positional[0], "error", err)
+		}
+	}
+
+	if *exportDir != "" {
+		exportRestore(cfg, *exportDir, blocks, versions)
+		return
+	}
+
+	restoreService := service.NewRestoreService(newContentClient(cfg))
+	results, err := restoreService.Plan(ctx, blocks)
+	if err == nil && *apply {
+		results, err = restoreService.Apply(ctx, blocks, results)
+	}
+	if *format == "json" {
+		writeJSON(results)
+	} else {
+		writeRestoreResults(results, *apply)
+	}
+	if err != nil {
+		fatal("Restore incomplete", "error", err)
+	}
+	if !*apply {
+		fmt.Fprintln(os.Stderr, "Dry run, use --apply to restore")
+	}
+}
+
+// parseRestoreTime accepts an RFC 3339 time or a date, which stands for the end of that
+// day in UTC so that changes made during the day are included
+func parseRestoreTime(s string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, s); err == nil {
+		return date.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// loadPointInTime returns the latest version of each asset modified at or before at
+func loadPointInTime(ctx context.Context, store storage.ObjectStore, at time.Time) ([]model.ContentBlock, error) {
+	versions, err := storage.ResolvePointInTime(ctx, store, at)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadPointInTimeBlocks(ctx, store, versions)
+}
+
+// exportRestore writes the blocks to dir with the configured layout. A point-in-time
+// export also lists the version of each asset and its folder in versions.json.
+func exportRestore(cfg config.Config, dir string, blocks []model.ContentBlock, versions []storage.PointInTimeEntry) {
+	layout, err := storage.NewLayout(cfg.Layout)
+	if err != nil {
+		fatal("Invalid storage layout", "error", err)
+	}
+	if err := storage.ExportBlocks(dir, layout, blocks); err != nil {
+		fatal("Failed to export assets", "error", err)
+	}
+	if versions != nil {
+		data, err := json.MarshalIndent(versions, "", "  ")
+		if err != nil {
+			fatal("Failed to encode versions", "error", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "versions.json"), data, 0644); err != nil {
+			fatal("Failed to export assets", "error", err)
+		}
+	}
+	fmt.Printf("Exported %d assets to %s\n", len(blocks), dir)
+}
+
+func writeRestoreResults(results []service.RestoreResult, applied bool) {
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tID\tNAME\tRESULT")
+	for _, r := range results {
+		result := "-"
+		switch {
+		case applied && r.Error == "" && r.Action != service.RestoreSkip && r.NewID == 0:
+			result = "restored"
+		case r.Error != "":
+			result = "error: " + r.Error
+		case r.NewID != 0:
+			result = fmt.Sprintf("new ID %d", r.NewID)
+		}
+		counts[r.Action]++
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.Action, r.AssetID, r.Name, result)
+	}
+	w.Flush()
+	fmt.Printf("create: %d, update: %d, skip: %d\n",
+		counts[service.RestoreCreate], counts[service.RestoreUpdate], counts[service.RestoreSkip])
+}
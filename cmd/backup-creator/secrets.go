@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+)
+
+// secretRotations are called with the reloaded configuration when its secrets changed
+var secretRotations []func(cfg config.Config)
+
+// onSecretRotation registers fn to hand rotated secrets to the client that uses them
+func onSecretRotation(fn func(cfg config.Config)) {
+	secretRotations = append(secretRotations, fn)
+}
+
+// reloadSecrets reloads the configuration every SECRETS_REFRESH until ctx is done, so that
+// secrets rotated in their files or secret stores are used without a restart. A failed
+// reload keeps the current secrets.
+func reloadSecrets(ctx context.Context, configFile string, cfg config.Config) {
+	if cfg.SecretStores.Refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.SecretStores.Refresh)
+	defer ticker.Stop()
+
+	current := cfg.Secrets()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := config.Load(configFile)
+		if err != nil {
+			slog.Error("Failed to reload secrets, keeping the current ones", "error", err)
+			continue
+		}
+		if slices.Equal(reloaded.Secrets(), current) {
+			continue
+		}
+		current = reloaded.Secrets()
+		for _, rotate := range secretRotations {
+			rotate(reloaded)
+		}
+		slog.Info("Rotated secrets reloaded")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/api"
+	"github.com/Feride3d/backup-creator/internal/client"
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/health"
+	"github.com/Feride3d/backup-creator/internal/metrics"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/scheduler"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+// readinessProbeKey is looked up in each store to check that it is reachable
+const readinessProbeKey = ".readyz"
+
+// newMux routes the operational endpoints: metrics, liveness, readiness and status, and
+// the control API when API_TOKEN is set
+func newMux(cfg config.Config, s *scheduler.Scheduler, contentClient *client.ContentClient, objectStores []storage.ObjectStore) *http.ServeMux {
+	liveness := health.NewChecker(5 * time.Second)
+	liveness.Add("scheduler", func(ctx context.Context) error {
+		if !s.Running() {
+			return errors.New("cron scheduler is not running")
+		}
+		return nil
+	})
+
+	readiness := health.NewChecker(10 * time.Second)
+	readiness.Add("config", func(ctx context.Context) error {
+		return cfg.Validate()
+	})
+	readiness.Add("token", func(ctx context.Context) error {
+		return contentClient.EnsureTokenValid()
+	})
+	readiness.Add("storage", func(ctx context.Context) error {
+		for _, store := range objectStores {
+			if _, err := store.ObjectExists(ctx, readinessProbeKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.Handle("GET /healthz", liveness.Handler())
+	mux.Handle("GET /readyz", readiness.Handler())
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		health.WriteJSON(w, http.StatusOK, s.Status())
+	})
+	registerAPI(mux, cfg, s, contentClient, objectStores)
+	return mux
+}
+
+// registerAPI routes the control API, which restores from the first destination that
+// can be read back
+func registerAPI(mux *http.ServeMux, cfg config.Config, s *scheduler.Scheduler, contentClient *client.ContentClient, objectStores []storage.ObjectStore) {
+	if cfg.APIToken == "" {
+		slog.Info("Control API disabled, set API_TOKEN to enable it")
+		return
+	}
+	opts := api.Options{
+		Token:         cfg.APIToken,
+		BusinessUnit:  cfg.BusinessUnit,
+		Runner:        s,
+		Restorer:      service.NewRestoreService(contentClient),
+		FullSnapshots: cfg.Dedup,
+	}
+	if len(objectStores) > 0 {
+		opts.LoadFolder = func(ctx context.Context, folder string) ([]model.ContentBlock, error) {
+			return storage.LoadFolderBlocks(ctx, objectStores[0], folder)
+		}
+		opts.LoadPointInTime = func(ctx context.Context, at time.Time) ([]model.ContentBlock, error) {
+			return loadPointInTime(ctx, objectStores[0], at)
+		}
+	}
+	controlAPI := api.New(opts)
+	onSecretRotation(func(cfg config.Config) {
+		controlAPI.SetToken(cfg.APIToken)
+	})
+	controlAPI.Register(mux)
+}
+
+// startHTTPServer serves handler in the background
+func startHTTPServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("HTTP server listening", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "error", err)
+		}
+	}()
+	return server
+}
+
+// stopHTTPServer waits up to 10 seconds for in-flight requests to complete
+func stopHTTPServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down HTTP server", "error", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Feride3d/backup-creator/internal/config"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+// newStorage creates the storage destinations selected by the configuration and returns
+// the ones that can be read back together with the storage backups are written to.
+// Several destinations are combined into a fan-out storage.
+func newStorage(cfg config.Config) ([]storage.ObjectStore, service.Storage) {
+	names := destinationNames(cfg)
+
+	var objectStores []storage.ObjectStore
+	var destinations []storage.Destination
+	for _, name := range names {
+		objectStore, destination := newDestination(cfg, name)
+		if objectStore != nil {
+			objectStores = append(objectStores, objectStore)
+		}
+		destinations = append(destinations, storage.Destination{Name: name, Storage: destination})
+	}
+	if len(destinations) == 1 {
+		return objectStores, destinations[0].Storage
+	}
+
+	policy, err := storage.ParseFanOutPolicy(cfg.FanOutPolicy)
+	if err != nil {
+		fatal("Invalid fan-out policy", "error", err)
+	}
+	return objectStores, storage.NewFanOutStorage(policy, destinations...)
+}
+
+// destinationNames returns the configured destinations, or the default one
+func destinationNames(cfg config.Config) []string {
+	if len(cfg.Destinations) == 0 {
+		return []string{defaultDestination(cfg)}
+	}
+	return cfg.Destinations
+}
+
+// defaultDestination picks a single destination: a git repository when GIT_REPO_PATH is
+// set, S3 when S3_BUCKET is set, SFTP when SFTP_ADDR is set, the local file system otherwise
+func defaultDestination(cfg config.Config) string {
+	switch {
+	case cfg.GitRepoPath != "":
+		return "git"
+	case cfg.S3Bucket != "":
+		return "s3"
+	case cfg.SFTPAddr != "":
+		return "sftp"
+	default:
+		return "local"
+	}
+}
+
+// newDestination creates a single destination. The git storage cannot be read back,
+// so no ObjectStore is returned for it.
+func newDestination(cfg config.Config, name string) (storage.ObjectStore, service.Storage) {
+	if name == "git" {
+		gitStorage, err := storage.NewGitStorage(context.Background(), cfg.GitRepoPath, storage.GitOptions{
+			Remote: cfg.GitRemote,
+			Branch: cfg.GitBranch,
+		})
+		if err != nil {
+			fatal("Failed to create git storage", "error", err)
+		}
+		return nil, gitStorage
+	}
+
+	layout, err := storage.NewLayout(cfg.Layout)
+	if err != nil {
+		fatal("Invalid storage layout", "error", err)
+	}
+
+	var objectStore storage.ObjectStore
+	var selectedStorage service.Storage
+	switch name {
+	case "s3":
+		s3Storage, err := storage.NewS3Storage(cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+		if err != nil {
+			fatal("Failed to create S3 storage", "error", err)
+		}
+		onSecretRotation(func(cfg config.Config) {
+			s3Storage.SetCredentials(cfg.S3AccessKey, cfg.S3SecretKey)
+		})
+		s3Storage.Layout = layout
+		s3Storage.Options = storage.S3Options{
+			ServerSideEncryption: cfg.S3SSE,
+			KMSKeyID:             cfg.S3KMSKeyID,
+			StorageClass:         cfg.S3Class,
+			ObjectLockMode:       cfg.S3LockMode,
+			ObjectLockRetention:  cfg.S3LockPeriod,
+			LegalHold:            cfg.S3LegalHold,
+		}
+		if err := s3Storage.Options.Validate(); err != nil {
+			fatal("Invalid S3 options", "error", err)
+		}
+		if s3Storage.Options.ObjectLockEnabled() {
+			if err := s3Storage.CheckObjectLock(context.Background()); err != nil {
+				fatal("S3 object lock check failed", "error", err)
+			}
+		}
+		objectStore, selectedStorage = s3Storage, s3Storage
+	case "sftp":
+		privateKey, err := os.ReadFile(cfg.SFTPKeyFile)
+		if err != nil {
+			fatal("Failed to read SFTP private key", "error", err)
+		}
+		knownHosts := cfg.SFTPHostKeys
+		if knownHosts == "" {
+			home, _ := os.UserHomeDir()
+			knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		sftpStorage, err := storage.NewSFTPStorage(context.Background(), storage.SFTPOptions{
+			Addr:           cfg.SFTPAddr,
+			User:           cfg.SFTPUser,
+			PrivateKey:     privateKey,
+			Passphrase:     []byte(cfg.SFTPKeyPass),
+			KnownHostsFile: knownHosts,
+			BasePath:       cfg.SFTPBasePath,
+			PoolSize:       cfg.SFTPPoolSize,
+		})
+		if err != nil {
+			fatal("Failed to create SFTP storage", "error", err)
+		}
+		sftpStorage.SetLayout(layout)
+		objectStore, selectedStorage = sftpStorage, sftpStorage
+	case "local":
+		localStorage := storage.NewLocalStorage(cfg.StoragePath)
+		localStorage.SetLayout(layout)
+		localStorage.SetPermissions(cfg.FileMode.Perm(), cfg.DirMode.Perm())
+		objectStore, selectedStorage = localStorage, localStorage
+	default:
+		fatal("Unknown storage destination, expected one of: local, s3, sftp, git", "destination", name)
+	}
+
+	if cfg.Dedup {
+		selectedStorage = storage.NewDedupStorage(objectStore)
+	}
+	return objectStore, selectedStorage
+}
+
+// newObjectStore returns the first readable destination for commands that read existing backups
+func newObjectStore(cfg config.Config) storage.ObjectStore {
+	objectStores, _ := newStorage(cfg)
+	if len(objectStores) == 0 {
+		fatal("The selected storage does not support reading backups")
+	}
+	return objectStores[0]
+}
+
+// sweepStaging cleans up the staging directories of local runs that were interrupted
+func sweepStaging(cfg config.Config, objectStores []storage.ObjectStore) {
+	if cfg.StagingSweep == "off" {
+		return
+	}
+	for _, objectStore := range objectStores {
+		localStorage, ok := objectStore.(*storage.LocalStorage)
+		if !ok {
+			continue
+		}
+		swept, err := localStorage.SweepStaging(cfg.StagingAge, cfg.StagingSweep == "quarantine")
+		if err != nil {
+			slog.Error("Failed to sweep abandoned staging directories", "error", err)
+			continue
+		}
+		for _, run := range swept {
+			slog.Info("Swept abandoned staging directory", "run", run, "mode", cfg.StagingSweep)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Package api serves the control API to trigger, inspect and cancel backup runs and to
+// restore assets from a backup
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/health"
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/scheduler"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+// maxBodySize limits the size of request bodies
+const maxBodySize = 1 << 20
+
+// Runner starts and tracks backup runs
+type Runner interface {
+	TriggerBackup(opts scheduler.RunOptions) (string, error)
+	Runs() []scheduler.RunInfo
+	LookupRun(runID string) (scheduler.RunInfo, *model.RunReport, error)
+	CancelRun(runID string) error
+	RunExclusive(fn func() error) error
+}
+
+// Restorer writes backed up assets back to Marketing Cloud
+type Restorer interface {
+	Restore(ctx context.Context, blocks []model.ContentBlock, dryRun bool) ([]service.RestoreResult, error)
+}
+
+// FolderLoader returns the content blocks stored in a backup folder
+type FolderLoader func(ctx context.Context, folder string) ([]model.ContentBlock, error)
+
+// PointInTimeLoader returns the latest version of each asset modified at or before a time
+type PointInTimeLoader func(ctx context.Context, at time.Time) ([]model.ContentBlock, error)
+
+// Options configures the API
+type Options struct {
+	// Token is the bearer token every request must present
+	Token string
+	// BusinessUnit is the business unit this instance backs up
+	BusinessUnit string
+	Runner       Runner
+	Restorer     Restorer
+	LoadFolder   FolderLoader
+	// LoadPointInTime is optional, without it restores only accept a folder
+	LoadPointInTime PointInTimeLoader
+	// FullSnapshots accepts full runs, which only deduplicated storage records as snapshots
+	FullSnapshots bool
+}
+
+// API serves the control endpoints
+type API struct {
+	opts  Options
+	mu    sync.RWMutex
+	token string
+}
+
+func New(opts Options) *API {
+	return &API{opts: opts, token: opts.Token}
+}
+
+// SetToken replaces the bearer token, e.g. after it was rotated
+func (a *API) SetToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = token
+}
+
+// Register routes the API endpoints on mux
+func (a *API) Register(mux *http.ServeMux) {
+	mux.Handle("POST /runs", a.authenticate(a.startRun))
+	mux.Handle("GET /runs", a.authenticate(a.listRuns))
+	mux.Handle("GET /runs/{id}", a.authenticate(a.getRun))
+	mux.Handle("DELETE /runs/{id}", a.authenticate(a.cancelRun))
+	mux.Handle("POST /restore", a.authenticate(a.restore))
+}
+
+// authenticate rejects requests without the bearer token
+func (a *API) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		token := a.token
+		a.mu.RUnlock()
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="backup-creator"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RunRequest overrides what a triggered run backs up. All fields are optional.
+type RunRequest struct {
+	// Since fetches changes from this time instead of the checkpoint
+	Since *time.Time `json:"since,omitempty"`
+	// BusinessUnit must be the business unit this instance backs up
+	BusinessUnit string `json:"bu,omitempty"`
+	// AssetTypes only fetches assets of these types
+	AssetTypes []string `json:"assetTypes,omitempty"`
+	// Full takes a full snapshot of every asset
+	Full bool `json:"full,omitempty"`
+}
+
+// RunResponse is a run with its report
+type RunResponse struct {
+	scheduler.RunInfo
+	Report *model.RunReport `json:"report,omitempty"`
+}
+
+func (a *API) startRun(w http.ResponseWriter, r *http.Request) {
+	var req RunRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.BusinessUnit != "" && req.BusinessUnit != a.opts.BusinessUnit {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("business unit %q is not backed up by this instance", req.BusinessUnit))
+		return
+	}
+	if req.Since != nil && req.Since.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, errors.New("since is in the future"))
+		return
+	}
+	if req.Full && (req.Since != nil || len(req.AssetTypes) > 0) {
+		writeError(w, http.StatusBadRequest, errors.New("a full snapshot cannot be limited by since or assetTypes"))
+		return
+	}
+	if req.Full && !a.opts.FullSnapshots {
+		writeError(w, http.StatusBadRequest, errors.New("full snapshots require STORAGE_DEDUP"))
+		return
+	}
+
+	runID, err := a.opts.Runner.TriggerBackup(scheduler.RunOptions{Since: req.Since, AssetTypes: req.AssetTypes, Full: req.Full})
+	if errors.Is(err, scheduler.ErrRunInProgress) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logging.FromContext(r.Context()).InfoContext(r.Context(), "Backup triggered through the API", "run_id", runID)
+	info, _, err := a.opts.Runner.LookupRun(runID)
+	if err != nil {
+		info = scheduler.RunInfo{RunID: runID, State: scheduler.StateRunning}
+	}
+	w.Header().Set("Location", "/runs/"+runID)
+	health.WriteJSON(w, http.StatusAccepted, info)
+}
+
+func (a *API) listRuns(w http.ResponseWriter, r *http.Request) {
+	health.WriteJSON(w, http.StatusOK, map[string]interface{}{"runs": a.opts.Runner.Runs()})
+}
+
+func (a *API) getRun(w http.ResponseWriter, r *http.Request) {
+	info, report, err := a.opts.Runner.LookupRun(r.PathValue("id"))
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+	health.WriteJSON(w, http.StatusOK, RunResponse{RunInfo: info, Report: report})
+}
+
+func (a *API) cancelRun(w http.ResponseWriter, r *http.Request) {
+	runID := r.PathValue("id")
+	if err := a.opts.Runner.CancelRun(runID); err != nil {
+		writeRunError(w, err)
+		return
+	}
+	logging.FromContext(r.Context()).InfoContext(r.Context(), "Backup cancelled through the API", "run_id", runID)
+	info, _, err := a.opts.Runner.LookupRun(runID)
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+	health.WriteJSON(w, http.StatusAccepted, info)
+}
+
+// RestoreRequest selects the assets to restore from a backup folder or as they were at a
+// point in time
+type RestoreRequest struct {
+	Folder string     `json:"folder,omitempty"`
+	At     *time.Time `json:"at,omitempty"`
+	// AssetIDs restores only these assets instead of all of them
+	AssetIDs []int `json:"assetIds,omitempty"`
+	// DryRun reports what would be restored without changing Marketing Cloud
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// RestoreResponse is the outcome of a restore
+type RestoreResponse struct {
+	Folder  string                  `json:"folder,omitempty"`
+	At      *time.Time              `json:"at,omitempty"`
+	DryRun  bool                    `json:"dryRun"`
+	Results []service.RestoreResult `json:"results"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+func (a *API) restore(w http.ResponseWriter, r *http.Request) {
+	var req RestoreRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := r.Context()
+	source, empty := req.Folder, req.Folder+" holds no assets"
+	load := func() ([]model.ContentBlock, error) { return a.opts.LoadFolder(ctx, req.Folder) }
+	available := a.opts.LoadFolder != nil
+	switch {
+	case req.At != nil && req.Folder != "":
+		writeError(w, http.StatusBadRequest, errors.New("folder and at are mutually exclusive"))
+		return
+	case req.At != nil && req.At.After(time.Now()):
+		writeError(w, http.StatusBadRequest, errors.New("at must not be in the future"))
+		return
+	case req.At != nil:
+		source = "the backups at " + req.At.UTC().Format(time.RFC3339)
+		empty = "no assets were backed up at or before " + req.At.UTC().Format(time.RFC3339)
+		load = func() ([]model.ContentBlock, error) { return a.opts.LoadPointInTime(ctx, *req.At) }
+		available = a.opts.LoadPointInTime != nil
+	case !validFolder(req.Folder):
+		writeError(w, http.StatusBadRequest, fmt.Errorf("folder must be the name of a backup folder, e.g. %s", storage.FolderName(time.Now())))
+		return
+	}
+	if a.opts.Restorer == nil || !available {
+		writeError(w, http.StatusNotImplemented, errors.New("restore is not available with the configured destinations"))
+		return
+	}
+
+	response := RestoreResponse{Folder: req.Folder, At: req.At, DryRun: req.DryRun}
+	status := http.StatusOK
+	err := a.opts.Runner.RunExclusive(func() error {
+		blocks, err := load()
+		if err != nil {
+			status = http.StatusInternalServerError
+			return fmt.Errorf("failed to load %s: %v", source, err)
+		}
+		if blocks, err = selectBlocks(blocks, req.AssetIDs, source); err != nil {
+			status = http.StatusNotFound
+			return err
+		}
+		if len(blocks) == 0 {
+			status = http.StatusNotFound
+			return errors.New(empty)
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "Restoring assets through the API", "source", source, "count", len(blocks), "dry_run", req.DryRun)
+		response.Results, err = a.opts.Restorer.Restore(ctx, blocks, req.DryRun)
+		if err != nil {
+			status = http.StatusBadGateway
+		}
+		return err
+	})
+	if errors.Is(err, scheduler.ErrRunInProgress) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil && response.Results == nil {
+		writeError(w, status, err)
+		return
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	health.WriteJSON(w, status, response)
+}
+
+// validFolder reports whether folder names a backup folder rather than an arbitrary path
+func validFolder(folder string) bool {
+	return strings.HasPrefix(folder, storage.FolderPrefix) && !strings.ContainsAny(folder, `/\`)
+}
+
+// selectBlocks returns the blocks with the given IDs, or all blocks when ids is empty
+func selectBlocks(blocks []model.ContentBlock, ids []int, source string) ([]model.ContentBlock, error) {
+	if len(ids) == 0 {
+		return blocks, nil
+	}
+	byID := make(map[int]model.ContentBlock, len(blocks))
+	for _, b := range blocks {
+		byID[b.ID] = b
+	}
+	selected := make([]model.ContentBlock, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		block, ok := byID[id]
+		if !ok {
+			missing = append(missing, fmt.Sprint(id))
+			continue
+		}
+		selected = append(selected, block)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("assets not found in %s: %s", source, strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
+
+// decodeBody decodes an optional JSON body into v, rejecting unknown fields
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeRunError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrRunNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, scheduler.ErrRunFinished):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	health.WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/scheduler"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRunner tracks runs in memory. A run is busy until it is cancelled.
+type fakeRunner struct {
+	runs      map[string]*scheduler.RunInfo
+	triggered []scheduler.RunOptions
+	busy      bool
+}
+
+func (f *fakeRunner) TriggerBackup(opts scheduler.RunOptions) (string, error) {
+	if f.busy {
+		return "", scheduler.ErrRunInProgress
+	}
+	f.busy = true
+	f.triggered = append(f.triggered, opts)
+	runID := "run-1"
+	f.runs[runID] = &scheduler.RunInfo{RunID: runID, Trigger: scheduler.TriggerAPI, State: scheduler.StateRunning, Options: opts}
+	return runID, nil
+}
+
+func (f *fakeRunner) Runs() []scheduler.RunInfo {
+	var runs []scheduler.RunInfo
+	for _, info := range f.runs {
+		runs = append(runs, *info)
+	}
+	return runs
+}
+
+func (f *fakeRunner) LookupRun(runID string) (scheduler.RunInfo, *model.RunReport, error) {
+	info, ok := f.runs[runID]
+	if !ok {
+		return scheduler.RunInfo{}, nil, scheduler.ErrRunNotFound
+	}
+	return *info, &model.RunReport{RunID: runID}, nil
+}
+
+func (f *fakeRunner) CancelRun(runID string) error {
+	info, ok := f.runs[runID]
+	if !ok {
+		return scheduler.ErrRunNotFound
+	}
+	if info.State != scheduler.StateRunning {
+		return scheduler.ErrRunFinished
+	}
+	info.State = scheduler.StateCancelled
+	f.busy = false
+	return nil
+}
+
+func (f *fakeRunner) RunExclusive(fn func() error) error {
+	if f.busy {
+		return scheduler.ErrRunInProgress
+	}
+	return fn()
+}
+
+// fakeRestorer records the blocks it restores and fails for assets named "broken"
+type fakeRestorer struct {
+	restored []model.ContentBlock
+}
+
+func (f *fakeRestorer) Restore(ctx context.Context, blocks []model.ContentBlock, dryRun bool) ([]service.RestoreResult, error) {
+	var results []service.RestoreResult
+	var err error
+	for _, b := range blocks {
+		result := service.RestoreResult{AssetID: b.ID, Name: b.Name, Action: service.RestoreUpdate}
+		if b.Name == "broken" {
+			result.Error = "invalid asset"
+			err = errors.New("1 of 1 assets failed to restore")
+		} else if !dryRun {
+			f.restored = append(f.restored, b)
+		}
+		results = append(results, result)
+	}
+	return results, err
+}
+
+func newTestAPI() (*API, *fakeRunner, *fakeRestorer, *http.ServeMux) {
+	runner := &fakeRunner{runs: make(map[string]*scheduler.RunInfo)}
+	restorer := &fakeRestorer{}
+	api := New(Options{
+		Token:        "s3cr3t",
+		BusinessUnit: "123",
+		Runner:       runner,
+		Restorer:     restorer,
+		LoadFolder: func(ctx context.Context, folder string) ([]model.ContentBlock, error) {
+			switch folder {
+			case "backup_20241121":
+				return []model.ContentBlock{{ID: 1, Name: "Welcome"}, {ID: 2, Name: "Footer"}, {ID: 3, Name: "broken"}}, nil
+			case "backup_20241122":
+				return nil, nil
+			}
+			return nil, errors.New("storage unreachable")
+		},
+		LoadPointInTime: func(ctx context.Context, at time.Time) ([]model.ContentBlock, error) {
+			if at.Before(time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)) {
+				return nil, nil
+			}
+			return []model.ContentBlock{{ID: 1, Name: "Welcome"}, {ID: 5, Name: "Header"}}, nil
+		},
+	})
+	mux := http.NewServeMux()
+	api.Register(mux)
+	return api, runner, restorer, mux
+}
+
+func do(mux http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPI_Authentication(t *testing.T) {
+	api, _, _, mux := newTestAPI()
+
+	for _, token := range []string{"", "wrong"} {
+		rec := do(mux, "GET", "/runs", token, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Bearer realm="backup-creator"`, rec.Header().Get("WWW-Authenticate"))
+		assert.JSONEq(t, `{"error":"missing or invalid bearer token"}`, rec.Body.String())
+	}
+	assert.Equal(t, http.StatusOK, do(mux, "GET", "/runs", "s3cr3t", "").Code)
+
+	api.SetToken("rotated")
+	assert.Equal(t, http.StatusUnauthorized, do(mux, "GET", "/runs", "s3cr3t", "").Code)
+	assert.Equal(t, http.StatusOK, do(mux, "GET", "/runs", "rotated", "").Code)
+}
+
+func TestAPI_Runs(t *testing.T) {
+	_, runner, _, mux := newTestAPI()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		error  string
+	}{
+		{name: "Unknown business unit", method: "POST", path: "/runs", body: `{"bu":"456"}`, status: http.StatusBadRequest, error: `business unit "456" is not backed up by this instance`},
+		{name: "Future since", method: "POST", path: "/runs", body: `{"since":"2999-01-01T00:00:00Z"}`, status: http.StatusBadRequest, error: "since is in the future"},
+		{name: "Limited full snapshot", method: "POST", path: "/runs", body: `{"full":true,"assetTypes":["htmlemail"]}`, status: http.StatusBadRequest, error: "a full snapshot cannot be limited by since or assetTypes"},
+		{name: "Full snapshot without dedup", method: "POST", path: "/runs", body: `{"full":true}`, status: http.StatusBadRequest, error: "full snapshots require STORAGE_DEDUP"},
+		{name: "Unknown field", method: "POST", path: "/runs", body: `{"until":"2024-11-21T00:00:00Z"}`, status: http.StatusBadRequest, error: `invalid request body: json: unknown field "until"`},
+		{name: "Start", method: "POST", path: "/runs", body: `{"since":"2024-11-21T00:00:00Z","bu":"123","assetTypes":["htmlemail"]}`, status: http.StatusAccepted},
+		{name: "Overlap", method: "POST", path: "/runs", status: http.StatusConflict, error: "a run is already in progress"},
+		{name: "Get", method: "GET", path: "/runs/run-1", status: http.StatusOK},
+		{name: "Get unknown", method: "GET", path: "/runs/run-2", status: http.StatusNotFound, error: "run not found"},
+		{name: "Cancel", method: "DELETE", path: "/runs/run-1", status: http.StatusAccepted},
+		{name: "Cancel finished", method: "DELETE", path: "/runs/run-1", status: http.StatusConflict, error: "run has already finished"},
+		{name: "Cancel unknown", method: "DELETE", path: "/runs/run-2", status: http.StatusNotFound, error: "run not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(mux, tt.method, tt.path, "s3cr3t", tt.body)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.error != "" {
+				assert.JSONEq(t, `{"error":`+jsonString(tt.error)+`}`, rec.Body.String())
+			}
+		})
+	}
+
+	since := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	require.Len(t, runner.triggered, 1)
+	assert.Equal(t, scheduler.RunOptions{Since: &since, AssetTypes: []string{"htmlemail"}}, runner.triggered[0])
+
+	var run RunResponse
+	require.NoError(t, json.Unmarshal(do(mux, "GET", "/runs/run-1", "s3cr3t", "").Body.Bytes(), &run))
+	assert.Equal(t, scheduler.StateCancelled, run.State)
+	assert.Equal(t, "run-1", run.Report.RunID)
+
+	var list struct {
+		Runs []scheduler.RunInfo `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(do(mux, "GET", "/runs", "s3cr3t", "").Body.Bytes(), &list))
+	assert.Len(t, list.Runs, 1)
+
+	// a run without a body backs up the changes since the checkpoint
+	rec := do(mux, "POST", "/runs", "s3cr3t", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "/runs/run-1", rec.Header().Get("Location"))
+	assert.Equal(t, scheduler.RunOptions{}, runner.triggered[1])
+}
+
+func TestAPI_Restore(t *testing.T) {
+	_, runner, restorer, mux := newTestAPI()
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		error    string
+		restored []int
+	}{
+		{name: "No folder", body: `{}`, status: http.StatusBadRequest, error: "folder must be the name of a backup folder"},
+		{name: "Path", body: `{"folder":"backup_20241121/../../etc"}`, status: http.StatusBadRequest, error: "folder must be the name of a backup folder"},
+		{name: "Storage error", body: `{"folder":"backup_20241120"}`, status: http.StatusInternalServerError, error: "failed to load backup_20241120: storage unreachable"},
+		{name: "Empty folder", body: `{"folder":"backup_20241122"}`, status: http.StatusNotFound, error: "backup_20241122 holds no assets"},
+		{name: "Missing asset", body: `{"folder":"backup_20241121","assetIds":[1,7]}`, status: http.StatusNotFound, error: "assets not found in backup_20241121: 7"},
+		{name: "Dry run", body: `{"folder":"backup_20241121","assetIds":[1],"dryRun":true}`, status: http.StatusOK},
+		{name: "Selected assets", body: `{"folder":"backup_20241121","assetIds":[2,1]}`, status: http.StatusOK, restored: []int{2, 1}},
+		{name: "Failed asset", body: `{"folder":"backup_20241121","assetIds":[3]}`, status: http.StatusBadGateway},
+		{name: "Folder and time", body: `{"folder":"backup_20241121","at":"2024-11-21T12:00:00Z"}`, status: http.StatusBadRequest, error: "folder and at are mutually exclusive"},
+		{name: "Future time", body: `{"at":"2999-01-01T00:00:00Z"}`, status: http.StatusBadRequest, error: "at must not be in the future"},
+		{name: "Nothing backed up", body: `{"at":"2024-11-20T12:00:00Z"}`, status: http.StatusNotFound, error: "no assets were backed up at or before 2024-11-20T12:00:00Z"},
+		{name: "Point in time", body: `{"at":"2024-11-21T12:00:00Z","assetIds":[5]}`, status: http.StatusOK, restored: []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restorer.restored = nil
+			rec := do(mux, "POST", "/restore", "s3cr3t", tt.body)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.error != "" {
+				assert.Contains(t, rec.Body.String(), tt.error)
+				return
+			}
+			var response RestoreResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			if response.At == nil {
+				assert.Equal(t, "backup_20241121", response.Folder)
+			}
+			var restored []int
+			for _, b := range restorer.restored {
+				restored = append(restored, b.ID)
+			}
+			assert.Equal(t, tt.restored, restored)
+		})
+	}
+
+	restorer.restored = nil
+	var response RestoreResponse
+	rec := do(mux, "POST", "/restore", "s3cr3t", `{"folder":"backup_20241121","assetIds":[3]}`)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "1 of 1 assets failed to restore", response.Error)
+	assert.Equal(t, "invalid asset", response.Results[0].Error)
+
+	// restores wait for the running backup
+	runner.busy = true
+	rec = do(mux, "POST", "/restore", "s3cr3t", `{"folder":"backup_20241121"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Nil(t, restorer.restored)
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
 )
 
@@ -15,31 +17,44 @@ type AuthClient struct {
 	authURL      string
 	clientID     string
 	clientSecret string
+	mu           sync.Mutex
 }
 
 func NewAuthClient(authURL, clientID, clientSecret string) *AuthClient {
-	return &AuthClient{authURL, clientID, clientSecret}
+	return &AuthClient{authURL: authURL, clientID: clientID, clientSecret: clientSecret}
+}
+
+// SetClientSecret replaces a rotated client secret, used from the next token request on
+func (a *AuthClient) SetClientSecret(clientSecret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientSecret = clientSecret
 }
 
 func (a *AuthClient) GetAccessToken() (model.Token, error) {
 	if _, err := url.ParseRequestURI(a.authURL); err != nil {
 		return model.Token{}, fmt.Errorf("invalid auth URL: %v", err)
 	}
+	a.mu.Lock()
 	payload := map[string]string{
 		"grant_type":    "client_credentials",
 		"client_id":     a.clientID,
 		"client_secret": a.clientSecret,
 	}
+	a.mu.Unlock()
 
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return model.Token{}, fmt.Errorf("failed to marshal payload: %v", err)
 	}
+	start := time.Now()
 	resp, err := http.Post(a.authURL, "application/json", bytes.NewBuffer(jsonPayload))
 	if err != nil {
+		metrics.ObserveRequest("token", 0, start)
 		return model.Token{}, fmt.Errorf("failed to send token request: %v", err)
 	}
 	defer resp.Body.Close()
+	metrics.ObserveRequest("token", resp.StatusCode, start)
 
 	if resp.StatusCode != http.StatusOK {
 		var apiError struct {
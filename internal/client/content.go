@@ -4,14 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	categoryPageSize  = 500
+	assetListPageSize = 500
+	queryPageSize     = 50
 )
 
 type AuthProvider interface {
@@ -22,21 +34,94 @@ type ContentClient struct {
 	apiURL     string
 	token      *model.Token
 	authClient AuthProvider
+	httpClient *http.Client
+	mu         sync.Mutex
 }
 
 func NewContentClient(apiURL string, token *model.Token, authClient AuthProvider) *ContentClient {
-	return &ContentClient{apiURL: apiURL, token: token, authClient: authClient}
+	return &ContentClient{
+		apiURL:     apiURL,
+		token:      token,
+		authClient: authClient,
+		httpClient: &http.Client{Transport: tracing.Transport(http.DefaultTransport)},
+	}
+}
+
+// SetHTTPClient changes the HTTP client used for API requests, e.g. to add instrumentation
+func (c *ContentClient) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
 }
 
 func (c *ContentClient) EnsureTokenValid() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.token.IsExpired() {
-		newToken, err := c.authClient.GetAccessToken()
+		return c.refreshToken(context.Background())
+	}
+	return nil
+}
+
+// refreshToken replaces the access token. c.mu must be held.
+func (c *ContentClient) refreshToken(ctx context.Context) (err error) {
+	_, span := tracing.Start(ctx, "sfmc.token_refresh")
+	defer func() { tracing.End(span, err) }()
+
+	newToken, err := c.authClient.GetAccessToken()
+	if err != nil {
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	c.token = &newToken
+	metrics.TokenRefreshes.Inc()
+	model.RunReportFromContext(ctx).AddTokenRefresh()
+	return nil
+}
+
+// accessToken returns a valid access token. A token the API rejected is replaced unless
+// another request already did so.
+func (c *ContentClient) accessToken(ctx context.Context, rejected string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authClient != nil && (c.token.IsExpired() || rejected != "" && c.token.AccessToken == rejected) {
+		if err := c.refreshToken(ctx); err != nil {
+			return "", err
+		}
+	}
+	return c.token.AccessToken, nil
+}
+
+// do sends the request built by newRequest with the current access token. A request
+// rejected with 401 Unauthorized is retried once with a new token.
+func (c *ContentClient) do(ctx context.Context, endpoint string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var rejected string
+	for {
+		token, err := c.accessToken(ctx, rejected)
+		if err != nil {
+			return nil, err
+		}
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		start := time.Now()
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return fmt.Errorf("failed to refresh token: %w", err)
+			metrics.ObserveRequest(endpoint, 0, start)
+			return nil, fmt.Errorf("failed to send request: %v", err)
+		}
+		metrics.ObserveRequest(endpoint, resp.StatusCode, start)
+
+		if resp.StatusCode == http.StatusUnauthorized && rejected == "" && c.authClient != nil {
+			resp.Body.Close()
+			rejected = token
+			metrics.Retries.WithLabelValues(endpoint).Inc()
+			model.RunReportFromContext(ctx).AddRetry(endpoint)
+			logging.FromContext(ctx).WarnContext(ctx, "Access token rejected, retrying with a new token", "endpoint", endpoint)
+			continue
 		}
-		c.token = &newToken
+		return resp, nil
 	}
-	return nil
 }
 
 func (c *ContentClient) GetUpdatedContentBlocksConcurrent(ctx context.Context, lastRun time.Time, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error) {
@@ -60,7 +145,7 @@ func (c *ContentClient) GetUpdatedContentBlocksConcurrent(ctx context.Context, l
 					if !ok {
 						return
 					}
-					items, err := c.FetchPage(ctx, query, page, 50)
+					items, err := c.FetchPage(ctx, query, page, queryPageSize)
 					if err != nil {
 						errors <- err
 						cancel()
@@ -124,6 +209,19 @@ func (c *ContentClient) GetUpdatedContentBlocksConcurrent(ctx context.Context, l
 }
 
 func (c *ContentClient) FetchPage(ctx context.Context, query map[string]interface{}, page, pageSize int) ([]model.ContentBlock, error) {
+	items, _, err := c.fetchPage(ctx, query, page, pageSize)
+	return items, err
+}
+
+// fetchPage runs the asset query for one page and also returns the number of assets
+// matching the query
+func (c *ContentClient) fetchPage(ctx context.Context, query map[string]interface{}, page, pageSize int) (items []model.ContentBlock, count int, err error) {
+	ctx, span := tracing.Start(ctx, "sfmc.fetch_page", attribute.Int("page", page), attribute.Int("page_size", pageSize))
+	defer func() {
+		span.SetAttributes(attribute.Int("items", len(items)))
+		tracing.End(span, err)
+	}()
+
 	localQuery := make(map[string]interface{})
 	for k, v := range query {
 		localQuery[k] = v
@@ -135,35 +233,277 @@ func (c *ContentClient) FetchPage(ctx context.Context, query map[string]interfac
 
 	queryJSON, err := json.Marshal(localQuery)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal query: %v", err)
+		return nil, 0, fmt.Errorf("failed to marshal query: %v", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/query", c.apiURL), bytes.NewBuffer(queryJSON))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+c.token.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	logger := logging.FromContext(ctx)
+	start := time.Now()
+	resp, err := c.do(ctx, "query", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/query", c.apiURL), bytes.NewReader(queryJSON))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error: %s (status: %d, response: %s)", c.apiURL, resp.StatusCode, string(body))
+		logger.WarnContext(ctx, "Asset query failed", "page", page, "status", resp.StatusCode, "duration", time.Since(start))
+		return nil, 0, fmt.Errorf("API error: %s (status: %d, response: %s)", c.apiURL, resp.StatusCode, string(body))
 	}
 
 	var result struct {
+		Count int                  `json:"count"`
 		Items []model.ContentBlock `json:"items"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, 0, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	logger.DebugContext(ctx, "Fetched asset page", "page", page, "items", len(result.Items), "duration", time.Since(start))
+	return result.Items, result.Count, nil
+}
+
+// GetAllContentBlocks fetches every asset matching query, however many pages there are.
+// The first page gives the number of matching assets; the remaining pages are fetched
+// by workerCount workers.
+func (c *ContentClient) GetAllContentBlocks(ctx context.Context, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error) {
+	first, count, err := c.fetchPage(ctx, query, 1, queryPageSize)
+	if err != nil {
+		return nil, err
+	}
+	pages := (count + queryPageSize - 1) / queryPageSize
+	if len(first) < queryPageSize {
+		pages = 1
+	}
+	results := make([][]model.ContentBlock, pages+1)
+	results[1] = first
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for i := 0; i < max(workerCount, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range jobs {
+				items, _, err := c.fetchPage(fetchCtx, query, page, queryPageSize)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				results[page] = items
+			}
+		}()
+	}
+feed:
+	for page := 2; page <= pages; page++ {
+		select {
+		case jobs <- page:
+		case <-fetchCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// an asset created while paging shifts the others, so one may appear on two pages
+	seen := make(map[int]bool, count)
+	allItems := make([]model.ContentBlock, 0, count)
+	for _, items := range results {
+		for _, item := range items {
+			if !seen[item.ID] {
+				seen[item.ID] = true
+				allItems = append(allItems, item)
+			}
+		}
+	}
+	sort.Slice(allItems, func(i, j int) bool {
+		return allItems[i].ID < allItems[j].ID
+	})
+	if len(allItems) < count {
+		logging.FromContext(ctx).WarnContext(ctx, "Fetched fewer assets than the query matched", "fetched", len(allItems), "count", count)
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "Fetched all assets", "count", len(allItems), "pages", pages)
+	return allItems, nil
+}
+
+// ErrAssetNotFound is returned when an asset does not exist in Marketing Cloud
+var ErrAssetNotFound = errors.New("asset not found")
+
+// GetAsset fetches the current version of a single asset by ID
+func (c *ContentClient) GetAsset(ctx context.Context, id int) (model.ContentBlock, error) {
+	resp, err := c.do(ctx, "asset", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/%d", c.apiURL, id), nil)
+	})
+	if err != nil {
+		return model.ContentBlock{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return model.ContentBlock{}, fmt.Errorf("asset %d: %w", id, ErrAssetNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return model.ContentBlock{}, fmt.Errorf("API error: %s (status: %d, response: %s)", c.apiURL, resp.StatusCode, string(body))
+	}
+
+	var block model.ContentBlock
+	if err := json.NewDecoder(resp.Body).Decode(&block); err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return block, nil
+}
+
+// UpdateAsset replaces an existing asset with block, e.g. to restore it from a backup
+func (c *ContentClient) UpdateAsset(ctx context.Context, block model.ContentBlock) error {
+	_, err := c.sendAsset(ctx, "asset_update", "PUT", fmt.Sprintf("%s/%d", c.apiURL, block.ID), block)
+	if errors.Is(err, ErrAssetNotFound) {
+		return fmt.Errorf("asset %d: %w", block.ID, ErrAssetNotFound)
+	}
+	return err
+}
+
+// CreateAsset creates a new asset from block and returns it with the ID Marketing Cloud
+// assigned. The ID of block is ignored.
+func (c *ContentClient) CreateAsset(ctx context.Context, block model.ContentBlock) (model.ContentBlock, error) {
+	return c.sendAsset(ctx, "asset_create", "POST", c.apiURL, block)
+}
+
+// sendAsset writes block to the asset API and decodes the asset in the response
+func (c *ContentClient) sendAsset(ctx context.Context, endpoint, method, url string, block model.ContentBlock) (model.ContentBlock, error) {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to marshal asset: %v", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to marshal asset: %v", err)
+	}
+	if method == "POST" {
+		delete(body, "id")
+	}
+	if data, err = json.Marshal(body); err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to marshal asset: %v", err)
 	}
 
-	return result.Items, nil
+	resp, err := c.do(ctx, endpoint, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return model.ContentBlock{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return model.ContentBlock{}, ErrAssetNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return model.ContentBlock{}, fmt.Errorf("API error: %s (status: %d, response: %s)", url, resp.StatusCode, string(body))
+	}
+
+	var saved model.ContentBlock
+	if err := json.NewDecoder(resp.Body).Decode(&saved); err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return saved, nil
+}
+
+// GetCategories returns all Content Builder categories
+func (c *ContentClient) GetCategories(ctx context.Context) ([]model.Category, error) {
+	categoriesURL := strings.TrimSuffix(c.apiURL, "/assets") + "/categories"
+	var categories []model.Category
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s?$page=%d&$pagesize=%d", categoriesURL, page, categoryPageSize)
+		resp, err := c.do(ctx, "categories", func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", url, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Count int              `json:"count"`
+			Items []model.Category `json:"items"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("API error: %s (status: %d, response: %s)", categoriesURL, resp.StatusCode, string(body))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		categories = append(categories, result.Items...)
+		if len(result.Items) < categoryPageSize || len(categories) >= result.Count {
+			logging.FromContext(ctx).DebugContext(ctx, "Fetched categories", "count", len(categories))
+			return categories, nil
+		}
+	}
+}
+
+// ListAssets returns every asset in the business unit with only its ID, customer key and
+// name, which is much cheaper than fetching the assets
+func (c *ContentClient) ListAssets(ctx context.Context) ([]model.AssetRef, error) {
+	var assets []model.AssetRef
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s?$page=%d&$pagesize=%d&$fields=id,customerKey,name", c.apiURL, page, assetListPageSize)
+		resp, err := c.do(ctx, "asset_list", func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", url, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Count int              `json:"count"`
+			Items []model.AssetRef `json:"items"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("API error: %s (status: %d, response: %s)", c.apiURL, resp.StatusCode, string(body))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		assets = append(assets, result.Items...)
+		if len(result.Items) < assetListPageSize || len(assets) >= result.Count {
+			logging.FromContext(ctx).DebugContext(ctx, "Listed assets", "count", len(assets))
+			return assets, nil
+		}
+	}
 }
@@ -9,8 +9,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type mockAuthClient struct {
@@ -36,6 +44,50 @@ func TestEnsureTokenValid(t *testing.T) {
 	assert.Equal(t, "new_token", client.token.AccessToken)
 }
 
+func TestFetchPage_RetriesWithNewToken(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer new_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": []model.ContentBlock{{ID: 1}}})
+	}))
+	defer server.Close()
+
+	refreshes := testutil.ToFloat64(metrics.TokenRefreshes)
+	retries := testutil.ToFloat64(metrics.Retries.WithLabelValues("query"))
+
+	mockAuth := &mockAuthClient{
+		GetAccessTokenFunc: func() (model.Token, error) {
+			return model.Token{AccessToken: "new_token", ExpiryTime: time.Now().Add(time.Hour)}, nil
+		},
+	}
+	token := &model.Token{AccessToken: "revoked_token", ExpiryTime: time.Now().Add(time.Hour)}
+	client := NewContentClient(server.URL, token, mockAuth)
+
+	report := &model.RunReport{}
+	items, err := client.FetchPage(model.WithRunReport(context.Background(), report), map[string]interface{}{}, 1, 50)
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, refreshes+1, testutil.ToFloat64(metrics.TokenRefreshes))
+	assert.Equal(t, retries+1, testutil.ToFloat64(metrics.Retries.WithLabelValues("query")))
+	assert.Equal(t, 1, report.Retries)
+	assert.Equal(t, 1, report.TokenRefreshes)
+
+	// a request rejected again after the retry is not retried a second time
+	mockAuth.GetAccessTokenFunc = func() (model.Token, error) {
+		return model.Token{AccessToken: "other_token", ExpiryTime: time.Now().Add(time.Hour)}, nil
+	}
+	client = NewContentClient(server.URL, &model.Token{AccessToken: "revoked_token", ExpiryTime: time.Now().Add(time.Hour)}, mockAuth)
+	requests = 0
+	_, err = client.FetchPage(context.Background(), map[string]interface{}{}, 1, 50)
+	assert.Error(t, err)
+	assert.Equal(t, 2, requests)
+}
+
 func TestFetchPage(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "Bearer test_token", r.Header.Get("Authorization"))
@@ -122,3 +174,195 @@ func TestGetUpdatedContentBlocksConcurrent_Error(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "API error")
 }
+
+func TestGetAllContentBlocks(t *testing.T) {
+	const total = 613
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		paging := query["page"].(map[string]interface{})
+		page, pageSize := int(paging["page"].(float64)), int(paging["pageSize"].(float64))
+		assert.Equal(t, "ServerSide", query["query"])
+
+		var items []model.ContentBlock
+		for id := (page-1)*pageSize + 1; id <= total && id <= page*pageSize; id++ {
+			items = append(items, model.ContentBlock{ID: id, Name: fmt.Sprintf("Block%d", id)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": total, "page": page, "pageSize": pageSize, "items": items})
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil)
+	items, err := client.GetAllContentBlocks(context.Background(), 3, map[string]interface{}{"query": "ServerSide"})
+	require.NoError(t, err)
+	require.Len(t, items, total)
+	for i, item := range items {
+		assert.Equal(t, i+1, item.ID)
+	}
+}
+
+func TestGetAllContentBlocks_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		if page := query["page"].(map[string]interface{})["page"].(float64); page == 4 {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		items := make([]model.ContentBlock, queryPageSize)
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": 10 * queryPageSize, "items": items})
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil)
+	_, err := client.GetAllContentBlocks(context.Background(), 2, map[string]interface{}{})
+	assert.ErrorContains(t, err, "API error")
+}
+
+func TestGetAsset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "Bearer test_token", r.Header.Get("Authorization"))
+		if r.URL.Path != "/42" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(model.ContentBlock{ID: 42, CustomerKey: "key-42", Name: "Block42"})
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil)
+
+	block, err := client.GetAsset(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Equal(t, "key-42", block.CustomerKey)
+
+	_, err = client.GetAsset(context.Background(), 7)
+	assert.ErrorIs(t, err, ErrAssetNotFound)
+}
+
+func TestUpdateAndCreateAsset(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test_token", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/42":
+			json.NewEncoder(w).Encode(body)
+		case r.Method == "POST" && r.URL.Path == "/":
+			assert.NotContains(t, body, "id")
+			body["id"] = 99
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(body)
+		case r.Method == "PUT":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, `{"message":"invalid asset"}`, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, client.UpdateAsset(ctx, model.ContentBlock{ID: 42, Name: "Welcome", Content: "<p>Hi</p>"}))
+	assert.Equal(t, float64(42), bodies[0]["id"])
+	assert.Equal(t, "<p>Hi</p>", bodies[0]["content"])
+
+	err := client.UpdateAsset(ctx, model.ContentBlock{ID: 7})
+	assert.ErrorIs(t, err, ErrAssetNotFound)
+	assert.ErrorContains(t, err, "asset 7")
+
+	created, err := client.CreateAsset(ctx, model.ContentBlock{ID: 7, Name: "Welcome"})
+	assert.NoError(t, err)
+	assert.Equal(t, 99, created.ID)
+	assert.Equal(t, "Welcome", created.Name)
+}
+
+func TestGetCategories(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/asset/v1/content/categories", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("$page"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count": 2,
+			"items": []model.Category{
+				{ID: 1, Name: "Content Builder"},
+				{ID: 2, Name: "Emails", ParentID: 1},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL+"/asset/v1/content/assets", &model.Token{AccessToken: "test_token"}, nil)
+
+	categories, err := client.GetCategories(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, categories, 2)
+	assert.Equal(t, 1, categories[1].ParentID)
+}
+
+func TestListAssets(t *testing.T) {
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/asset/v1/content/assets", r.URL.Path)
+		assert.Equal(t, "id,customerKey,name", r.URL.Query().Get("$fields"))
+		page := r.URL.Query().Get("$page")
+		pages = append(pages, page)
+		items := []model.AssetRef{{ID: 501, Name: "Footer"}, {ID: 502, CustomerKey: "welcome"}}
+		if page == "1" {
+			items = make([]model.AssetRef, assetListPageSize)
+			for i := range items {
+				items[i].ID = i + 1
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": 502, "items": items})
+	}))
+	defer server.Close()
+
+	client := NewContentClient(server.URL+"/asset/v1/content/assets", &model.Token{AccessToken: "test_token"}, nil)
+
+	assets, err := client.ListAssets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, pages)
+	assert.Len(t, assets, 502)
+	assert.Equal(t, model.AssetRef{ID: 502, CustomerKey: "welcome"}, assets[501])
+}
+
+func TestFetchPage_Span(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": []model.ContentBlock{{ID: 1}, {ID: 2}}})
+	}))
+	defer server.Close()
+
+	token := &model.Token{AccessToken: "test_token", ExpiryTime: time.Now().Add(time.Hour)}
+	client := NewContentClient(server.URL, token, nil)
+	_, err := client.FetchPage(context.Background(), map[string]interface{}{}, 3, 50)
+	assert.NoError(t, err)
+
+	var page sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "sfmc.fetch_page" {
+			page = span
+		}
+	}
+	if assert.NotNil(t, page) {
+		assert.Contains(t, page.Attributes(), attribute.Int("page", 3))
+		assert.Contains(t, page.Attributes(), attribute.Int("items", 2))
+		assert.Contains(t, traceparent, page.SpanContext().TraceID().String())
+	}
+}
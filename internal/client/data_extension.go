@@ -0,0 +1,283 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+const rowPageSize = 2500
+
+// DataExtensionClient reads data extensions: their list and schema from the SOAP API,
+// which is the only API describing them, and their rows from the REST API. It shares the
+// access token of the content client.
+type DataExtensionClient struct {
+	content *ContentClient
+	restURL string
+	soapURL string
+}
+
+func NewDataExtensionClient(content *ContentClient, restURL, soapURL string) *DataExtensionClient {
+	return &DataExtensionClient{
+		content: content,
+		restURL: strings.TrimSuffix(restURL, "/"),
+		soapURL: soapURL,
+	}
+}
+
+var retrieveTemplate = template.Must(template.New("retrieve").Funcs(template.FuncMap{
+	"xml": func(s string) (string, error) {
+		var b strings.Builder
+		err := xml.EscapeText(&b, []byte(s))
+		return b.String(), err
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<s:Header><fueloauth xmlns="http://exacttarget.com">{{xml .Token}}</fueloauth></s:Header>
+<s:Body><RetrieveRequestMsg xmlns="http://exacttarget.com/wsdl/partnerAPI"><RetrieveRequest>
+{{- if .ContinueRequest}}<ContinueRequest>{{xml .ContinueRequest}}</ContinueRequest>{{end -}}
+<ObjectType>{{xml .ObjectType}}</ObjectType>
+{{- range .Properties}}<Properties>{{xml .}}</Properties>{{end -}}
+<Filter xsi:type="SimpleFilterPart"><Property>{{xml .Filter.Property}}</Property><SimpleOperator>{{.Filter.Operator}}</SimpleOperator>
+{{- range .Filter.Values}}<Value>{{xml .}}</Value>{{end}}</Filter>
+</RetrieveRequest></RetrieveRequestMsg></s:Body></s:Envelope>`))
+
+type retrieveRequest struct {
+	Token           string
+	ContinueRequest string
+	ObjectType      string
+	Properties      []string
+	Filter          soapFilter
+}
+
+// soapFilter matches objects whose property is one of the values
+type soapFilter struct {
+	Property string
+	Values   []string
+}
+
+func (f soapFilter) Operator() string {
+	if len(f.Values) == 1 {
+		return "equals"
+	}
+	return "IN"
+}
+
+// soapResult holds the properties retrieved for data extensions and their fields
+type soapResult struct {
+	CustomerKey  string `xml:"CustomerKey"`
+	Name         string `xml:"Name"`
+	CategoryID   string `xml:"CategoryID"`
+	FieldType    string `xml:"FieldType"`
+	MaxLength    string `xml:"MaxLength"`
+	Scale        string `xml:"Scale"`
+	IsPrimaryKey bool   `xml:"IsPrimaryKey"`
+	IsRequired   bool   `xml:"IsRequired"`
+	DefaultValue string `xml:"DefaultValue"`
+	Ordinal      string `xml:"Ordinal"`
+}
+
+type retrieveResponse struct {
+	Body struct {
+		Response struct {
+			OverallStatus string       `xml:"OverallStatus"`
+			RequestID     string       `xml:"RequestID"`
+			Results       []soapResult `xml:"Results"`
+		} `xml:"RetrieveResponseMsg"`
+		Fault struct {
+			String string `xml:"faultstring"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// retrieve runs a SOAP Retrieve, following MoreDataAvailable until every result is read
+func (c *DataExtensionClient) retrieve(ctx context.Context, objectType string, properties []string, filter soapFilter) ([]soapResult, error) {
+	var results []soapResult
+	request := retrieveRequest{ObjectType: objectType, Properties: properties, Filter: filter}
+	for {
+		resp, err := c.content.do(ctx, "soap_retrieve", func() (*http.Request, error) {
+			token, err := c.content.accessToken(ctx, "")
+			if err != nil {
+				return nil, err
+			}
+			request.Token = token
+			var body bytes.Buffer
+			if err := retrieveTemplate.Execute(&body, request); err != nil {
+				return nil, err
+			}
+			req, err := http.NewRequestWithContext(ctx, "POST", c.soapURL, &body)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+			req.Header.Set("SOAPAction", "Retrieve")
+			return req, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var result retrieveResponse
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("API error: %s (status: %d, fault: %s)", c.soapURL, resp.StatusCode, result.Body.Fault.String)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		response := result.Body.Response
+		results = append(results, response.Results...)
+		switch response.OverallStatus {
+		case "OK":
+			return results, nil
+		case "MoreDataAvailable":
+			request.ContinueRequest = response.RequestID
+		default:
+			return nil, fmt.Errorf("failed to retrieve %s: %s", objectType, response.OverallStatus)
+		}
+	}
+}
+
+// DataExtensions returns the data extensions with the given customer keys and those in the
+// given folders, sorted by customer key. A key that does not exist is an error.
+func (c *DataExtensionClient) DataExtensions(ctx context.Context, keys, folderIDs []string) ([]model.DataExtension, error) {
+	properties := []string{"CustomerKey", "Name", "CategoryID"}
+	var results []soapResult
+	if len(keys) > 0 {
+		found, err := c.retrieve(ctx, "DataExtension", properties, soapFilter{Property: "CustomerKey", Values: keys})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, found...)
+	}
+	if len(folderIDs) > 0 {
+		found, err := c.retrieve(ctx, "DataExtension", properties, soapFilter{Property: "CategoryID", Values: folderIDs})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, found...)
+	}
+
+	byKey := make(map[string]model.DataExtension)
+	for _, r := range results {
+		categoryID, _ := strconv.Atoi(r.CategoryID)
+		byKey[r.CustomerKey] = model.DataExtension{CustomerKey: r.CustomerKey, Name: r.Name, CategoryID: categoryID}
+	}
+	var missing []string
+	for _, key := range keys {
+		if _, ok := byKey[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("data extensions not found: %s", strings.Join(missing, ", "))
+	}
+
+	dataExtensions := make([]model.DataExtension, 0, len(byKey))
+	for _, de := range byKey {
+		dataExtensions = append(dataExtensions, de)
+	}
+	sort.Slice(dataExtensions, func(i, j int) bool {
+		return dataExtensions[i].CustomerKey < dataExtensions[j].CustomerKey
+	})
+	logging.FromContext(ctx).DebugContext(ctx, "Listed data extensions", "count", len(dataExtensions))
+	return dataExtensions, nil
+}
+
+// DataExtensionFields returns the schema of a data extension in column order
+func (c *DataExtensionClient) DataExtensionFields(ctx context.Context, key string) ([]model.DataExtensionField, error) {
+	properties := []string{"Name", "FieldType", "MaxLength", "Scale", "IsPrimaryKey", "IsRequired", "DefaultValue", "Ordinal"}
+	results, err := c.retrieve(ctx, "DataExtensionField", properties, soapFilter{Property: "DataExtension.CustomerKey", Values: []string{key}})
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]model.DataExtensionField, 0, len(results))
+	for _, r := range results {
+		field := model.DataExtensionField{
+			Name:         r.Name,
+			FieldType:    r.FieldType,
+			IsPrimaryKey: r.IsPrimaryKey,
+			IsRequired:   r.IsRequired,
+			DefaultValue: r.DefaultValue,
+		}
+		// empty for field types without a length or scale
+		field.MaxLength, _ = strconv.Atoi(r.MaxLength)
+		field.Scale, _ = strconv.Atoi(r.Scale)
+		field.Ordinal, _ = strconv.Atoi(r.Ordinal)
+		fields = append(fields, field)
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Ordinal < fields[j].Ordinal
+	})
+	return fields, nil
+}
+
+// DataExtensionRows pages through the rows of a data extension and passes each page to fn,
+// so that rows can be written as they arrive. Each row maps the lowercased field names,
+// as the API returns them, to their values. It returns the row count reported by the API.
+func (c *DataExtensionClient) DataExtensionRows(ctx context.Context, key string, fn func(rows []map[string]string) error) (int, error) {
+	rowsURL := fmt.Sprintf("%s/data/v1/customobjectdata/key/%s/rowset", c.restURL, url.PathEscape(key))
+	var read int
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s?$page=%d&$pageSize=%d", rowsURL, page, rowPageSize)
+		resp, err := c.content.do(ctx, "data_extension_rows", func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", url, nil)
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		var result struct {
+			Count int `json:"count"`
+			Items []struct {
+				Keys   map[string]interface{} `json:"keys"`
+				Values map[string]interface{} `json:"values"`
+			} `json:"items"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return 0, fmt.Errorf("API error: %s (status: %d, response: %s)", rowsURL, resp.StatusCode, string(body))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		rows := make([]map[string]string, 0, len(result.Items))
+		for _, item := range result.Items {
+			row := make(map[string]string, len(item.Keys)+len(item.Values))
+			for _, values := range []map[string]interface{}{item.Keys, item.Values} {
+				for name, value := range values {
+					if value != nil {
+						row[strings.ToLower(name)] = fmt.Sprint(value)
+					}
+				}
+			}
+			rows = append(rows, row)
+		}
+		if err := fn(rows); err != nil {
+			return result.Count, err
+		}
+		read += len(rows)
+		if len(result.Items) < rowPageSize || read >= result.Count {
+			logging.FromContext(ctx).DebugContext(ctx, "Fetched data extension rows", "key", key, "rows", read)
+			return result.Count, nil
+		}
+	}
+}
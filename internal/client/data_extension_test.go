@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSOAPRequest is the part of a Retrieve request the fake server looks at
+type fakeSOAPRequest struct {
+	Body struct {
+		Message struct {
+			Request struct {
+				ContinueRequest string   `xml:"ContinueRequest"`
+				ObjectType      string   `xml:"ObjectType"`
+				Properties      []string `xml:"Properties"`
+				Filter          struct {
+					Property string   `xml:"Property"`
+					Operator string   `xml:"SimpleOperator"`
+					Values   []string `xml:"Value"`
+				} `xml:"Filter"`
+			} `xml:"RetrieveRequest"`
+		} `xml:"RetrieveRequestMsg"`
+	} `xml:"Body"`
+	Token string `xml:"Header>fueloauth"`
+}
+
+func soapResponse(status, requestID string, results ...string) string {
+	return fmt.Sprintf(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>`+
+		`<RetrieveResponseMsg xmlns="http://exacttarget.com/wsdl/partnerAPI"><OverallStatus>%s</OverallStatus><RequestID>%s</RequestID>%s</RetrieveResponseMsg>`+
+		`</soap:Body></soap:Envelope>`, status, requestID, strings.Join(results, ""))
+}
+
+func newFakeDataExtensionServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Service.asmx" {
+			var req fakeSOAPRequest
+			require.NoError(t, xml.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "test_token", req.Token)
+			assert.Equal(t, "Retrieve", r.Header.Get("SOAPAction"))
+			retrieve := req.Body.Message.Request
+			filter := fmt.Sprintf("%s %s %s", retrieve.Filter.Property, retrieve.Filter.Operator, strings.Join(retrieve.Filter.Values, ","))
+			switch {
+			case retrieve.ObjectType == "DataExtension" && filter == "CustomerKey IN lookup,regions":
+				io.WriteString(w, soapResponse("OK", "1",
+					`<Results><CustomerKey>regions</CustomerKey><Name>Regions</Name><CategoryID>7</CategoryID></Results>`,
+					`<Results><CustomerKey>lookup</CustomerKey><Name>Lookup &amp; Co</Name><CategoryID>7</CategoryID></Results>`))
+			case retrieve.ObjectType == "DataExtension" && filter == "CustomerKey equals missing":
+				io.WriteString(w, soapResponse("OK", "2"))
+			case retrieve.ObjectType == "DataExtension" && filter == "CategoryID equals 7" && retrieve.ContinueRequest == "":
+				io.WriteString(w, soapResponse("MoreDataAvailable", "3",
+					`<Results><CustomerKey>regions</CustomerKey><Name>Regions</Name><CategoryID>7</CategoryID></Results>`))
+			case retrieve.ObjectType == "DataExtension" && retrieve.ContinueRequest == "3":
+				io.WriteString(w, soapResponse("OK", "3",
+					`<Results><CustomerKey>stores</CustomerKey><Name>Stores</Name><CategoryID>7</CategoryID></Results>`))
+			case retrieve.ObjectType == "DataExtensionField" && filter == "DataExtension.CustomerKey equals regions":
+				io.WriteString(w, soapResponse("OK", "4",
+					`<Results><Name>Label</Name><FieldType>Text</FieldType><MaxLength>100</MaxLength><Scale></Scale><IsPrimaryKey>false</IsPrimaryKey><IsRequired>false</IsRequired><Ordinal>1</Ordinal></Results>`,
+					`<Results><Name>Code</Name><FieldType>Text</FieldType><MaxLength>2</MaxLength><IsPrimaryKey>true</IsPrimaryKey><IsRequired>true</IsRequired><Ordinal>0</Ordinal></Results>`))
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				io.WriteString(w, `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultstring>Unexpected request</faultstring></soap:Fault></soap:Body></soap:Envelope>`)
+			}
+			return
+		}
+
+		assert.Equal(t, "/data/v1/customobjectdata/key/regions/rowset", r.URL.Path)
+		assert.Equal(t, "Bearer test_token", r.Header.Get("Authorization"))
+		page := r.URL.Query().Get("$page")
+		var items []map[string]interface{}
+		if page == "1" {
+			for i := 0; i < rowPageSize; i++ {
+				items = append(items, map[string]interface{}{"keys": map[string]interface{}{"code": fmt.Sprint(i)}, "values": map[string]interface{}{"label": "Region"}})
+			}
+		} else {
+			items = append(items, map[string]interface{}{"keys": map[string]interface{}{"code": "FR"}, "values": map[string]interface{}{"label": "France", "notes": nil}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": rowPageSize + 1, "page": page, "items": items})
+	}))
+}
+
+func TestDataExtensionClient_DataExtensions(t *testing.T) {
+	server := newFakeDataExtensionServer(t)
+	defer server.Close()
+	client := NewDataExtensionClient(NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil), server.URL, server.URL+"/Service.asmx")
+	ctx := context.Background()
+
+	dataExtensions, err := client.DataExtensions(ctx, []string{"lookup", "regions"}, []string{"7"})
+	require.NoError(t, err)
+	assert.Equal(t, []model.DataExtension{
+		{CustomerKey: "lookup", Name: "Lookup & Co", CategoryID: 7},
+		{CustomerKey: "regions", Name: "Regions", CategoryID: 7},
+		{CustomerKey: "stores", Name: "Stores", CategoryID: 7},
+	}, dataExtensions)
+
+	_, err = client.DataExtensions(ctx, []string{"missing"}, nil)
+	assert.EqualError(t, err, "data extensions not found: missing")
+
+	_, err = client.DataExtensions(ctx, nil, []string{"8"})
+	assert.EqualError(t, err, fmt.Sprintf("API error: %s/Service.asmx (status: 500, fault: Unexpected request)", server.URL))
+}
+
+func TestDataExtensionClient_DataExtensionFields(t *testing.T) {
+	server := newFakeDataExtensionServer(t)
+	defer server.Close()
+	client := NewDataExtensionClient(NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil), server.URL, server.URL+"/Service.asmx")
+
+	fields, err := client.DataExtensionFields(context.Background(), "regions")
+	require.NoError(t, err)
+	assert.Equal(t, []model.DataExtensionField{
+		{Name: "Code", FieldType: "Text", MaxLength: 2, IsPrimaryKey: true, IsRequired: true, Ordinal: 0},
+		{Name: "Label", FieldType: "Text", MaxLength: 100, Ordinal: 1},
+	}, fields)
+}
+
+func TestDataExtensionClient_DataExtensionRows(t *testing.T) {
+	server := newFakeDataExtensionServer(t)
+	defer server.Close()
+	client := NewDataExtensionClient(NewContentClient(server.URL, &model.Token{AccessToken: "test_token"}, nil), server.URL+"/", server.URL+"/Service.asmx")
+
+	var pages []int
+	var last map[string]string
+	count, err := client.DataExtensionRows(context.Background(), "regions", func(rows []map[string]string) error {
+		pages = append(pages, len(rows))
+		last = rows[len(rows)-1]
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rowPageSize+1, count)
+	assert.Equal(t, []int{rowPageSize, 1}, pages)
+	assert.Equal(t, map[string]string{"code": "FR", "label": "France"}, last)
+
+	_, err = client.DataExtensionRows(context.Background(), "regions", func(rows []map[string]string) error {
+		return fmt.Errorf("disk full")
+	})
+	assert.EqualError(t, err, "disk full")
+}
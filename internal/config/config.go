@@ -1,36 +1,374 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
+// Config is read from an optional YAML or TOML file, then overridden by the environment.
+// Each field has its file key in the yaml/toml tags and its environment variable in the
+// env tag, secrets are marked so that they can be redacted.
 type Config struct {
-	AuthURL      string
-	APIURL       string
-	ClientID     string
-	ClientSecret string
-	StoragePath  string
-	S3Bucket     string
-	S3Region     string
-	S3AccessKey  string
-	S3SecretKey  string
-}
-
-func Load() Config {
-	authBaseURL := os.Getenv("AUTH_URL")
-	authURL := fmt.Sprintf("%s/v2/token", authBaseURL)
-	apiBaseURL := os.Getenv("API_URL")
-	apiURL := fmt.Sprintf("%s/asset/v1/content/assets", apiBaseURL)
+	AuthURL      string        `yaml:"auth_url" toml:"auth_url" env:"AUTH_URL"`
+	APIURL       string        `yaml:"api_url" toml:"api_url" env:"API_URL"`
+	SOAPURL      string        `yaml:"soap_url" toml:"soap_url" env:"SOAP_URL"`
+	ClientID     string        `yaml:"client_id" toml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string        `yaml:"client_secret" toml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+	BusinessUnit string        `yaml:"business_unit" toml:"business_unit" env:"BUSINESS_UNIT"`
+	Schedule     string        `yaml:"schedule" toml:"schedule" env:"SCHEDULE"`
+	FullSchedule string        `yaml:"full_schedule" toml:"full_schedule" env:"FULL_SCHEDULE"`
+	LastRunFile  string        `yaml:"last_run_file" toml:"last_run_file" env:"LAST_RUN_FILE"`
+	HistoryFile  string        `yaml:"history_file" toml:"history_file" env:"HISTORY_FILE"`
+	Inventory    string        `yaml:"inventory_file" toml:"inventory_file" env:"INVENTORY_FILE"`
+	Reconcile    time.Duration `yaml:"reconcile_interval" toml:"reconcile_interval" env:"RECONCILE_INTERVAL"`
+	DeleteAlert  int           `yaml:"deletion_alert_threshold" toml:"deletion_alert_threshold" env:"DELETION_ALERT_THRESHOLD"`
+	DEKeys       []string      `yaml:"data_extensions" toml:"data_extensions" env:"DATA_EXTENSIONS"`
+	DEFolders    []string      `yaml:"data_extension_folders" toml:"data_extension_folders" env:"DATA_EXTENSION_FOLDERS"`
+	DEFormat     string        `yaml:"data_extension_format" toml:"data_extension_format" env:"DATA_EXTENSION_FORMAT"`
+	FetchWorkers int           `yaml:"fetch_workers" toml:"fetch_workers" env:"FETCH_WORKERS"`
+	SaveWorkers  int           `yaml:"save_workers" toml:"save_workers" env:"SAVE_WORKERS"`
+	LogFormat    string        `yaml:"log_format" toml:"log_format" env:"LOG_FORMAT"`
+	LogLevel     string        `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL"`
+	HTTPAddr     string        `yaml:"http_addr" toml:"http_addr" env:"HTTP_ADDR"`
+	APIToken     string        `yaml:"api_token" toml:"api_token" env:"API_TOKEN" secret:"true"`
+	StoragePath  string        `yaml:"storage_path" toml:"storage_path" env:"STORAGE_PATH"`
+	FileMode     FileMode      `yaml:"storage_file_mode" toml:"storage_file_mode" env:"STORAGE_FILE_MODE"`
+	DirMode      FileMode      `yaml:"storage_dir_mode" toml:"storage_dir_mode" env:"STORAGE_DIR_MODE"`
+	StagingSweep string        `yaml:"staging_sweep" toml:"staging_sweep" env:"STAGING_SWEEP"`
+	StagingAge   time.Duration `yaml:"staging_max_age" toml:"staging_max_age" env:"STAGING_MAX_AGE"`
+	S3Bucket     string        `yaml:"s3_bucket" toml:"s3_bucket" env:"S3_BUCKET"`
+	S3Region     string        `yaml:"s3_region" toml:"s3_region" env:"S3_REGION"`
+	S3AccessKey  string        `yaml:"s3_access_key" toml:"s3_access_key" env:"S3_ACCESS_KEY" secret:"true"`
+	S3SecretKey  string        `yaml:"s3_secret_key" toml:"s3_secret_key" env:"S3_SECRET_KEY" secret:"true"`
+	S3SSE        string        `yaml:"s3_sse" toml:"s3_sse" env:"S3_SSE"`
+	S3KMSKeyID   string        `yaml:"s3_kms_key_id" toml:"s3_kms_key_id" env:"S3_KMS_KEY_ID"`
+	S3Class      string        `yaml:"s3_storage_class" toml:"s3_storage_class" env:"S3_STORAGE_CLASS"`
+	S3LockMode   string        `yaml:"s3_object_lock_mode" toml:"s3_object_lock_mode" env:"S3_OBJECT_LOCK_MODE"`
+	S3LockPeriod time.Duration `yaml:"s3_object_lock_retention" toml:"s3_object_lock_retention" env:"S3_OBJECT_LOCK_RETENTION"`
+	S3LegalHold  bool          `yaml:"s3_legal_hold" toml:"s3_legal_hold" env:"S3_LEGAL_HOLD"`
+	SFTPAddr     string        `yaml:"sftp_addr" toml:"sftp_addr" env:"SFTP_ADDR"`
+	SFTPUser     string        `yaml:"sftp_user" toml:"sftp_user" env:"SFTP_USER"`
+	SFTPKeyFile  string        `yaml:"sftp_key_file" toml:"sftp_key_file" env:"SFTP_KEY_FILE"`
+	SFTPKeyPass  string        `yaml:"sftp_key_passphrase" toml:"sftp_key_passphrase" env:"SFTP_KEY_PASSPHRASE" secret:"true"`
+	SFTPHostKeys string        `yaml:"sftp_known_hosts" toml:"sftp_known_hosts" env:"SFTP_KNOWN_HOSTS"`
+	SFTPBasePath string        `yaml:"sftp_base_path" toml:"sftp_base_path" env:"SFTP_BASE_PATH"`
+	SFTPPoolSize int           `yaml:"sftp_pool_size" toml:"sftp_pool_size" env:"SFTP_POOL_SIZE"`
+	Destinations []string      `yaml:"destinations" toml:"destinations" env:"STORAGE_DESTINATIONS"`
+	FanOutPolicy string        `yaml:"fanout_policy" toml:"fanout_policy" env:"FANOUT_POLICY"`
+	Layout       string        `yaml:"storage_layout" toml:"storage_layout" env:"STORAGE_LAYOUT"`
+	GitRepoPath  string        `yaml:"git_repo_path" toml:"git_repo_path" env:"GIT_REPO_PATH"`
+	GitRemote    string        `yaml:"git_remote" toml:"git_remote" env:"GIT_REMOTE"`
+	GitBranch    string        `yaml:"git_branch" toml:"git_branch" env:"GIT_BRANCH"`
+	Dedup        bool          `yaml:"storage_dedup" toml:"storage_dedup" env:"STORAGE_DEDUP"`
+	ReportHTML   bool          `yaml:"report_html" toml:"report_html" env:"REPORT_HTML"`
+	Retention    Retention     `yaml:"retention" toml:"retention"`
+	Tracing      Tracing       `yaml:"tracing" toml:"tracing"`
+	Notify       Notify        `yaml:"notify" toml:"notify"`
+	SecretStores SecretStores  `yaml:"secrets" toml:"secrets"`
+}
+
+// Retention holds the GFS retention rules applied by prune
+type Retention struct {
+	KeepLast      int  `yaml:"keep_last" toml:"keep_last" env:"RETENTION_KEEP_LAST"`
+	KeepDaily     int  `yaml:"keep_daily" toml:"keep_daily" env:"RETENTION_KEEP_DAILY"`
+	KeepWeekly    int  `yaml:"keep_weekly" toml:"keep_weekly" env:"RETENTION_KEEP_WEEKLY"`
+	KeepMonthly   int  `yaml:"keep_monthly" toml:"keep_monthly" env:"RETENTION_KEEP_MONTHLY"`
+	PruneAfterRun bool `yaml:"prune_after_run" toml:"prune_after_run" env:"PRUNE_AFTER_RUN"`
+}
+
+// Tracing configures the OpenTelemetry exporter, spans are not exported by default
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACE_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACE_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACE_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACE_SAMPLE_RATIO"`
+	Propagate   bool    `yaml:"propagate" toml:"propagate" env:"TRACE_PROPAGATE"`
+}
+
+// Notify configures the notifications sent at the end of each run
+type Notify struct {
+	Mode       string   `yaml:"on" toml:"on" env:"NOTIFY_ON"`
+	WebhookURL string   `yaml:"webhook_url" toml:"webhook_url" env:"NOTIFY_WEBHOOK_URL" secret:"true"`
+	SlackURL   string   `yaml:"slack_url" toml:"slack_url" env:"NOTIFY_SLACK_URL" secret:"true"`
+	TeamsURL   string   `yaml:"teams_url" toml:"teams_url" env:"NOTIFY_TEAMS_URL" secret:"true"`
+	Template   string   `yaml:"template" toml:"template" env:"NOTIFY_TEMPLATE"`
+	Attempts   int      `yaml:"attempts" toml:"attempts" env:"NOTIFY_ATTEMPTS"`
+	FolderURL  string   `yaml:"folder_url" toml:"folder_url" env:"NOTIFY_FOLDER_URL"`
+	SMTPAddr   string   `yaml:"smtp_addr" toml:"smtp_addr" env:"SMTP_ADDR"`
+	SMTPUser   string   `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPass   string   `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	SMTPFrom   string   `yaml:"smtp_from" toml:"smtp_from" env:"SMTP_FROM"`
+	SMTPTo     []string `yaml:"smtp_to" toml:"smtp_to" env:"SMTP_TO"`
+	SMTPTLS    string   `yaml:"smtp_tls" toml:"smtp_tls" env:"SMTP_TLS"`
+	Digest     bool     `yaml:"digest" toml:"digest" env:"NOTIFY_DIGEST"`
+	DigestCron string   `yaml:"digest_cron" toml:"digest_cron" env:"NOTIFY_DIGEST_CRON"`
+	DigestFile string   `yaml:"digest_file" toml:"digest_file" env:"NOTIFY_DIGEST_FILE"`
+}
+
+// SecretStores configures the secret stores that secret settings can reference, and how
+// often rotated secrets are reloaded
+type SecretStores struct {
+	VaultAddr      string        `yaml:"vault_addr" toml:"vault_addr" env:"VAULT_ADDR"`
+	VaultToken     string        `yaml:"vault_token" toml:"vault_token" env:"VAULT_TOKEN" secret:"true"`
+	VaultNamespace string        `yaml:"vault_namespace" toml:"vault_namespace" env:"VAULT_NAMESPACE"`
+	VaultKV        int           `yaml:"vault_kv_version" toml:"vault_kv_version" env:"VAULT_KV_VERSION"`
+	AWSRegion      string        `yaml:"aws_region" toml:"aws_region" env:"AWS_REGION"`
+	Refresh        time.Duration `yaml:"refresh" toml:"refresh" env:"SECRETS_REFRESH"`
+}
+
+// Default returns the configuration used for settings that are neither in the
+// configuration file nor in the environment
+func Default() Config {
 	return Config{
-		AuthURL:      authURL,
-		APIURL:       apiURL,
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		StoragePath:  os.Getenv("STORAGE_PATH"),
-		S3Bucket:     os.Getenv("S3_BUCKET"),
-		S3Region:     os.Getenv("S3_REGION"),
-		S3AccessKey:  os.Getenv("S3_ACCESS_KEY"),
-		S3SecretKey:  os.Getenv("S3_SECRET_KEY"),
+		Schedule:     "0 0 * * *", // every day at midnight
+		LastRunFile:  "lastrun.txt",
+		HistoryFile:  "history.db",
+		Inventory:    "inventory.json",
+		DeleteAlert:  50,
+		DEFormat:     "csv",
+		FetchWorkers: 5,
+		SaveWorkers:  10,
+		HTTPAddr:     ":8080",
+		FileMode:     0644,
+		DirMode:      FileMode(os.ModePerm),
+		StagingSweep: "remove",
+		SFTPBasePath: "backups",
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		Notify: Notify{
+			DigestCron: "0 8 * * *",
+			DigestFile: "digest.json",
+		},
+		SecretStores: SecretStores{
+			VaultKV: 2,
+			Refresh: 15 * time.Minute,
+		},
+	}
+}
+
+// Load reads the configuration: the defaults, overridden by the file at path if not
+// empty, overridden by the environment variables that are set. Secret settings that
+// reference a secret store are then replaced with the secret. Load only fails when the
+// file or a variable cannot be parsed or a secret cannot be read, Validate checks the
+// resulting configuration.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := loadEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	cfg.AuthURL = strings.TrimRight(cfg.AuthURL, "/")
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	cfg.S3LockMode = strings.ToUpper(cfg.S3LockMode)
+	cfg.Notify.SMTPTLS = strings.ToLower(cfg.Notify.SMTPTLS)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := resolveSecrets(ctx, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// TokenURL returns the OAuth token endpoint of the authentication base URL
+func (c Config) TokenURL() string {
+	return c.AuthURL + "/v2/token"
+}
+
+// AssetsURL returns the Content Builder assets endpoint of the REST base URL
+func (c Config) AssetsURL() string {
+	return c.APIURL + "/asset/v1/content/assets"
+}
+
+// SOAPServiceURL returns the SOAP endpoint, by default the one of the tenant of the REST
+// base URL
+func (c Config) SOAPServiceURL() string {
+	if c.SOAPURL != "" {
+		return c.SOAPURL
+	}
+	return strings.Replace(c.APIURL, ".rest.", ".soap.", 1) + "/Service.asmx"
+}
+
+// minAPITokenLength keeps the control API token from being guessed
+const minAPITokenLength = 16
+
+// Validate checks the whole configuration and reports every invalid setting, each named
+// by its file key and environment variable
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(key, env, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s (%s): %s", key, env, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(key, env, value string, allowed ...string) {
+		if value != "" && !slices.Contains(allowed, value) {
+			invalid(key, env, "unknown value %q, expected one of %s", value, strings.Join(allowed, ", "))
+		}
+	}
+
+	for _, u := range []struct{ key, env, value string }{
+		{"auth_url", "AUTH_URL", c.AuthURL},
+		{"api_url", "API_URL", c.APIURL},
+	} {
+		if u.value == "" {
+			invalid(u.key, u.env, "is required")
+		} else if parsed, err := url.ParseRequestURI(u.value); err != nil || parsed.Host == "" {
+			invalid(u.key, u.env, "%q is not a valid URL", u.value)
+		}
+	}
+	if c.SOAPURL != "" {
+		if parsed, err := url.ParseRequestURI(c.SOAPURL); err != nil || parsed.Host == "" {
+			invalid("soap_url", "SOAP_URL", "%q is not a valid URL", c.SOAPURL)
+		}
 	}
+	if c.ClientID == "" {
+		invalid("client_id", "CLIENT_ID", "is required")
+	}
+	if c.ClientSecret == "" {
+		invalid("client_secret", "CLIENT_SECRET", "is required")
+	}
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		invalid("schedule", "SCHEDULE", "invalid cron expression %q: %v", c.Schedule, err)
+	}
+	if c.FullSchedule != "" {
+		if _, err := cron.ParseStandard(c.FullSchedule); err != nil {
+			invalid("full_schedule", "FULL_SCHEDULE", "invalid cron expression %q: %v", c.FullSchedule, err)
+		}
+		if !c.Dedup {
+			invalid("full_schedule", "FULL_SCHEDULE", "requires storage_dedup, only deduplicated folders record the snapshot chain")
+		}
+	}
+	if c.LastRunFile == "" {
+		invalid("last_run_file", "LAST_RUN_FILE", "is required")
+	}
+	if c.HistoryFile == "" {
+		invalid("history_file", "HISTORY_FILE", "is required, use off to disable the history index")
+	}
+	if c.Inventory == "" {
+		invalid("inventory_file", "INVENTORY_FILE", "is required, use off to disable the detection of deleted assets")
+	}
+	if c.Reconcile < 0 {
+		invalid("reconcile_interval", "RECONCILE_INTERVAL", "must not be negative, got %s", c.Reconcile)
+	}
+	if c.DeleteAlert < 0 {
+		invalid("deletion_alert_threshold", "DELETION_ALERT_THRESHOLD", "must not be negative, got %d", c.DeleteAlert)
+	}
+	for _, folder := range c.DEFolders {
+		if _, err := strconv.Atoi(folder); err != nil {
+			invalid("data_extension_folders", "DATA_EXTENSION_FOLDERS", "%q is not a folder ID", folder)
+		}
+	}
+	if c.FetchWorkers < 1 {
+		invalid("fetch_workers", "FETCH_WORKERS", "must be at least 1, got %d", c.FetchWorkers)
+	}
+	if c.SaveWorkers < 1 {
+		invalid("save_workers", "SAVE_WORKERS", "must be at least 1, got %d", c.SaveWorkers)
+	}
+	if c.APIToken != "" && len(c.APIToken) < minAPITokenLength {
+		invalid("api_token", "API_TOKEN", "must be at least %d characters", minAPITokenLength)
+	}
+	oneOf("log_format", "LOG_FORMAT", strings.ToLower(c.LogFormat), "text", "json")
+	oneOf("log_level", "LOG_LEVEL", strings.ToLower(c.LogLevel), "debug", "info", "warn", "error")
+	oneOf("staging_sweep", "STAGING_SWEEP", c.StagingSweep, "remove", "quarantine", "off")
+	oneOf("fanout_policy", "FANOUT_POLICY", c.FanOutPolicy, "all", "any", "quorum")
+	oneOf("data_extension_format", "DATA_EXTENSION_FORMAT", c.DEFormat, "csv", "jsonl")
+	oneOf("storage_layout", "STORAGE_LAYOUT", c.Layout, "raw", "human")
+	oneOf("s3_object_lock_mode", "S3_OBJECT_LOCK_MODE", c.S3LockMode, "GOVERNANCE", "COMPLIANCE")
+	oneOf("tracing.exporter", "TRACE_EXPORTER", strings.ToLower(c.Tracing.Exporter), "none", "otlp")
+	oneOf("notify.on", "NOTIFY_ON", c.Notify.Mode, "always", "failure", "change")
+	oneOf("notify.smtp_tls", "SMTP_TLS", c.Notify.SMTPTLS, "starttls", "tls", "none")
+
+	seen := make(map[string]bool)
+	for _, name := range c.Destinations {
+		oneOf("destinations", "STORAGE_DESTINATIONS", name, "local", "s3", "sftp", "git")
+		if seen[name] {
+			invalid("destinations", "STORAGE_DESTINATIONS", "%q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if seen["s3"] && c.S3Bucket == "" {
+		invalid("s3_bucket", "S3_BUCKET", "is required by the s3 destination")
+	}
+	if seen["sftp"] {
+		if c.SFTPAddr == "" {
+			invalid("sftp_addr", "SFTP_ADDR", "is required by the sftp destination")
+		}
+		if c.SFTPKeyFile == "" {
+			invalid("sftp_key_file", "SFTP_KEY_FILE", "is required by the sftp destination")
+		}
+	}
+	if seen["git"] && c.GitRepoPath == "" {
+		invalid("git_repo_path", "GIT_REPO_PATH", "is required by the git destination")
+	}
+	if c.SFTPPoolSize < 0 {
+		invalid("sftp_pool_size", "SFTP_POOL_SIZE", "must not be negative, got %d", c.SFTPPoolSize)
+	}
+	for _, r := range []struct {
+		key, env string
+		value    int
+	}{
+		{"retention.keep_last", "RETENTION_KEEP_LAST", c.Retention.KeepLast},
+		{"retention.keep_daily", "RETENTION_KEEP_DAILY", c.Retention.KeepDaily},
+		{"retention.keep_weekly", "RETENTION_KEEP_WEEKLY", c.Retention.KeepWeekly},
+		{"retention.keep_monthly", "RETENTION_KEEP_MONTHLY", c.Retention.KeepMonthly},
+		{"notify.attempts", "NOTIFY_ATTEMPTS", c.Notify.Attempts},
+	} {
+		if r.value < 0 {
+			invalid(r.key, r.env, "must not be negative, got %d", r.value)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "TRACE_SAMPLE_RATIO", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.SecretStores.VaultKV != 1 && c.SecretStores.VaultKV != 2 {
+		invalid("secrets.vault_kv_version", "VAULT_KV_VERSION", "must be 1 or 2, got %d", c.SecretStores.VaultKV)
+	}
+	if c.SecretStores.Refresh < 0 {
+		invalid("secrets.refresh", "SECRETS_REFRESH", "must not be negative, got %s", c.SecretStores.Refresh)
+	}
+	if c.Notify.Digest {
+		if c.Notify.SMTPAddr == "" {
+			invalid("notify.smtp_addr", "SMTP_ADDR", "is required by the email digest")
+		}
+		if _, err := cron.ParseStandard(c.Notify.DigestCron); err != nil {
+			invalid("notify.digest_cron", "NOTIFY_DIGEST_CRON", "invalid cron expression %q: %v", c.Notify.DigestCron, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileMode is a permission written in octal, such as "0640"
+type FileMode os.FileMode
+
+// UnmarshalText parses an octal permission
+func (m *FileMode) UnmarshalText(text []byte) error {
+	value, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode %q, expected an octal permission such as 0640", text)
+	}
+	*m = FileMode(value)
+	return nil
+}
+
+// MarshalText formats the permission in octal
+func (m FileMode) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%04o", uint32(m.Perm()))), nil
+}
+
+// Perm returns the permission bits
+func (m FileMode) Perm() os.FileMode {
+	return os.FileMode(m).Perm()
 }
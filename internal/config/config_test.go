@@ -0,0 +1,366 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func validConfig() Config {
+	cfg := Default()
+	cfg.AuthURL = "https://example.auth.marketingcloudapis.com"
+	cfg.APIURL = "https://example.rest.marketingcloudapis.com"
+	cfg.ClientID = "id"
+	cfg.ClientSecret = "secret"
+	return cfg
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, "0 0 * * *", cfg.Schedule)
+	assert.Equal(t, "lastrun.txt", cfg.LastRunFile)
+	assert.Equal(t, "history.db", cfg.HistoryFile)
+	assert.Equal(t, "inventory.json", cfg.Inventory)
+	assert.Equal(t, 50, cfg.DeleteAlert)
+	assert.Equal(t, "csv", cfg.DEFormat)
+	assert.Equal(t, 5, cfg.FetchWorkers)
+	assert.Equal(t, FileMode(0644), cfg.FileMode)
+	assert.Equal(t, "digest.json", cfg.Notify.DigestFile)
+}
+
+func TestLoad_Files(t *testing.T) {
+	t.Setenv("TEST_CLIENT_SECRET", "from-env")
+	t.Setenv("SAVE_WORKERS", "3")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "YAML",
+			file: "config.yaml",
+			content: `
+auth_url: https://example.auth.marketingcloudapis.com/
+client_secret: ${TEST_CLIENT_SECRET}
+business_unit: ${TEST_BUSINESS_UNIT:-default-bu}
+schedule: "30 2 * * *"
+save_workers: 20
+storage_file_mode: "0640"
+staging_max_age: 36h
+destinations: [local, s3]
+s3_object_lock_mode: governance
+retention:
+  keep_daily: 7
+notify:
+  smtp_to: [ops@example.com]
+`,
+		},
+		{
+			name: "TOML",
+			file: "config.toml",
+			content: `
+auth_url = "https://example.auth.marketingcloudapis.com/"
+client_secret = "${TEST_CLIENT_SECRET}"
+business_unit = "${TEST_BUSINESS_UNIT:-default-bu}"
+schedule = "30 2 * * *"
+save_workers = 20
+storage_file_mode = "0640"
+staging_max_age = "36h"
+destinations = ["local", "s3"]
+s3_object_lock_mode = "governance"
+
+[retention]
+keep_daily = 7
+
+[notify]
+smtp_to = ["ops@example.com"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeFile(t, tt.file, tt.content))
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.auth.marketingcloudapis.com", cfg.AuthURL)
+			assert.Equal(t, "https://example.auth.marketingcloudapis.com/v2/token", cfg.TokenURL())
+			assert.Equal(t, "from-env", cfg.ClientSecret)
+			assert.Equal(t, "default-bu", cfg.BusinessUnit)
+			assert.Equal(t, "30 2 * * *", cfg.Schedule)
+			assert.Equal(t, 3, cfg.SaveWorkers, "environment overrides the file")
+			assert.Equal(t, 5, cfg.FetchWorkers, "defaults apply to missing keys")
+			assert.Equal(t, FileMode(0640), cfg.FileMode)
+			assert.Equal(t, 36*time.Hour, cfg.StagingAge)
+			assert.Equal(t, []string{"local", "s3"}, cfg.Destinations)
+			assert.Equal(t, "GOVERNANCE", cfg.S3LockMode)
+			assert.Equal(t, 7, cfg.Retention.KeepDaily)
+			assert.Equal(t, []string{"ops@example.com"}, cfg.Notify.SMTPTo)
+			assert.Equal(t, "0 8 * * *", cfg.Notify.DigestCron)
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			name:     "Unknown YAML key",
+			file:     "config.yaml",
+			content:  "shedule: \"0 1 * * *\"\n",
+			expected: []string{"line 1: field shedule not found"},
+		},
+		{
+			name:     "Wrong YAML type",
+			file:     "config.yaml",
+			content:  "fetch_workers: many\n",
+			expected: []string{"line 1: cannot unmarshal !!str `many` into int"},
+		},
+		{
+			name:     "Unknown TOML keys",
+			file:     "config.toml",
+			content:  "shedule = \"0 1 * * *\"\n[notify]\nsmtp_port = 25\n",
+			expected: []string{"unknown keys shedule, notify.smtp_port"},
+		},
+		{
+			name:     "Unset variable",
+			file:     "config.yaml",
+			content:  "client_secret: ${TEST_UNSET_A}\nclient_id: ${TEST_UNSET_B}\n",
+			expected: []string{"environment variables referenced but not set: TEST_UNSET_A, TEST_UNSET_B"},
+		},
+		{
+			name:     "Unsupported format",
+			file:     "config.json",
+			content:  "{}",
+			expected: []string{"unsupported config file format"},
+		},
+		{
+			name:     "Invalid environment",
+			env:      map[string]string{"FETCH_WORKERS": "many", "S3_LEGAL_HOLD": "yes", "STORAGE_DIR_MODE": "rwx"},
+			expected: []string{`FETCH_WORKERS: invalid integer "many"`, `S3_LEGAL_HOLD: invalid boolean "yes"`, `STORAGE_DIR_MODE: invalid file mode "rwx"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var path string
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.content)
+			}
+			_, err := Load(path)
+			require.Error(t, err)
+			for _, expected := range tt.expected {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	t.Setenv("SMTP_PASSWORD_FILE", writeFile(t, "password", "s3cret\n"))
+	t.Setenv("CLIENT_SECRET_FILE", writeFile(t, "client_secret", "client-s3cret"))
+	t.Setenv("CLIENT_SECRET", "")
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", cfg.Notify.SMTPPass)
+	assert.Equal(t, "client-s3cret", cfg.ClientSecret)
+
+	t.Setenv("S3_SECRET_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = Load("")
+	assert.ErrorContains(t, err, "S3_SECRET_KEY_FILE: open")
+}
+
+func TestLoad_SecretReferences(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/sfmc" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"client_secret":"from-vault","s3_secret_key":"s3-from-vault"}}}`))
+	}))
+	defer vault.Close()
+
+	path := writeFile(t, "config.yaml", `
+client_secret: vault:secret/sfmc#client_secret
+s3_secret_key: vault:secret/sfmc#s3_secret_key
+sftp_key_passphrase: file:`+writeFile(t, "passphrase", "from-file")+`
+notify:
+  slack_url: https://hooks.slack.com/services/T0/B0/x
+secrets:
+  vault_addr: `+vault.URL+`
+  vault_token: file:`+writeFile(t, "token", "vault-token\n")+`
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "from-vault", cfg.ClientSecret)
+	assert.Equal(t, "s3-from-vault", cfg.S3SecretKey)
+	assert.Equal(t, "from-file", cfg.SFTPKeyPass)
+	assert.Equal(t, "https://hooks.slack.com/services/T0/B0/x", cfg.Notify.SlackURL, "values that are not references are kept")
+
+	t.Setenv("CLIENT_SECRET", "vault:secret/other#client_secret")
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "CLIENT_SECRET: failed to resolve vault:secret/other#client_secret: secret not found")
+
+	// a reference to a store that is not configured is not taken for the secret
+	t.Setenv("CLIENT_SECRET", "vault:secret/sfmc#client_secret")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "secrets.vault_addr (VAULT_ADDR) is not set")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+
+	tests := []struct {
+		name     string
+		modify   func(cfg *Config)
+		expected []string
+	}{
+		{
+			name: "Missing credentials",
+			modify: func(cfg *Config) {
+				cfg.ClientID, cfg.ClientSecret, cfg.APIURL = "", "", "not a url"
+			},
+			expected: []string{"client_id (CLIENT_ID): is required", "client_secret (CLIENT_SECRET): is required", `api_url (API_URL): "not a url" is not a valid URL`},
+		},
+		{
+			name: "Schedule",
+			modify: func(cfg *Config) {
+				cfg.Schedule = "every day"
+				cfg.FullSchedule = "weekly"
+				cfg.Dedup = true
+				cfg.FetchWorkers = 0
+			},
+			expected: []string{
+				`schedule (SCHEDULE): invalid cron expression "every day"`,
+				`full_schedule (FULL_SCHEDULE): invalid cron expression "weekly"`,
+				"fetch_workers (FETCH_WORKERS): must be at least 1, got 0",
+			},
+		},
+		{
+			name: "Full snapshots without dedup",
+			modify: func(cfg *Config) {
+				cfg.FullSchedule = "0 3 * * 0"
+			},
+			expected: []string{"full_schedule (FULL_SCHEDULE): requires storage_dedup, only deduplicated folders record the snapshot chain"},
+		},
+		{
+			name: "Deleted assets",
+			modify: func(cfg *Config) {
+				cfg.Inventory = ""
+				cfg.Reconcile = -time.Hour
+				cfg.DeleteAlert = -1
+			},
+			expected: []string{
+				"inventory_file (INVENTORY_FILE): is required, use off to disable the detection of deleted assets",
+				"reconcile_interval (RECONCILE_INTERVAL): must not be negative, got -1h0m0s",
+				"deletion_alert_threshold (DELETION_ALERT_THRESHOLD): must not be negative, got -1",
+			},
+		},
+		{
+			name: "Data extensions",
+			modify: func(cfg *Config) {
+				cfg.SOAPURL = "soap"
+				cfg.DEFolders = []string{"123", "Lookups"}
+				cfg.DEFormat = "xlsx"
+			},
+			expected: []string{
+				`soap_url (SOAP_URL): "soap" is not a valid URL`,
+				`data_extension_folders (DATA_EXTENSION_FOLDERS): "Lookups" is not a folder ID`,
+				`data_extension_format (DATA_EXTENSION_FORMAT): unknown value "xlsx", expected one of csv, jsonl`,
+			},
+		},
+		{
+			name: "API token",
+			modify: func(cfg *Config) {
+				cfg.APIToken = "short"
+			},
+			expected: []string{"api_token (API_TOKEN): must be at least 16 characters"},
+		},
+		{
+			name: "Enums",
+			modify: func(cfg *Config) {
+				cfg.Layout = "pretty"
+				cfg.Notify.Mode = "sometimes"
+			},
+			expected: []string{`storage_layout (STORAGE_LAYOUT): unknown value "pretty", expected one of raw, human`, `notify.on (NOTIFY_ON): unknown value "sometimes"`},
+		},
+		{
+			name: "Destinations",
+			modify: func(cfg *Config) {
+				cfg.Destinations = []string{"s3", "gcs", "s3"}
+			},
+			expected: []string{`destinations (STORAGE_DESTINATIONS): unknown value "gcs"`, `"s3" is listed twice`, "s3_bucket (S3_BUCKET): is required by the s3 destination"},
+		},
+		{
+			name: "Ranges",
+			modify: func(cfg *Config) {
+				cfg.Tracing.SampleRatio = 1.5
+				cfg.Retention.KeepLast = -1
+			},
+			expected: []string{"tracing.sample_ratio (TRACE_SAMPLE_RATIO): must be between 0 and 1", "retention.keep_last (RETENTION_KEEP_LAST): must not be negative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			for _, expected := range tt.expected {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestConfig_SOAPServiceURL(t *testing.T) {
+	cfg := validConfig()
+	assert.Equal(t, "https://example.soap.marketingcloudapis.com/Service.asmx", cfg.SOAPServiceURL())
+	cfg.SOAPURL = "https://soap.example.com/Service.asmx"
+	assert.Equal(t, "https://soap.example.com/Service.asmx", cfg.SOAPServiceURL())
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.Destinations = []string{"local"}
+	cfg.Notify.SlackURL = "https://hooks.slack.com/services/T0/B0/x"
+	cfg.Notify.SMTPTo = []string{"ops@example.com"}
+	cfg.DEKeys, cfg.DEFolders = []string{"regions"}, []string{"123"}
+	assert.ElementsMatch(t, []string{"secret", "https://hooks.slack.com/services/T0/B0/x"}, cfg.Secrets())
+
+	for _, format := range []string{"yaml", "toml"} {
+		data, err := cfg.Redacted().Marshal(format)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "hooks.slack.com")
+		assert.Contains(t, string(data), redacted)
+
+		// the printed configuration can be loaded again
+		loaded, err := Load(writeFile(t, "config."+format, string(data)))
+		require.NoError(t, err)
+		assert.Equal(t, cfg.Redacted(), loaded)
+	}
+	assert.Equal(t, "secret", cfg.ClientSecret, "the original is not modified")
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// loadEnv overrides the fields of cfg whose environment variable is set and not empty.
+// Secrets can also be read from the file named by NAME_FILE, such as a Docker or
+// Kubernetes secret.
+func loadEnv(cfg *Config) error {
+	var errs []error
+	walkFields(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		name := tag.Get("env")
+		if name == "" {
+			return
+		}
+		value := os.Getenv(name)
+		if value == "" && tag.Get("secret") == "true" {
+			if path := os.Getenv(name + "_FILE"); path != "" {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s_FILE: %v", name, err))
+					return
+				}
+				value = strings.TrimSpace(string(data))
+			}
+		}
+		if value == "" {
+			return
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walkFields calls fn with every field of the struct v that is not itself a section
+func walkFields(v reflect.Value, fn func(field reflect.Value, tag reflect.StructTag)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			walkFields(field, fn)
+			continue
+		}
+		fn(field, v.Type().Field(i).Tag)
+	}
+}
+
+// setField parses an environment variable into a field
+func setField(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected e.g. 30m or 24h", value)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, expected true or false", value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${NAME} and ${NAME:-default}
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadFile decodes a YAML (.yaml, .yml) or TOML (.toml) file over cfg. Keys missing from
+// the file keep their value, unknown keys and values of the wrong type are rejected.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data, err = interpolate(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config file format, expected .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// interpolate replaces ${NAME} with the value of the environment variable NAME, and
+// ${NAME:-default} with default when NAME is empty. Unset variables without a default
+// are reported together.
+func interpolate(data []byte) ([]byte, error) {
+	missing := make(map[string]bool)
+	data = envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := envReference.FindSubmatch(ref)
+		if value := os.Getenv(string(match[1])); value != "" {
+			return []byte(value)
+		}
+		if match[2] != nil {
+			return match[3]
+		}
+		missing[string(match[1])] = true
+		return nil
+	})
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("environment variables referenced but not set: %s", strings.Join(names, ", "))
+	}
+	return data, nil
+}
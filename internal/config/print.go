@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in Redacted and in logs
+const redacted = "[REDACTED]"
+
+// Secrets returns the values of the secret settings that are set
+func (c Config) Secrets() []string {
+	var secrets []string
+	walkFields(reflect.ValueOf(&c).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("secret") == "true" && field.String() != "" {
+			secrets = append(secrets, field.String())
+		}
+	})
+	return secrets
+}
+
+// Redacted returns a copy of the configuration with the secrets that are set replaced
+func (c Config) Redacted() Config {
+	walkFields(reflect.ValueOf(&c).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(redacted)
+		}
+	})
+	return c
+}
+
+// Marshal encodes the configuration as a yaml or toml configuration file
+func (c Config) Marshal(format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "yaml":
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(c); err != nil {
+			return nil, fmt.Errorf("failed to encode config: %v", err)
+		}
+	case "toml":
+		if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+			return nil, fmt.Errorf("failed to encode config: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q, expected yaml or toml", format)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Feride3d/backup-creator/internal/secrets"
+)
+
+// resolveSecrets replaces the secret settings that reference a secret store, such as
+// vault:secret/sfmc#client_secret, with the secret
+func resolveSecrets(ctx context.Context, cfg *Config) error {
+	// the Vault token itself can only come from a file
+	token, err := secrets.NewResolver().Resolve(ctx, cfg.SecretStores.VaultToken)
+	if err != nil {
+		return fmt.Errorf("secrets.vault_token (VAULT_TOKEN): %v", err)
+	}
+	cfg.SecretStores.VaultToken = token
+
+	resolver, err := cfg.SecretStores.resolver()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	walkFields(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("secret") != "true" {
+			return
+		}
+		value, err := resolver.Resolve(ctx, field.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", tag.Get("env"), err))
+			return
+		}
+		field.SetString(value)
+	})
+	return errors.Join(errs...)
+}
+
+// resolver returns a resolver for file:, vault: and awssm: references. References to
+// Vault fail when it is not configured, rather than being taken for the secret.
+func (s SecretStores) resolver() (*secrets.Resolver, error) {
+	resolver := secrets.NewResolver()
+	if s.VaultAddr == "" {
+		resolver.Register("vault", unconfigured("secrets.vault_addr (VAULT_ADDR) is not set"))
+	} else {
+		vault, err := secrets.NewVault(secrets.VaultOptions{
+			Addr:      s.VaultAddr,
+			Token:     s.VaultToken,
+			Namespace: s.VaultNamespace,
+			KVVersion: s.VaultKV,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("secrets: %v", err)
+		}
+		resolver.Register("vault", vault)
+	}
+	manager, err := secrets.NewAWSSecretsManager(s.AWSRegion)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %v", err)
+	}
+	resolver.Register("awssm", manager)
+	return resolver, nil
+}
+
+// unconfigured is registered for secret stores that are referenced but not configured
+type unconfigured string
+
+func (u unconfigured) Secret(ctx context.Context, path, key string) (string, error) {
+	return "", errors.New(string(u))
+}
@@ -0,0 +1,240 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// contentField is the field of a content block that holds its HTML/text body
+const contentField = "content"
+
+// AssetRef identifies an asset in a diff report
+type AssetRef struct {
+	ID          int    `json:"id"`
+	CustomerKey string `json:"customerKey,omitempty"`
+	Name        string `json:"name"`
+}
+
+// FieldChange is a metadata field that differs between two versions of an asset
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// AssetChange describes how an asset differs between two backups
+type AssetChange struct {
+	AssetRef
+	Content  string        `json:"content,omitempty"`
+	Metadata []FieldChange `json:"metadata,omitempty"`
+}
+
+// Report is the result of comparing two sets of content blocks
+type Report struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Added    []AssetRef    `json:"added"`
+	Removed  []AssetRef    `json:"removed"`
+	Modified []AssetChange `json:"modified"`
+}
+
+// Compare returns the assets added, removed and modified between from and to
+func Compare(fromName string, from []model.ContentBlock, toName string, to []model.ContentBlock) (*Report, error) {
+	report := &Report{From: fromName, To: toName, Added: []AssetRef{}, Removed: []AssetRef{}, Modified: []AssetChange{}}
+
+	old := make(map[int]model.ContentBlock, len(from))
+	for _, b := range from {
+		old[b.ID] = b
+	}
+	current := make(map[int]model.ContentBlock, len(to))
+	for _, b := range to {
+		current[b.ID] = b
+	}
+
+	for _, b := range to {
+		if _, ok := old[b.ID]; !ok {
+			report.Added = append(report.Added, refOf(b))
+		}
+	}
+	for _, b := range from {
+		newer, ok := current[b.ID]
+		if !ok {
+			report.Removed = append(report.Removed, refOf(b))
+			continue
+		}
+		change, err := compareBlocks(b, newer, fromName, toName)
+		if err != nil {
+			return nil, fmt.Errorf("block ID %d: %w", b.ID, err)
+		}
+		if change != nil {
+			report.Modified = append(report.Modified, *change)
+		}
+	}
+
+	sort.Slice(report.Added, func(i, j int) bool { return report.Added[i].ID < report.Added[j].ID })
+	sort.Slice(report.Removed, func(i, j int) bool { return report.Removed[i].ID < report.Removed[j].ID })
+	sort.Slice(report.Modified, func(i, j int) bool { return report.Modified[i].ID < report.Modified[j].ID })
+	return report, nil
+}
+
+// Empty reports whether the compared sets are identical
+func (r *Report) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in a human-readable form
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Comparing %s -> %s\n", r.From, r.To)
+	fmt.Fprintf(&b, "%d added, %d removed, %d modified\n", len(r.Added), len(r.Removed), len(r.Modified))
+	for _, a := range r.Added {
+		fmt.Fprintf(&b, "\n+ %s\n", a)
+	}
+	for _, a := range r.Removed {
+		fmt.Fprintf(&b, "\n- %s\n", a)
+	}
+	for _, c := range r.Modified {
+		fmt.Fprintf(&b, "\n~ %s\n", c.AssetRef)
+		for _, f := range c.Metadata {
+			fmt.Fprintf(&b, "  %s: %s -> %s\n", f.Path, formatValue(f.Old), formatValue(f.New))
+		}
+		if c.Content != "" {
+			b.WriteString(c.Content)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (a AssetRef) String() string {
+	if a.CustomerKey != "" {
+		return fmt.Sprintf("%d [%s] %s", a.ID, a.CustomerKey, a.Name)
+	}
+	return fmt.Sprintf("%d %s", a.ID, a.Name)
+}
+
+func refOf(b model.ContentBlock) AssetRef {
+	return AssetRef{ID: b.ID, CustomerKey: b.CustomerKey, Name: b.Name}
+}
+
+func compareBlocks(from, to model.ContentBlock, fromName, toName string) (*AssetChange, error) {
+	oldFields, err := toMap(from)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := toMap(to)
+	if err != nil {
+		return nil, err
+	}
+
+	oldContent, err := contentText(oldFields[contentField])
+	if err != nil {
+		return nil, err
+	}
+	newContent, err := contentText(newFields[contentField])
+	if err != nil {
+		return nil, err
+	}
+	delete(oldFields, contentField)
+	delete(newFields, contentField)
+
+	change := &AssetChange{
+		AssetRef: refOf(to),
+		Content:  Unified(oldContent, newContent, fromName, toName),
+		Metadata: compareFields(oldFields, newFields),
+	}
+	if change.Content == "" && len(change.Metadata) == 0 {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// compareFields returns the JSON paths whose values differ between two decoded objects
+func compareFields(from, to map[string]interface{}) []FieldChange {
+	oldPaths := make(map[string]interface{})
+	flatten("$", from, oldPaths)
+	newPaths := make(map[string]interface{})
+	flatten("$", to, newPaths)
+
+	var changes []FieldChange
+	for p, v := range oldPaths {
+		if nv, ok := newPaths[p]; !ok || !reflect.DeepEqual(v, nv) {
+			changes = append(changes, FieldChange{Path: p, Old: v, New: newPaths[p]})
+		}
+	}
+	for p, v := range newPaths {
+		if _, ok := oldPaths[p]; !ok {
+			changes = append(changes, FieldChange{Path: p, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// flatten records every leaf value of v under its JSON path
+func flatten(path string, v interface{}, out map[string]interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			flatten(path+"."+k, child, out)
+		}
+	case []interface{}:
+		for i, child := range t {
+			flatten(fmt.Sprintf("%s[%d]", path, i), child, out)
+		}
+	default:
+		out[path] = v
+	}
+}
+
+func toMap(b model.ContentBlock) (map[string]interface{}, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal block: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode block: %v", err)
+	}
+	return fields, nil
+}
+
+// contentText returns string content as is and any other content as indented JSON
+func contentText(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	default:
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal content: %v", err)
+		}
+		return string(data), nil
+	}
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
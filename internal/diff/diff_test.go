@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 11, d, 0, 0, 0, 0, time.UTC) }
+	from := []model.ContentBlock{
+		{ID: 1, CustomerKey: "header", Name: "Header", ModifiedDate: day(1), Content: "<h1>Hello</h1>\n<p>Intro</p>\n"},
+		{ID: 2, CustomerKey: "footer", Name: "Footer", ModifiedDate: day(1), Content: "<p>Bye</p>"},
+		{ID: 3, CustomerKey: "legal", Name: "Legal", ModifiedDate: day(1), Content: "unchanged"},
+	}
+	to := []model.ContentBlock{
+		{ID: 1, CustomerKey: "header", Name: "Header v2", ModifiedDate: day(2), Content: "<h1>Hello</h1>\n<p>New intro</p>\n"},
+		{ID: 3, CustomerKey: "legal", Name: "Legal", ModifiedDate: day(1), Content: "unchanged"},
+		{ID: 4, CustomerKey: "banner", Name: "Banner", ModifiedDate: day(2), Content: "<img>"},
+	}
+
+	report, err := Compare("backup_20241101", from, "backup_20241102", to)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []AssetRef{{ID: 4, CustomerKey: "banner", Name: "Banner"}}, report.Added)
+	assert.Equal(t, []AssetRef{{ID: 2, CustomerKey: "footer", Name: "Footer"}}, report.Removed)
+	assert.Len(t, report.Modified, 1)
+
+	change := report.Modified[0]
+	assert.Equal(t, 1, change.ID)
+	assert.Equal(t, "--- backup_20241101\n+++ backup_20241102\n@@ -1,2 +1,2 @@\n <h1>Hello</h1>\n-<p>Intro</p>\n+<p>New intro</p>\n", change.Content)
+	assert.Equal(t, []FieldChange{
+		{Path: "$.modifiedDate", Old: "2024-11-01T00:00:00Z", New: "2024-11-02T00:00:00Z"},
+		{Path: "$.name", Old: "Header", New: "Header v2"},
+	}, change.Metadata)
+
+	var text bytes.Buffer
+	assert.NoError(t, report.WriteText(&text))
+	assert.Contains(t, text.String(), "1 added, 1 removed, 1 modified")
+	assert.Contains(t, text.String(), "~ 1 [header] Header v2")
+	assert.Contains(t, text.String(), `$.name: "Header" -> "Header v2"`)
+
+	var out bytes.Buffer
+	assert.NoError(t, report.WriteJSON(&out))
+	var decoded Report
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Len(t, decoded.Modified, 1)
+}
+
+func TestUnified(t *testing.T) {
+	assert.Empty(t, Unified("same", "same", "a", "b"))
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n"
+	assert.Equal(t, "--- a\n+++ b\n"+
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n"+
+		"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n", Unified(a, b, "a", "b"))
+}
+
+func TestUnified_LargeText(t *testing.T) {
+	lines := make([]string, 50000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i)
+	}
+	a := strings.Join(lines, "\n") + "\n"
+	lines[20000] = "changed"
+	b := strings.Join(lines, "\n") + "\n"
+	assert.Equal(t, "--- a\n+++ b\n"+
+		"@@ -19998,7 +19998,7 @@\n line 19997\n line 19998\n line 19999\n-line 20000\n+changed\n line 20001\n line 20002\n line 20003\n",
+		Unified(a, b, "a", "b"))
+
+	// texts differing in more lines than the search is bounded to are shown as replaced
+	other := make([]string, maxEdits)
+	for i := range other {
+		other[i] = fmt.Sprintf("other %d", i)
+	}
+	ops := diffLines(lines[:maxEdits], other)
+	require.Len(t, ops, 2*maxEdits)
+	assert.Equal(t, opDelete, ops[0].kind)
+	assert.Equal(t, opInsert, ops[len(ops)-1].kind)
+}
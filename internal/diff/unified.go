@@ -0,0 +1,209 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change
+const contextLines = 3
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	line string
+	a, b int // line index in the old and new text
+}
+
+// Unified returns a unified diff of two texts, or an empty string if they are equal
+func Unified(a, b, fromName, toName string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(ops); {
+		// find the next change and the extent of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == opEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		lo := max(first-contextLines, start)
+		hi := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != opEqual {
+				hi = i
+			} else if i-hi > 2*contextLines {
+				break
+			}
+		}
+		hi = min(hi+contextLines+1, len(ops))
+		writeHunk(&out, ops[lo:hi])
+		start = hi
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []op) {
+	var aStart, bStart, aLen, bLen int
+	aStart, bStart = -1, -1
+	for _, o := range ops {
+		if o.kind != opInsert {
+			if aStart < 0 {
+				aStart = o.a
+			}
+			aLen++
+		}
+		if o.kind != opDelete {
+			if bStart < 0 {
+				bStart = o.b
+			}
+			bLen++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen, ops[0].a), hunkRange(bStart, bLen, ops[0].b))
+	for _, o := range ops {
+		fmt.Fprintf(out, "%c%s\n", o.kind, o.line)
+	}
+}
+
+func hunkRange(start, length, fallback int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", fallback)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// maxEdits bounds the edit distance searched by diffLines, past which the changed lines are
+// shown as entirely replaced. The search keeps O(d²) state for d edits.
+const maxEdits = 2000
+
+// diffLines computes a line edit script with Myers' algorithm, in O((n+m)·d) time for
+// d inserted and deleted lines, after trimming the common prefix and suffix
+func diffLines(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{opEqual, a[i], i, i})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix)...)
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, op{opEqual, a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	return ops
+}
+
+// myers returns the shortest edit script turning a into b, deletions first within a
+// change. offset is the line index of a[0] and b[0] in the whole texts.
+func myers(a, b []string, offset int) []op {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	// v[k] is the furthest x reached on diagonal k = x - y, trace[d] is v after d edits
+	// for k in [-d, d]
+	size := n + m
+	v := make([]int, 2*size+2)
+	var trace [][]int
+	for d := 0; d <= size; d++ {
+		if d > maxEdits {
+			return replaceLines(a, b, offset)
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[size+k-1] < v[size+k+1]) {
+				x = v[size+k+1]
+			} else {
+				x = v[size+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[size+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[size-d:size+d+1]...))
+				return backtrack(a, b, trace, offset)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[size-d:size+d+1]...))
+	}
+	return replaceLines(a, b, offset)
+}
+
+// backtrack walks the trace of myers back from the end of both texts
+func backtrack(a, b []string, trace [][]int, offset int) []op {
+	x, y := len(a), len(b)
+	var ops []op
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{opEqual, a[x], offset + x, offset + y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, op{opInsert, b[y], offset + x, offset + y})
+		} else {
+			x--
+			ops = append(ops, op{opDelete, a[x], offset + x, offset + y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, op{opEqual, a[x], offset + x, offset + y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceLines deletes every line of a and inserts every line of b
+func replaceLines(a, b []string, offset int) []op {
+	ops := make([]op, 0, len(a)+len(b))
+	for i, line := range a {
+		ops = append(ops, op{opDelete, line, offset + i, offset})
+	}
+	for j, line := range b {
+		ops = append(ops, op{opInsert, line, offset + len(a), offset + j})
+	}
+	return ops
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,90 @@
+// Package health serves liveness and readiness probes
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Checker runs named checks concurrently, each bounded by a timeout
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  []Check
+}
+
+// NewChecker creates a checker whose checks fail after timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check under name
+func (c *Checker) Add(name string, check Check) {
+	c.names = append(c.names, name)
+	c.checks = append(c.checks, check)
+}
+
+// Run executes all checks
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{OK: true, Checks: make(map[string]CheckResult, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := CheckResult{OK: true}
+			if err := check(ctx); err != nil {
+				result = CheckResult{Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			report.OK = report.OK && result.OK
+		}(c.names[i], check)
+	}
+	wg.Wait()
+	return report
+}
+
+// Handler responds 200 when every check passes and 503 otherwise, with the report as JSON
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		status := http.StatusOK
+		if !report.OK {
+			status = http.StatusServiceUnavailable
+		}
+		WriteJSON(w, status, report)
+	})
+}
+
+// WriteJSON writes v as an indented JSON response
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
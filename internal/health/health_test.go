@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Handler(t *testing.T) {
+	tests := []struct {
+		name           string
+		checks         map[string]Check
+		expectedStatus int
+		expectedChecks map[string]CheckResult
+	}{
+		{
+			name: "All checks pass",
+			checks: map[string]Check{
+				"config":  func(ctx context.Context) error { return nil },
+				"storage": func(ctx context.Context) error { return nil },
+			},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]CheckResult{"config": {OK: true}, "storage": {OK: true}},
+		},
+		{
+			name: "One check fails",
+			checks: map[string]Check{
+				"config": func(ctx context.Context) error { return nil },
+				"token":  func(ctx context.Context) error { return errors.New("invalid client") },
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]CheckResult{"config": {OK: true}, "token": {Error: "invalid client"}},
+		},
+		{
+			name: "Check times out",
+			checks: map[string]Check{
+				"storage": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]CheckResult{"storage": {Error: "context deadline exceeded"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			checker.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			var report Report
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, report.OK)
+			assert.Equal(t, tt.expectedChecks, report.Checks)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Package history keeps an index of backup runs and of every asset version they saved, so
+// that questions like "when was asset 4512 last backed up?" need no scan of the storage
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultFile is the index file used unless configured
+const DefaultFile = "history.db"
+
+// openTimeout bounds the wait for the lock held by another process using the index
+const openTimeout = 5 * time.Second
+
+var (
+	runsBucket   = []byte("runs")
+	assetsBucket = []byte("assets")
+)
+
+// Run is a backup run
+type Run struct {
+	RunID        string                    `json:"runId"`
+	Folder       string                    `json:"folder,omitempty"`
+	StartedAt    time.Time                 `json:"startedAt"`
+	FinishedAt   time.Time                 `json:"finishedAt"`
+	Success      bool                      `json:"success"`
+	Error        string                    `json:"error,omitempty"`
+	Progress     model.Progress            `json:"progress"`
+	Destinations []model.DestinationResult `json:"destinations,omitempty"`
+}
+
+// RunFromReport returns the run described by a run report
+func RunFromReport(report *model.RunReport) Run {
+	report = report.Copy()
+	return Run{
+		RunID:        report.RunID,
+		Folder:       report.Folder,
+		StartedAt:    report.StartedAt,
+		FinishedAt:   report.FinishedAt,
+		Success:      report.Success,
+		Error:        report.Error,
+		Progress:     report.Progress,
+		Destinations: report.Destinations,
+	}
+}
+
+// Version is a version of an asset saved to a backup folder of a destination. A tombstone,
+// recorded when a run found the asset deleted from Marketing Cloud, is a version with
+// DeletedAt and without a destination.
+type Version struct {
+	AssetID      int        `json:"assetId"`
+	CustomerKey  string     `json:"customerKey,omitempty"`
+	Name         string     `json:"name"`
+	ModifiedDate time.Time  `json:"modifiedDate"`
+	SHA256       string     `json:"sha256"`
+	Size         int        `json:"size"`
+	Folder       string     `json:"folder"`
+	Destination  string     `json:"destination"`
+	Object       string     `json:"object"`
+	RunID        string     `json:"runId,omitempty"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+}
+
+// key orders the versions of an asset by folder, so by date
+func (v Version) key() []byte {
+	return []byte(v.Folder + "\x00" + v.Destination)
+}
+
+// Index is the run and asset index, stored in a BoltDB file
+type Index struct {
+	db *bolt.DB
+}
+
+// Open opens the index at path for writing, creating it if needed. Only one process can
+// have it open for writing, others wait up to 5 seconds for it.
+func Open(path string) (*Index, error) {
+	return open(path, false)
+}
+
+// OpenReadOnly opens an existing index at path for queries
+func OpenReadOnly(path string) (*Index, error) {
+	return open(path, true)
+}
+
+func open(path string, readOnly bool) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("history index %s is in use by another process, try again later", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history index %s: %v", path, err)
+	}
+	index := &Index{db: db}
+	if readOnly {
+		return index, nil
+	}
+	if err := index.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{runsBucket, assetsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history index %s: %v", path, err)
+	}
+	return index, nil
+}
+
+// Close releases the index file
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+// Reset removes every run and version
+func (i *Index) Reset() error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{runsBucket, assetsBucket} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Record stores a run and the versions it saved. A version already recorded for the same
+// folder and destination is replaced when its hash changed, and kept with the run that
+// first saved it otherwise.
+func (i *Index) Record(run Run, versions []Version) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		if run.RunID != "" {
+			data, err := json.Marshal(run)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(runsBucket).Put([]byte(run.RunID), data); err != nil {
+				return err
+			}
+		}
+
+		assets := tx.Bucket(assetsBucket)
+		for _, v := range versions {
+			bucket, err := assets.CreateBucketIfNotExists(assetKey(v.AssetID))
+			if err != nil {
+				return err
+			}
+			if data := bucket.Get(v.key()); data != nil {
+				var existing Version
+				if json.Unmarshal(data, &existing) == nil && existing.SHA256 == v.SHA256 {
+					continue
+				}
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(v.key(), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs returns up to limit runs, newest first, or all of them when limit is 0
+func (i *Index) Runs(limit int) ([]Run, error) {
+	runs := []Run{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, data := c.Last(); k != nil && (limit <= 0 || len(runs) < limit); k, data = c.Prev() {
+			var run Run
+			if err := json.Unmarshal(data, &run); err != nil {
+				return fmt.Errorf("failed to decode run %s: %v", k, err)
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// AssetVersions returns the versions of an asset, newest folder first
+func (i *Index) AssetVersions(assetID int) ([]Version, error) {
+	versions := []Version{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		assets := tx.Bucket(assetsBucket)
+		if assets == nil {
+			return nil
+		}
+		bucket := assets.Bucket(assetKey(assetID))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, data := c.Last(); k != nil; k, data = c.Prev() {
+			var v Version
+			if err := json.Unmarshal(data, &v); err != nil {
+				return fmt.Errorf("failed to decode version of asset %d: %v", assetID, err)
+			}
+			versions = append(versions, v)
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// assetKey encodes an asset ID so that keys sort numerically
+func assetKey(assetID int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(assetID))
+	return key
+}
@@ -0,0 +1,202 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	index, err := Open(path)
+	require.NoError(t, err)
+
+	version := func(folder, hash, runID string) Version {
+		return Version{AssetID: 4512, Name: "Welcome", SHA256: hash, Folder: folder, Destination: "local", RunID: runID}
+	}
+	require.NoError(t, index.Record(Run{RunID: "20241121T000000Z", Folder: "backup_20241121", Success: true}, []Version{
+		version("backup_20241121", "aaa", "20241121T000000Z"),
+		{AssetID: 7, SHA256: "ccc", Folder: "backup_20241121", Destination: "local"},
+	}))
+	require.NoError(t, index.Record(Run{RunID: "20241122T000000Z", Folder: "backup_20241122", Success: true}, []Version{
+		version("backup_20241122", "bbb", "20241122T000000Z"),
+	}))
+	// a later run into the same folder keeps unchanged versions with the run that saved them
+	require.NoError(t, index.Record(Run{RunID: "20241122T120000Z", Folder: "backup_20241122", Error: "disk full"}, []Version{
+		version("backup_20241122", "bbb", "20241122T120000Z"),
+	}))
+	require.NoError(t, index.Close())
+
+	// queries open the index read-only
+	index, err = OpenReadOnly(path)
+	require.NoError(t, err)
+	defer index.Close()
+
+	versions, err := index.AssetVersions(4512)
+	require.NoError(t, err)
+	assert.Equal(t, []Version{
+		version("backup_20241122", "bbb", "20241122T000000Z"),
+		version("backup_20241121", "aaa", "20241121T000000Z"),
+	}, versions)
+
+	versions, err = index.AssetVersions(1)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+
+	runs, err := index.Runs(2)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "20241122T120000Z", runs[0].RunID)
+	assert.Equal(t, "disk full", runs[0].Error)
+	assert.Equal(t, "20241122T000000Z", runs[1].RunID)
+
+	runs, err = index.Runs(0)
+	require.NoError(t, err)
+	assert.Len(t, runs, 3)
+}
+
+// saveFolder backs up blocks to a folder the way a run does and writes its report
+func saveFolder(t *testing.T, store *storage.LocalStorage, dedup bool, folder string, report *model.RunReport, blocks ...model.ContentBlock) {
+	t.Helper()
+	ctx := model.WithRunReport(model.WithRunID(context.Background(), report.RunID), report)
+	if dedup {
+		dedupStorage := storage.NewDedupStorage(store)
+		require.NoError(t, dedupStorage.SaveContentBlocks(ctx, blocks, folder))
+		require.NoError(t, dedupStorage.FinalizeFolder(ctx, folder))
+	} else {
+		require.NoError(t, store.SaveContentBlocks(ctx, blocks, folder))
+		require.NoError(t, store.FinalizeFolder(ctx, folder))
+	}
+	report.Folder = folder
+	require.NoError(t, storage.NewReportWriter(store, false).WriteReport(ctx, report))
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	local := storage.NewLocalStorage(t.TempDir())
+	mirror := storage.NewLocalStorage(t.TempDir())
+	modified := time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	saveFolder(t, local, true, "backup_20241121", &model.RunReport{RunID: "20241121T000000Z", Success: true},
+		model.ContentBlock{ID: 4512, CustomerKey: "welcome", Name: "Welcome", ModifiedDate: modified, Content: "v1"},
+		model.ContentBlock{ID: 7, Name: "Footer", Content: "v1"})
+	saveFolder(t, local, true, "backup_20241122", &model.RunReport{RunID: "20241122T000000Z", Success: true},
+		model.ContentBlock{ID: 4512, CustomerKey: "welcome", Name: "Welcome", ModifiedDate: modified.Add(24 * time.Hour), Content: "v2"})
+	saveFolder(t, mirror, false, "backup_20241122", &model.RunReport{RunID: "20241122T000000Z", Success: true},
+		model.ContentBlock{ID: 4512, CustomerKey: "welcome", Name: "Welcome", ModifiedDate: modified.Add(24 * time.Hour), Content: "v2"})
+
+	index, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer index.Close()
+	// entries of a previous index are replaced
+	require.NoError(t, index.Record(Run{RunID: "stale"}, []Version{{AssetID: 1, Folder: "backup_20200101", Destination: "local"}}))
+
+	stats, err := Rebuild(ctx, index, []Source{{Name: "local", Store: local}, {Name: "mirror", Store: mirror}})
+	require.NoError(t, err)
+	assert.Equal(t, RebuildStats{Folders: 3, Runs: 2, Versions: 4}, stats)
+
+	versions, err := index.AssetVersions(4512)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "backup_20241122", versions[0].Folder)
+	assert.Equal(t, "mirror", versions[0].Destination)
+	assert.Equal(t, "backup_20241122/4512.json", versions[0].Object)
+	assert.Equal(t, "local", versions[1].Destination)
+	assert.Equal(t, "20241122T000000Z", versions[1].RunID)
+	assert.Equal(t, "welcome", versions[1].CustomerKey)
+	assert.Equal(t, modified.Add(24*time.Hour), versions[1].ModifiedDate)
+	assert.Equal(t, storage.ObjectKey(versions[1].SHA256), versions[1].Object)
+	assert.Equal(t, "backup_20241121", versions[2].Folder)
+	assert.NotEqual(t, versions[1].SHA256, versions[2].SHA256)
+
+	versions, err = index.AssetVersions(1)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	runs, err := index.Runs(0)
+	require.NoError(t, err)
+	assert.Len(t, runs, 2)
+}
+
+func TestRecorder_RecordRun(t *testing.T) {
+	ctx := context.Background()
+	local := storage.NewLocalStorage(t.TempDir())
+	mirror := storage.NewLocalStorage(t.TempDir())
+	block := model.ContentBlock{ID: 4512, Name: "Welcome", Content: "v1"}
+	report := &model.RunReport{
+		RunID:   "20241121T000000Z",
+		Success: false,
+		Error:   "failed to save content blocks: 1 of 2 destinations failed",
+		Destinations: []model.DestinationResult{
+			{Name: "local", Saved: 1, OK: true},
+			{Name: "mirror", Failed: 1, Error: "disk full"},
+		},
+	}
+	saveFolder(t, local, false, "backup_20241121", report, block)
+	saveFolder(t, mirror, false, "backup_20241121", report, block)
+
+	path := filepath.Join(t.TempDir(), "history.db")
+	recorder := NewRecorder(path, []Source{{Name: "local", Store: local}, {Name: "mirror", Store: mirror}})
+	require.NoError(t, recorder.RecordRun(ctx, report))
+	// runs that failed before saving have no folder
+	require.NoError(t, recorder.RecordRun(ctx, &model.RunReport{RunID: "20241122T000000Z", Error: "failed to fetch content blocks"}))
+
+	index, err := OpenReadOnly(path)
+	require.NoError(t, err)
+	defer index.Close()
+
+	versions, err := index.AssetVersions(4512)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, "local", versions[0].Destination)
+	assert.Equal(t, "20241121T000000Z", versions[0].RunID)
+
+	runs, err := index.Runs(0)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "failed to fetch content blocks", runs[0].Error)
+	assert.Equal(t, report.Destinations, runs[1].Destinations)
+}
+
+func TestRecorder_RecordRunTombstones(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewLocalStorage(t.TempDir())
+	deletedAt := time.Date(2024, 11, 22, 0, 0, 0, 0, time.UTC)
+	saveFolder(t, store, false, "backup_20241121", &model.RunReport{RunID: "20241121T000000Z"}, model.ContentBlock{ID: 4512, Name: "Welcome", Content: "v1"})
+	report := &model.RunReport{RunID: "20241122T000000Z", Success: true}
+	report.AddDeleted([]model.Tombstone{{AssetRef: model.AssetRef{ID: 4512, Name: "Welcome"}, DetectedAt: deletedAt}})
+	// the dedup manifest keeps the tombstones of a run without changes
+	saveFolder(t, store, true, "backup_20241122", report)
+
+	path := filepath.Join(t.TempDir(), "history.db")
+	recorder := NewRecorder(path, []Source{{Name: "local", Store: store}})
+	require.NoError(t, recorder.RecordRun(ctx, report))
+
+	index, err := OpenReadOnly(path)
+	require.NoError(t, err)
+	versions, err := index.AssetVersions(4512)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, "backup_20241122", versions[0].Folder)
+	require.NotNil(t, versions[0].DeletedAt)
+	assert.True(t, deletedAt.Equal(*versions[0].DeletedAt))
+	require.NoError(t, index.Close())
+
+	// a rebuild reads the tombstones from the reports
+	index, err = Open(path)
+	require.NoError(t, err)
+	defer index.Close()
+	stats, err := Rebuild(ctx, index, []Source{{Name: "local", Store: store}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Runs)
+	versions, err = index.AssetVersions(4512)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.NotNil(t, versions[0].DeletedAt)
+	assert.Equal(t, "local", versions[1].Destination)
+}
@@ -0,0 +1,159 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/storage"
+)
+
+// Source is a destination whose backup folders are indexed
+type Source struct {
+	Name  string
+	Store storage.ObjectStore
+}
+
+// folderVersions returns the versions stored in a backup folder of a source, read from
+// its manifest or from the raw files of folders without one
+func folderVersions(ctx context.Context, source Source, folder, runID string) ([]Version, error) {
+	entries, err := storage.LoadFolderEntries(ctx, source.Store, folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", folder, source.Name, err)
+	}
+	versions := make([]Version, 0, len(entries))
+	for _, e := range entries {
+		versions = append(versions, Version{
+			AssetID:      e.ID,
+			CustomerKey:  e.CustomerKey,
+			Name:         e.Name,
+			ModifiedDate: e.ModifiedDate,
+			SHA256:       e.SHA256,
+			Size:         e.Size,
+			Folder:       folder,
+			Destination:  source.Name,
+			Object:       e.Object,
+			RunID:        runID,
+		})
+	}
+	return versions, nil
+}
+
+// tombstoneVersions returns the tombstones of the assets a run found deleted
+func tombstoneVersions(tombstones []model.Tombstone, folder, runID string) []Version {
+	versions := make([]Version, 0, len(tombstones))
+	for _, t := range tombstones {
+		detectedAt := t.DetectedAt
+		versions = append(versions, Version{
+			AssetID:     t.ID,
+			CustomerKey: t.CustomerKey,
+			Name:        t.Name,
+			Folder:      folder,
+			RunID:       runID,
+			DeletedAt:   &detectedAt,
+		})
+	}
+	return versions
+}
+
+// Recorder indexes each finished run. The index is only opened while a run is recorded,
+// so that the history commands can read it in between.
+type Recorder struct {
+	path    string
+	sources []Source
+}
+
+func NewRecorder(path string, sources []Source) *Recorder {
+	return &Recorder{path: path, sources: sources}
+}
+
+// RecordRun indexes a run, the versions in its folder on every destination that saved
+// all of its assets and the assets it found deleted
+func (r *Recorder) RecordRun(ctx context.Context, report *model.RunReport) error {
+	run := RunFromReport(report)
+	failed := make(map[string]bool)
+	for _, d := range run.Destinations {
+		failed[d.Name] = !d.OK
+	}
+
+	var versions []Version
+	var errs []error
+	if run.Folder != "" {
+		for _, source := range r.sources {
+			if failed[source.Name] {
+				continue
+			}
+			v, err := folderVersions(ctx, source, run.Folder, run.RunID)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			versions = append(versions, v...)
+		}
+		versions = append(versions, tombstoneVersions(report.DeletedAssets(), run.Folder, run.RunID)...)
+	}
+
+	index, err := Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	if err := index.Record(run, versions); err != nil {
+		return fmt.Errorf("failed to record run %s: %v", run.RunID, err)
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "Recorded run in the history index", "versions", len(versions))
+	return errors.Join(errs...)
+}
+
+// RebuildStats counts what a rebuild indexed
+type RebuildStats struct {
+	Folders  int `json:"folders"`
+	Runs     int `json:"runs"`
+	Versions int `json:"versions"`
+}
+
+// Rebuild recreates the index from storage: a run and tombstones from the report of each
+// backup folder and a version from each entry of its manifest
+func Rebuild(ctx context.Context, index *Index, sources []Source) (RebuildStats, error) {
+	var stats RebuildStats
+	if err := index.Reset(); err != nil {
+		return stats, fmt.Errorf("failed to reset history index: %v", err)
+	}
+
+	runs := make(map[string]bool)
+	for _, source := range sources {
+		folders, err := storage.ListBackupFolders(ctx, source.Store)
+		if err != nil {
+			return stats, fmt.Errorf("failed to list backup folders of %s: %w", source.Name, err)
+		}
+		for _, folder := range folders {
+			var run Run
+			var tombstones []model.Tombstone
+			report, err := storage.LoadReport(ctx, source.Store, folder.Name)
+			if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+				return stats, err
+			}
+			if report != nil {
+				run = RunFromReport(report)
+				tombstones = report.DeletedAssets()
+			}
+			versions, err := folderVersions(ctx, source, folder.Name, run.RunID)
+			if err != nil {
+				return stats, err
+			}
+			versions = append(versions, tombstoneVersions(tombstones, folder.Name, run.RunID)...)
+			if err := index.Record(run, versions); err != nil {
+				return stats, fmt.Errorf("failed to record %s: %v", folder.Name, err)
+			}
+			stats.Folders++
+			stats.Versions += len(versions)
+			if run.RunID != "" && !runs[run.RunID] {
+				runs[run.RunID] = true
+				stats.Runs++
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,188 @@
+// Package logging builds the structured logger used across the application. Records are
+// enriched with the attributes carried by the context, such as the run ID, and secrets
+// are redacted before they are written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// Redacted replaces secret values in log output
+const Redacted = "[REDACTED]"
+
+// Options configures the logger created by New
+type Options struct {
+	Format    string     // "text" (default) or "json"
+	Level     string     // "debug", "info" (default), "warn" or "error"
+	Secrets   []string   // values redacted wherever they appear in a record
+	SecretSet *SecretSet // further values redacted, which can be added while the logger is in use
+}
+
+// SecretSet holds secrets to redact that can change while loggers use them, such as
+// rotated credentials. The zero value is empty and ready to use.
+type SecretSet struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// Add adds secrets to the set, ignoring empty values and values already in it
+func (s *SecretSet) Add(secrets ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" && !slices.Contains(s.values, secret) {
+			s.values = append(s.values, secret)
+		}
+	}
+}
+
+func (s *SecretSet) redact(value string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, secret := range s.values {
+		value = strings.ReplaceAll(value, secret, Redacted)
+	}
+	return value
+}
+
+// New creates a logger writing to w
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	handlerOpts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactor(opts.Secrets, opts.SecretSet),
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", opts.Format)
+	}
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// ParseLevel parses a level name, defaulting to info when empty
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+type attrsKey struct{}
+
+type loggerKey struct{}
+
+// With returns a context whose log records carry the given attributes, e.g.
+// With(ctx, "asset_id", 42)
+func With(ctx context.Context, args ...any) context.Context {
+	record := slog.Record{}
+	record.Add(args...)
+	attrs := append([]slog.Attr(nil), Attrs(ctx)...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// Attrs returns the attributes stored in ctx with With
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// WithLogger returns a context carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// contextHandler adds the run ID and the attributes stored with With to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if runID := model.RunIDFromContext(ctx); runID != "" {
+		r.AddAttrs(slog.String("run_id", runID))
+	}
+	r.AddAttrs(Attrs(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// sensitiveKeys are attribute names whose values are never logged
+var sensitiveKeys = []string{"token", "secret", "password", "passphrase", "authorization", "secret_key", "access_key"}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if key == sensitive || strings.HasSuffix(key, "_"+sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactor hides the values of sensitive attributes and any occurrence of a known secret,
+// including inside the message and error values
+func redactor(secrets []string, set *SecretSet) func(groups []string, a slog.Attr) slog.Attr {
+	known := &SecretSet{}
+	known.Add(secrets...)
+	redact := func(s string) string {
+		s = known.redact(s)
+		if set != nil {
+			s = set.redact(s)
+		}
+		return s
+	}
+
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if isSensitiveKey(a.Key) {
+			return slog.String(a.Key, Redacted)
+		}
+		switch a.Value.Kind() {
+		case slog.KindString:
+			return slog.String(a.Key, redact(a.Value.String()))
+		case slog.KindAny:
+			if err, ok := a.Value.Any().(error); ok {
+				return slog.String(a.Key, redact(err.Error()))
+			}
+		}
+		return a
+	}
+}
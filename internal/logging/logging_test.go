@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_ContextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Format: "json"})
+	assert.NoError(t, err)
+
+	ctx := model.WithRunID(context.Background(), "20241121T000000Z")
+	ctx = With(ctx, "asset_id", 42)
+	logger.With("bu", "510000001").InfoContext(ctx, "Saved block")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Saved block", record["msg"])
+	assert.Equal(t, "20241121T000000Z", record["run_id"])
+	assert.Equal(t, float64(42), record["asset_id"])
+	assert.Equal(t, "510000001", record["bu"])
+}
+
+func TestNew_Redaction(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(l *slog.Logger)
+	}{
+		{
+			name: "Sensitive attribute key",
+			log:  func(l *slog.Logger) { l.Info("Authenticated", "client_secret", "s3cr3t") },
+		},
+		{
+			name: "Secret in message",
+			log:  func(l *slog.Logger) { l.Info("Token request with s3cr3t failed") },
+		},
+		{
+			name: "Secret in error",
+			log:  func(l *slog.Logger) { l.Info("Request failed", "error", errors.New("bad credentials s3cr3t")) },
+		},
+		{
+			name: "Token value",
+			log: func(l *slog.Logger) {
+				l.Info("Refreshed", "current", model.Token{AccessToken: "s3cr3t"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(&buf, Options{Format: "text", Secrets: []string{"s3cr3t", ""}})
+			assert.NoError(t, err)
+
+			tt.log(logger)
+			assert.NotContains(t, buf.String(), "s3cr3t")
+			assert.Contains(t, buf.String(), Redacted)
+		})
+	}
+}
+
+func TestNew_SecretSet(t *testing.T) {
+	var buf bytes.Buffer
+	secrets := &SecretSet{}
+	logger, err := New(&buf, Options{SecretSet: secrets})
+	assert.NoError(t, err)
+
+	logger.Info("Token request", "body", "client_secret=rotated")
+	assert.Contains(t, buf.String(), "client_secret=rotated")
+
+	// a secret added after the logger was created is redacted from then on
+	buf.Reset()
+	secrets.Add("rotated", "")
+	logger.Info("Token request", "body", "client_secret=rotated")
+	assert.NotContains(t, buf.String(), "rotated")
+	assert.Contains(t, buf.String(), "client_secret="+Redacted)
+}
+
+func TestNew_Options(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Level: "warn"})
+	assert.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.True(t, strings.Contains(buf.String(), "msg=shown"))
+
+	_, err = New(&buf, Options{Format: "xml"})
+	assert.Error(t, err)
+	_, err = New(&buf, Options{Level: "verbose"})
+	assert.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{})
+	assert.NoError(t, err)
+
+	assert.NotNil(t, FromContext(context.Background()))
+	assert.Same(t, logger, FromContext(WithLogger(context.Background(), logger)))
+}
@@ -0,0 +1,148 @@
+// Package metrics defines the Prometheus metrics exposed on /metrics. Collectors are
+// package level, following the Prometheus convention, and registered with Registry.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "backup_creator"
+
+// Registry holds the application metrics together with the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Runs counts backup runs by outcome: success or failure
+	Runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Backup runs by outcome.",
+	}, []string{"outcome"})
+
+	// RunDuration observes how long backup runs take
+	RunDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of backup runs.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	})
+
+	// AssetsFetched counts assets fetched from Marketing Cloud
+	AssetsFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "assets_fetched_total",
+		Help:      "Assets fetched from Marketing Cloud.",
+	})
+
+	// AssetsSaved counts assets saved to storage
+	AssetsSaved = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "assets_saved_total",
+		Help:      "Assets saved to storage.",
+	})
+
+	// AssetsFailed counts assets that could not be saved
+	AssetsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "assets_failed_total",
+		Help:      "Assets that failed to save.",
+	})
+
+	// AssetsDeleted counts assets found deleted from Marketing Cloud
+	AssetsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "assets_deleted_total",
+		Help:      "Assets found deleted from Marketing Cloud.",
+	})
+
+	// BytesWritten counts bytes written by storage backend
+	BytesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_bytes_written_total",
+		Help:      "Bytes written per storage backend.",
+	}, []string{"backend"})
+
+	// RequestDuration observes Marketing Cloud request latency by endpoint and status code
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sfmc_request_duration_seconds",
+		Help:      "Latency of Marketing Cloud API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+
+	// TokenRefreshes counts access tokens obtained after the initial one
+	TokenRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Access token refreshes.",
+	})
+
+	// Retries counts retried Marketing Cloud requests by endpoint
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sfmc_retries_total",
+		Help:      "Retried Marketing Cloud API requests.",
+	}, []string{"endpoint"})
+
+	// LastSuccess is the Unix time of the last successful backup run
+	LastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful backup run.",
+	})
+)
+
+// checkpoint is the Unix time in nanoseconds up to which assets have been backed up
+var checkpoint atomic.Int64
+
+// checkpointLag reports how far the checkpoint is behind the current time
+var checkpointLag = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "checkpoint_lag_seconds",
+	Help:      "Seconds since the last run time assets were fetched from, 0 before the first run.",
+}, func() float64 {
+	ns := checkpoint.Load()
+	if ns == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, ns)).Seconds()
+})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Runs, RunDuration,
+		AssetsFetched, AssetsSaved, AssetsFailed, AssetsDeleted,
+		BytesWritten,
+		RequestDuration, TokenRefreshes, Retries,
+		LastSuccess, checkpointLag,
+	)
+}
+
+// SetCheckpoint records the time the next run fetches changes from
+func SetCheckpoint(t time.Time) {
+	checkpoint.Store(t.UnixNano())
+}
+
+// ObserveRequest records the latency of a Marketing Cloud request. A status of 0 means
+// the request failed before a response was received.
+func ObserveRequest(endpoint string, status int, start time.Time) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	RequestDuration.WithLabelValues(endpoint, label).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
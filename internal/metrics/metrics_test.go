@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	Runs.WithLabelValues("success").Inc()
+	BytesWritten.WithLabelValues("local").Add(128)
+	ObserveRequest("query", 200, time.Now())
+	ObserveRequest("query", 0, time.Now())
+	SetCheckpoint(time.Now().Add(-time.Hour))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`backup_creator_runs_total{outcome="success"}`,
+		`backup_creator_storage_bytes_written_total{backend="local"} 128`,
+		`backup_creator_sfmc_request_duration_seconds_count{endpoint="query",status="200"} 1`,
+		`backup_creator_sfmc_request_duration_seconds_count{endpoint="query",status="error"} 1`,
+		`backup_creator_checkpoint_lag_seconds`,
+		`backup_creator_last_success_timestamp_seconds`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, string(body), want)
+	}
+	assert.InDelta(t, 3600, testutil.ToFloat64(checkpointLag), 60)
+}
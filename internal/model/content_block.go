@@ -3,8 +3,35 @@ package model
 import "time"
 
 type ContentBlock struct {
-	ID           int         `json:"id"`
-	Name         string      `json:"name"`
-	ModifiedDate time.Time   `json:"modifiedDate"`
-	Content      interface{} `json:"content"`
+	ID           int                    `json:"id"`
+	CustomerKey  string                 `json:"customerKey,omitempty"`
+	Name         string                 `json:"name"`
+	AssetType    *AssetType             `json:"assetType,omitempty"`
+	Category     *Category              `json:"category,omitempty"`
+	ModifiedDate time.Time              `json:"modifiedDate"`
+	ModifiedBy   *User                  `json:"modifiedBy,omitempty"`
+	Content      interface{}            `json:"content"`
+	Views        map[string]interface{} `json:"views,omitempty"`
+}
+
+// User is the Marketing Cloud user who created or modified an asset
+type User struct {
+	ID     int    `json:"id"`
+	Email  string `json:"email,omitempty"`
+	Name   string `json:"name,omitempty"`
+	UserID string `json:"userId,omitempty"`
+}
+
+type AssetType struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Category is a Content Builder folder. Path holds the names of the category and
+// its ancestors from the root, resolved when the blocks are fetched.
+type Category struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	ParentID int      `json:"parentId,omitempty"`
+	Path     []string `json:"path,omitempty"`
 }
@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+// DataExtension is a Marketing Cloud data extension, a table of rows with a fixed schema
+type DataExtension struct {
+	CustomerKey string               `json:"customerKey"`
+	Name        string               `json:"name"`
+	CategoryID  int                  `json:"categoryId,omitempty"`
+	Fields      []DataExtensionField `json:"fields,omitempty"`
+}
+
+// DataExtensionField is a column of a data extension
+type DataExtensionField struct {
+	Name         string `json:"name"`
+	FieldType    string `json:"fieldType"`
+	MaxLength    int    `json:"maxLength,omitempty"`
+	Scale        int    `json:"scale,omitempty"`
+	IsPrimaryKey bool   `json:"isPrimaryKey,omitempty"`
+	IsRequired   bool   `json:"isRequired,omitempty"`
+	DefaultValue string `json:"defaultValue,omitempty"`
+	Ordinal      int    `json:"ordinal"`
+}
+
+// DataExtensionManifest describes the data extensions stored in a backup folder. Each
+// entry holds the row count reported by Marketing Cloud next to the rows written, so a
+// truncated export can be told from a complete one.
+type DataExtensionManifest struct {
+	Folder    string               `json:"folder"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Entries   []DataExtensionEntry `json:"entries"`
+}
+
+// DataExtensionEntry points to the stored schema and rows of one data extension
+type DataExtensionEntry struct {
+	CustomerKey  string `json:"customerKey"`
+	Name         string `json:"name"`
+	Schema       string `json:"schema"`
+	Object       string `json:"object"`
+	Format       string `json:"format"`
+	SHA256       string `json:"sha256"`
+	Size         int64  `json:"size"`
+	Rows         int    `json:"rows"`
+	ExpectedRows int    `json:"expectedRows"`
+}
+
+// RowCountMatches reports whether every row Marketing Cloud reported was written
+func (e DataExtensionEntry) RowCountMatches() bool {
+	return e.Rows == e.ExpectedRows
+}
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// Manifest describes the content blocks stored in a single backup folder. Type is
+// BackupFull for a snapshot of every asset and BackupIncremental for the changes since the
+// previous folder; manifests written before snapshots existed have none. An incremental
+// manifest links to the folder before it and to the full snapshot its chain starts from.
+type Manifest struct {
+	Folder    string          `json:"folder"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Type      string          `json:"type,omitempty"`
+	Base      string          `json:"base,omitempty"`
+	Previous  string          `json:"previous,omitempty"`
+	Entries   []ManifestEntry `json:"entries"`
+	// Tombstones are the assets found deleted from Marketing Cloud by the runs into the folder
+	Tombstones []Tombstone `json:"tombstones,omitempty"`
+}
+
+// ManifestEntry points to the stored body of one content block.
+type ManifestEntry struct {
+	ID           int       `json:"id"`
+	CustomerKey  string    `json:"customerKey,omitempty"`
+	Name         string    `json:"name"`
+	ModifiedDate time.Time `json:"modifiedDate"`
+	SHA256       string    `json:"sha256"`
+	Size         int       `json:"size"`
+	Object       string    `json:"object"`
+}
+
+// AssetRef identifies an asset in the inventory of Marketing Cloud
+type AssetRef struct {
+	ID          int    `json:"id"`
+	CustomerKey string `json:"customerKey,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// Tombstone records an asset that disappeared from Marketing Cloud and when that was noticed
+type Tombstone struct {
+	AssetRef
+	DetectedAt time.Time `json:"detectedAt"`
+}
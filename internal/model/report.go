@@ -0,0 +1,325 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RunReport collects the outcome of a backup run. Its methods are safe for concurrent
+// use and do nothing on a nil report. It is written as report.json to the backup folder.
+type RunReport struct {
+	mu             sync.Mutex
+	RunID          string               `json:"runId"`
+	Type           string               `json:"type,omitempty"`
+	Folder         string               `json:"folder,omitempty"`
+	StartedAt      time.Time            `json:"startedAt"`
+	FinishedAt     time.Time            `json:"finishedAt"`
+	Success        bool                 `json:"success"`
+	Error          string               `json:"error,omitempty"`
+	Progress       Progress             `json:"progress"`
+	AssetTypes     map[string]*Progress `json:"assetTypes,omitempty"`
+	Failures       []Failure            `json:"failures,omitempty"`
+	Retries        int                  `json:"retries"`
+	TokenRefreshes int                  `json:"tokenRefreshes"`
+	Destinations   []DestinationResult  `json:"destinations,omitempty"`
+	Deleted        []Tombstone          `json:"deleted,omitempty"`
+	Timeline       []TimelineEvent      `json:"timeline,omitempty"`
+	Previous       *RunComparison       `json:"previous,omitempty"`
+}
+
+// UnknownAssetType groups the assets without an asset type in AssetTypes
+const UnknownAssetType = "unknown"
+
+// Failure is an asset that could not be backed up
+type Failure struct {
+	AssetID   int    `json:"assetId"`
+	Name      string `json:"name,omitempty"`
+	AssetType string `json:"assetType,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// TimelineEvent is a step of a run
+type TimelineEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// RunComparison compares a run with the previous run
+type RunComparison struct {
+	RunID           string    `json:"runId"`
+	Folder          string    `json:"folder,omitempty"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	Success         bool      `json:"success"`
+	Progress        Progress  `json:"progress"`
+	FetchedChange   int       `json:"fetchedChange"`
+	FailedChange    int       `json:"failedChange"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	DurationChange  float64   `json:"durationChangeSeconds"`
+}
+
+// Progress counts the assets handled so far in a run
+type Progress struct {
+	Fetched int `json:"fetched"`
+	Saved   int `json:"saved"`
+	Failed  int `json:"failed"`
+}
+
+// DestinationResult is the outcome of writing a run to one storage destination
+type DestinationResult struct {
+	Name   string `json:"name"`
+	Saved  int    `json:"saved"`
+	Failed int    `json:"failed"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+type runReportKey struct{}
+
+// WithRunReport returns a copy of ctx carrying the report of the current run
+func WithRunReport(ctx context.Context, report *RunReport) context.Context {
+	return context.WithValue(ctx, runReportKey{}, report)
+}
+
+// RunReportFromContext returns the report of the current run, or nil
+func RunReportFromContext(ctx context.Context) *RunReport {
+	report, _ := ctx.Value(runReportKey{}).(*RunReport)
+	return report
+}
+
+// AddDestination records the result of a destination
+func (r *RunReport) AddDestination(result DestinationResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Destinations = append(r.Destinations, result)
+}
+
+// AddFetched records assets fetched from Marketing Cloud
+func (r *RunReport) AddFetched(n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Progress.Fetched += n
+}
+
+// AddFetchedBlocks records blocks fetched from Marketing Cloud, counted by asset type
+func (r *RunReport) AddFetchedBlocks(blocks []ContentBlock) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Progress.Fetched += len(blocks)
+	for _, b := range blocks {
+		r.assetType(b).Fetched++
+	}
+}
+
+// AddSaved records an asset saved to storage, or that failed to save when ok is false
+func (r *RunReport) AddSaved(ok bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ok {
+		r.Progress.Saved++
+	} else {
+		r.Progress.Failed++
+	}
+}
+
+// AddSavedBlock records the outcome of saving a block, counted by asset type, and the
+// reason when err is set
+func (r *RunReport) AddSavedBlock(block ContentBlock, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.assetType(block)
+	if err == nil {
+		r.Progress.Saved++
+		stats.Saved++
+		return
+	}
+	r.Progress.Failed++
+	stats.Failed++
+	failure := Failure{AssetID: block.ID, Name: block.Name, Reason: err.Error()}
+	if block.AssetType != nil {
+		failure.AssetType = block.AssetType.Name
+	}
+	r.Failures = append(r.Failures, failure)
+}
+
+// assetType returns the stats of the asset type of block, the caller holds r.mu
+func (r *RunReport) assetType(block ContentBlock) *Progress {
+	name := UnknownAssetType
+	if block.AssetType != nil && block.AssetType.Name != "" {
+		name = block.AssetType.Name
+	}
+	if r.AssetTypes == nil {
+		r.AssetTypes = make(map[string]*Progress)
+	}
+	stats, ok := r.AssetTypes[name]
+	if !ok {
+		stats = &Progress{}
+		r.AssetTypes[name] = stats
+	}
+	return stats
+}
+
+// AddRetry records a request retried after it failed
+func (r *RunReport) AddRetry(endpoint string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Retries++
+	r.mu.Unlock()
+	r.AddEvent("retry", endpoint)
+}
+
+// AddTokenRefresh records an access token refreshed during the run
+func (r *RunReport) AddTokenRefresh() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.TokenRefreshes++
+	r.mu.Unlock()
+	r.AddEvent("token refreshed", "")
+}
+
+// AddEvent appends a step to the timeline of the run
+func (r *RunReport) AddEvent(event, detail string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Timeline = append(r.Timeline, TimelineEvent{Time: time.Now().UTC(), Event: event, Detail: detail})
+}
+
+// AddDeleted records assets found deleted from Marketing Cloud during the run
+func (r *RunReport) AddDeleted(tombstones []Tombstone) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Deleted = append(r.Deleted, tombstones...)
+}
+
+// DeletedAssets returns a copy of the assets found deleted during the run
+func (r *RunReport) DeletedAssets() []Tombstone {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Tombstone(nil), r.Deleted...)
+}
+
+// Finish records the end of the run
+func (r *RunReport) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.FinishedAt = time.Now().UTC()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.mu.Unlock()
+	if err != nil {
+		r.AddEvent("run failed", err.Error())
+		return
+	}
+	r.AddEvent("run finished", "")
+}
+
+// Compare records previous as the run before this one
+func (r *RunReport) Compare(previous *RunReport) {
+	if r == nil || previous == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	duration := previous.FinishedAt.Sub(previous.StartedAt).Seconds()
+	r.Previous = &RunComparison{
+		RunID:           previous.RunID,
+		Folder:          previous.Folder,
+		FinishedAt:      previous.FinishedAt,
+		Success:         previous.Success,
+		Progress:        previous.Progress,
+		FetchedChange:   r.Progress.Fetched - previous.Progress.Fetched,
+		FailedChange:    r.Progress.Failed - previous.Progress.Failed,
+		DurationSeconds: duration,
+		DurationChange:  r.FinishedAt.Sub(r.StartedAt).Seconds() - duration,
+	}
+}
+
+// Snapshot returns the progress of the run so far
+func (r *RunReport) Snapshot() Progress {
+	if r == nil {
+		return Progress{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Progress
+}
+
+// SetFolder records the backup folder the run writes to
+func (r *RunReport) SetFolder(folder string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Folder = folder
+}
+
+// Copy returns a copy of the report that can be read while the run goes on
+func (r *RunReport) Copy() *RunReport {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &RunReport{
+		RunID:          r.RunID,
+		Type:           r.Type,
+		Folder:         r.Folder,
+		StartedAt:      r.StartedAt,
+		FinishedAt:     r.FinishedAt,
+		Success:        r.Success,
+		Error:          r.Error,
+		Progress:       r.Progress,
+		Failures:       append([]Failure(nil), r.Failures...),
+		Retries:        r.Retries,
+		TokenRefreshes: r.TokenRefreshes,
+		Destinations:   append([]DestinationResult(nil), r.Destinations...),
+		Timeline:       append([]TimelineEvent(nil), r.Timeline...),
+		Deleted:        append([]Tombstone(nil), r.Deleted...),
+	}
+	if r.AssetTypes != nil {
+		c.AssetTypes = make(map[string]*Progress, len(r.AssetTypes))
+		for name, progress := range r.AssetTypes {
+			p := *progress
+			c.AssetTypes[name] = &p
+		}
+	}
+	if r.Previous != nil {
+		previous := *r.Previous
+		c.Previous = &previous
+	}
+	return c
+}
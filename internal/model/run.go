@@ -0,0 +1,47 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+type runIDKey struct{}
+
+type backupTypeKey struct{}
+
+// Backup types
+const (
+	// BackupIncremental saves the assets changed since the previous run
+	BackupIncremental = "incremental"
+	// BackupFull saves every asset regardless of the checkpoint
+	BackupFull = "full"
+)
+
+// NewRunID returns the ID of a backup run started at t
+func NewRunID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// WithRunID returns a copy of ctx carrying the ID of the current backup run
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the ID of the current backup run, or an empty string
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// WithBackupType returns a copy of ctx carrying the type of the current backup run
+func WithBackupType(ctx context.Context, backupType string) context.Context {
+	return context.WithValue(ctx, backupTypeKey{}, backupType)
+}
+
+// BackupTypeFromContext returns the type of the current backup run, incremental unless set
+func BackupTypeFromContext(ctx context.Context) string {
+	if backupType, ok := ctx.Value(backupTypeKey{}).(string); ok && backupType != "" {
+		return backupType
+	}
+	return BackupIncremental
+}
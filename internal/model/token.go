@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"log/slog"
+	"time"
+)
 
 type Token struct {
 	AccessToken string `json:"access_token"`
@@ -8,6 +11,14 @@ type Token struct {
 	ExpiryTime  time.Time
 }
 
+// LogValue keeps the access token out of logs
+func (t Token) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("access_token", "[REDACTED]"),
+		slog.Time("expiry_time", t.ExpiryTime),
+	)
+}
+
 func (t *Token) IsExpired() bool {
 	return time.Now().After(t.ExpiryTime)
 }
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ReportSender sends a report of several runs, implemented by Email
+type ReportSender interface {
+	Send(ctx context.Context, report Report) error
+}
+
+// Digest collects the events of every run and sends them as one report when Send is
+// called, typically once a day. Pending events are kept in a file so that a restart
+// does not lose them.
+type Digest struct {
+	sender  ReportSender
+	path    string
+	mu      sync.Mutex
+	pending []Event
+}
+
+// NewDigest creates a digest sending through sender and loads the events pending in path
+func NewDigest(sender ReportSender, path string) (*Digest, error) {
+	d := &Digest{sender: sender, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read digest file: %v", err)
+	}
+	if err := json.Unmarshal(data, &d.pending); err != nil {
+		return nil, fmt.Errorf("failed to parse digest file %s: %v", path, err)
+	}
+	return d, nil
+}
+
+// Notify adds event to the next digest
+func (d *Digest) Notify(ctx context.Context, event Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = append(d.pending, event)
+	return d.save()
+}
+
+// Send sends the pending events as one report. Nothing is sent when no run happened
+// since the last digest, and the events are kept for the next digest if sending fails.
+func (d *Digest) Send(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	first, last := d.pending[0].StartedAt, d.pending[len(d.pending)-1].FinishedAt
+	title := fmt.Sprintf("Backup digest for %s", first.Format("2006-01-02"))
+	if day := last.Format("2006-01-02"); day != first.Format("2006-01-02") {
+		title += " to " + day
+	}
+	title += fmt.Sprintf(", %d runs", len(d.pending))
+	if err := d.sender.Send(ctx, Report{Title: title, Runs: d.pending}); err != nil {
+		return err
+	}
+	d.pending = nil
+	return d.save()
+}
+
+func (d *Digest) save() error {
+	if len(d.pending) == 0 {
+		if err := os.Remove(d.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove digest file: %v", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(d.pending)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest: %v", err)
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write digest file: %v", err)
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return fmt.Errorf("failed to write digest file: %v", err)
+	}
+	return nil
+}
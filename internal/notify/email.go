@@ -0,0 +1,328 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TLS modes of an SMTP connection
+const (
+	TLSStartTLS = "starttls" // upgrade a plain connection, refusing servers without STARTTLS
+	TLSImplicit = "tls"      // connect over TLS, usually port 465
+	TLSNone     = "none"     // never encrypt, for local relays only
+)
+
+// EmailOptions configures an email notifier
+type EmailOptions struct {
+	Addr        string // SMTP server host:port
+	Username    string // PLAIN auth is skipped when empty
+	Password    string
+	From        string
+	To          []string
+	TLS         string      // TLSStartTLS (default), TLSImplicit or TLSNone
+	TLSConfig   *tls.Config // verifies the server name against the Addr host when nil
+	FolderLinks []string    // templates rendered with the Event linking to the backup folder
+	Timeout     time.Duration
+}
+
+// Report is the content of an email: the runs of one notification or of a digest
+type Report struct {
+	Title string
+	Runs  []Event
+}
+
+// Changed counts the assets changed across the runs
+func (r Report) Changed() int {
+	var changed int
+	for _, run := range r.Runs {
+		changed += len(run.Assets)
+	}
+	return changed
+}
+
+// FailedRuns counts the failed runs
+func (r Report) FailedRuns() int {
+	var failed int
+	for _, run := range r.Runs {
+		if !run.Success {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Email sends run reports as multipart plain-text and HTML emails
+type Email struct {
+	opts     EmailOptions
+	host     string
+	links    []*template.Template
+	password string
+	mu       sync.Mutex
+}
+
+// NewEmail creates an email notifier
+func NewEmail(opts EmailOptions) (*Email, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %v", opts.Addr, err)
+	}
+	if opts.From == "" || len(opts.To) == 0 {
+		return nil, fmt.Errorf("email sender and recipients are required")
+	}
+	switch opts.TLS {
+	case "":
+		opts.TLS = TLSStartTLS
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q, expected starttls, tls or none", opts.TLS)
+	}
+	if opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	e := &Email{opts: opts, host: host, password: opts.Password}
+	for _, link := range opts.FolderLinks {
+		tmpl, err := template.New("link").Parse(link)
+		if err != nil {
+			return nil, fmt.Errorf("invalid folder link template: %v", err)
+		}
+		e.links = append(e.links, tmpl)
+	}
+	return e, nil
+}
+
+// SetPassword replaces a rotated SMTP password, used from the next email on
+func (e *Email) SetPassword(password string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.password = password
+}
+
+// Notify emails the report of a single run
+func (e *Email) Notify(ctx context.Context, event Event) error {
+	return e.Send(ctx, Report{Title: fmt.Sprintf("Backup run %s %s", event.RunID, event.Status()), Runs: []Event{event}})
+}
+
+// Send emails report
+func (e *Email) Send(ctx context.Context, report Report) error {
+	msg, err := e.message(report)
+	if err != nil {
+		return err
+	}
+	if err := e.deliver(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send email via %s: %w", e.opts.Addr, err)
+	}
+	return nil
+}
+
+func (e *Email) deliver(ctx context.Context, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", e.opts.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if e.opts.TLS == TLSImplicit {
+		conn = tls.Client(conn, e.opts.TLSConfig)
+	}
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.opts.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server does not support STARTTLS")
+		}
+		if err := c.StartTLS(e.opts.TLSConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if e.opts.Username != "" {
+		e.mu.Lock()
+		password := e.password
+		e.mu.Unlock()
+		if err := c.Auth(smtp.PlainAuth("", e.opts.Username, password, e.host)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+	if err := c.Mail(e.opts.From); err != nil {
+		return err
+	}
+	for _, to := range e.opts.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// reportRun is a run as rendered in the email templates
+type reportRun struct {
+	Event
+	Links []string
+}
+
+// message renders report as a MIME message with plain-text and HTML alternatives
+func (e *Email) message(report Report) ([]byte, error) {
+	data := struct {
+		Report
+		Runs []reportRun
+	}{Report: report}
+	for _, event := range report.Runs {
+		run := reportRun{Event: event}
+		if event.Folder != "" {
+			for _, link := range e.links {
+				rendered, err := Render(link, event)
+				if err != nil {
+					return nil, err
+				}
+				run.Links = append(run.Links, rendered)
+			}
+		}
+		data.Runs = append(data.Runs, run)
+	}
+
+	var text, html bytes.Buffer
+	if err := textReport.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render text report: %v", err)
+	}
+	if err := htmlReport.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("failed to render HTML report: %v", err)
+	}
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+	subject := fmt.Sprintf("%s: %d assets changed", report.Title, report.Changed())
+	if failed := report.FailedRuns(); failed > 0 {
+		subject += fmt.Sprintf(", %d failed", failed)
+	}
+	headers := []string{
+		"From: " + e.opts.From,
+		"To: " + strings.Join(e.opts.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(e.host),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + body.Boundary(),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func messageID(host string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%s.%s@%s>", time.Now().UTC().Format("20060102150405"), hex.EncodeToString(b), host)
+}
+
+var textReport = template.Must(template.New("text").Parse(`{{.Title}}
+{{range .Runs}}
+Run {{.RunID}} {{.Status}} at {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}} in {{.Duration}}
+Fetched {{.Progress.Fetched}}, saved {{.Progress.Saved}}, failed {{.Progress.Failed}}
+{{- if .Folder}}
+Backup folder: {{.Folder}}{{range .Links}}
+  {{.}}{{end}}{{end}}
+{{- with .Failures}}
+Failures:{{range .}}
+  - {{.}}{{end}}{{end}}
+{{- with .Alerts}}
+Alerts:{{range .}}
+  - {{.}}{{end}}{{end}}
+{{- with .Deleted}}
+Deleted assets:{{range .}}
+  - {{.Name}} (#{{.ID}}){{end}}{{end}}
+{{- with .Assets}}
+Changed assets:{{range .}}
+  - {{.Name}} (#{{.ID}}{{with .Type}}, {{.}}{{end}}){{with .ModifiedBy}} modified by {{.}}{{end}}{{end}}
+{{- else}}
+No assets changed.{{end}}
+{{end}}`))
+
+// web reports whether link can be rendered as an HTML link, other schemes such as s3://
+// are shown as text
+func web(link string) bool {
+	return strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://")
+}
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{"web": web}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+{{range .Runs}}
+<h3 style="color: {{if .Success}}#2EB67D{{else}}#E01E5A{{end}}">Run {{.RunID}} {{.Status}}</h3>
+<p>Finished {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}} in {{.Duration}}.
+Fetched {{.Progress.Fetched}}, saved {{.Progress.Saved}}, failed {{.Progress.Failed}}.</p>
+{{if .Folder}}<p>Backup folder: <code>{{.Folder}}</code>{{range .Links}}<br>{{if web .}}<a href="{{.}}">{{.}}</a>{{else}}<code>{{.}}</code>{{end}}{{end}}</p>{{end}}
+{{with .Failures}}<p>Failures:</p>
+<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{with .Alerts}}<p style="color: #ECB22E">Alerts:</p>
+<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{with .Deleted}}<p>Deleted assets:</p>
+<ul>{{range .}}<li>{{.Name}} (#{{.ID}})</li>{{end}}</ul>{{end}}
+{{with .Assets}}<table border="1" cellpadding="4" cellspacing="0" style="border-collapse: collapse">
+<tr><th>ID</th><th>Name</th><th>Type</th><th>Modified by</th><th>Modified</th></tr>
+{{range .}}<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.ModifiedBy}}</td><td>{{.ModifiedDate.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>{{else}}<p>No assets changed.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,359 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received is a message accepted by fakeSMTP
+type received struct {
+	from string
+	to   []string
+	auth string
+	tls  bool
+	data []byte
+}
+
+// fakeSMTP is a minimal SMTP server supporting STARTTLS and PLAIN auth
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	mu       sync.Mutex
+	messages []received
+}
+
+func newFakeSMTP(t *testing.T, startTLS bool) (*fakeSMTP, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeSMTP{listener: listener}
+	if startTLS {
+		s.tls = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, pool
+}
+
+func (s *fakeSMTP) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSMTP) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.messages...)
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+
+	var msg received
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-fake")
+			if s.tls != nil && !msg.tls {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			text.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, text = tlsConn, textproto.NewConn(tlsConn)
+			msg.tls = true
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			msg.auth = string(decoded)
+			text.PrintfLine("235 authenticated")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			msg.data, err = text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+// parts decodes the alternatives of a multipart message by content type
+func parts(t *testing.T, msg *mail.Message) map[string]string {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	result := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		require.NoError(t, err)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		result[contentType] = string(body)
+	}
+}
+
+func emailEvent() Event {
+	start := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	return Event{
+		RunID:        "20241121T000000Z-abcd",
+		Error:        "failed to save content blocks: s3 unavailable",
+		StartedAt:    start,
+		FinishedAt:   start.Add(75 * time.Second),
+		Progress:     model.Progress{Fetched: 2, Saved: 1, Failed: 1},
+		Destinations: []model.DestinationResult{{Name: "s3", Saved: 1, Failed: 1, Error: "s3 unavailable"}},
+		Folder:       "backup_20241121",
+		Assets: AssetsOf([]model.ContentBlock{
+			{ID: 1, Name: "Welcome <Email>", AssetType: &model.AssetType{Name: "htmlemail"}, ModifiedBy: &model.User{Name: "Jane Doe"}},
+			{ID: 2, Name: "Footer", ModifiedBy: &model.User{Email: "ops@example.com"}},
+		}),
+	}
+}
+
+func TestEmail_Send(t *testing.T) {
+	server, pool := newFakeSMTP(t, true)
+	email, err := NewEmail(EmailOptions{
+		Addr:        server.addr(),
+		Username:    "backup",
+		Password:    "secret",
+		From:        "backup@example.com",
+		To:          []string{"ops@example.com", "leads@example.com"},
+		TLSConfig:   &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+		FolderLinks: []string{"https://backups.example.com/{{.Folder}}", "s3://bucket/{{.Folder}}/"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, email.Notify(context.Background(), emailEvent()))
+
+	messages := server.received()
+	require.Len(t, messages, 1)
+	assert.True(t, messages[0].tls)
+	assert.Equal(t, "\x00backup\x00secret", messages[0].auth)
+	assert.Equal(t, "backup@example.com", messages[0].from)
+	assert.Equal(t, []string{"ops@example.com", "leads@example.com"}, messages[0].to)
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(messages[0].data)))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Backup run 20241121T000000Z-abcd failed: 2 assets changed, 1 failed", subject)
+
+	bodies := parts(t, msg)
+	text := bodies["text/plain"]
+	assert.Contains(t, text, "Fetched 2, saved 1, failed 1")
+	assert.Contains(t, text, "Backup folder: backup_20241121\n  https://backups.example.com/backup_20241121\n  s3://bucket/backup_20241121/")
+	assert.Contains(t, text, "  - s3: s3 unavailable")
+	assert.Contains(t, text, "  - Welcome <Email> (#1, htmlemail) modified by Jane Doe")
+	assert.Contains(t, text, "  - Footer (#2) modified by ops@example.com")
+
+	html := bodies["text/html"]
+	assert.Contains(t, html, "<td>Welcome &lt;Email&gt;</td><td>htmlemail</td><td>Jane Doe</td>")
+	assert.Contains(t, html, `<a href="https://backups.example.com/backup_20241121">`)
+	assert.Contains(t, html, "<code>s3://bucket/backup_20241121/</code>")
+	assert.Contains(t, html, "<li>s3: s3 unavailable</li>")
+}
+
+func TestEmail_SetPassword(t *testing.T) {
+	server, pool := newFakeSMTP(t, true)
+	email, err := NewEmail(EmailOptions{
+		Addr:      server.addr(),
+		Username:  "backup",
+		Password:  "secret",
+		From:      "backup@example.com",
+		To:        []string{"ops@example.com"},
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	email.SetPassword("rotated")
+	require.NoError(t, email.Notify(context.Background(), emailEvent()))
+
+	messages := server.received()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "\x00backup\x00rotated", messages[0].auth)
+}
+
+func TestEmail_RequiresStartTLS(t *testing.T) {
+	server, _ := newFakeSMTP(t, false)
+
+	email, err := NewEmail(EmailOptions{Addr: server.addr(), From: "backup@example.com", To: []string{"ops@example.com"}})
+	require.NoError(t, err)
+	err = email.Notify(context.Background(), emailEvent())
+	assert.ErrorContains(t, err, "server does not support STARTTLS")
+	assert.Empty(t, server.received())
+
+	// plain connections are only used when asked for
+	email, err = NewEmail(EmailOptions{Addr: server.addr(), From: "backup@example.com", To: []string{"ops@example.com"}, TLS: TLSNone})
+	require.NoError(t, err)
+	assert.NoError(t, email.Notify(context.Background(), emailEvent()))
+	assert.Len(t, server.received(), 1)
+}
+
+func TestNewEmail_Invalid(t *testing.T) {
+	for name, opts := range map[string]EmailOptions{
+		"missing port":       {Addr: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}},
+		"missing recipients": {Addr: "smtp.example.com:587", From: "a@example.com"},
+		"unknown TLS mode":   {Addr: "smtp.example.com:587", From: "a@example.com", To: []string{"b@example.com"}, TLS: "ssl3"},
+		"invalid link":       {Addr: "smtp.example.com:587", From: "a@example.com", To: []string{"b@example.com"}, FolderLinks: []string{"{{.Folder"}},
+	} {
+		_, err := NewEmail(opts)
+		assert.Error(t, err, name)
+	}
+}
+
+// bufferedSender records the reports sent by a digest
+type bufferedSender struct {
+	reports []Report
+	err     error
+}
+
+func (s *bufferedSender) Send(ctx context.Context, report Report) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.reports = append(s.reports, report)
+	return nil
+}
+
+func TestDigest(t *testing.T) {
+	path := t.TempDir() + "/digest.json"
+	sender := &bufferedSender{}
+	ctx := context.Background()
+
+	digest, err := NewDigest(sender, path)
+	require.NoError(t, err)
+	assert.NoError(t, digest.Send(ctx))
+	assert.Empty(t, sender.reports)
+
+	first := emailEvent()
+	second := emailEvent()
+	second.RunID, second.Success, second.Error = "20241121T060000Z-efgh", true, ""
+	second.StartedAt, second.FinishedAt = first.StartedAt.Add(6*time.Hour), first.FinishedAt.Add(6*time.Hour)
+	require.NoError(t, digest.Notify(ctx, first))
+
+	// pending runs survive a restart
+	digest, err = NewDigest(sender, path)
+	require.NoError(t, err)
+	require.NoError(t, digest.Notify(ctx, second))
+
+	// a failed delivery keeps the runs for the next digest
+	sender.err = assert.AnError
+	assert.Error(t, digest.Send(ctx))
+	sender.err = nil
+
+	require.NoError(t, digest.Send(ctx))
+	require.Len(t, sender.reports, 1)
+	report := sender.reports[0]
+	assert.Equal(t, "Backup digest for 2024-11-21, 2 runs", report.Title)
+	assert.Equal(t, 4, report.Changed())
+	assert.Equal(t, 1, report.FailedRuns())
+	assert.Equal(t, []string{first.RunID, second.RunID}, []string{report.Runs[0].RunID, report.Runs[1].RunID})
+	assert.Equal(t, first.Assets, report.Runs[0].Assets)
+	assert.NoFileExists(t, path)
+
+	assert.NoError(t, digest.Send(ctx))
+	assert.Len(t, sender.reports, 1)
+}
+
+func TestDigest_SendsEmail(t *testing.T) {
+	server, pool := newFakeSMTP(t, true)
+	email, err := NewEmail(EmailOptions{
+		Addr:      server.addr(),
+		From:      "backup@example.com",
+		To:        []string{"ops@example.com"},
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	})
+	require.NoError(t, err)
+	digest, err := NewDigest(email, t.TempDir()+"/digest.json")
+	require.NoError(t, err)
+
+	event := emailEvent()
+	require.NoError(t, digest.Notify(context.Background(), event))
+	event.StartedAt, event.FinishedAt = event.StartedAt.Add(24*time.Hour), event.FinishedAt.Add(24*time.Hour)
+	require.NoError(t, digest.Notify(context.Background(), event))
+	require.NoError(t, digest.Send(context.Background()))
+
+	messages := server.received()
+	require.Len(t, messages, 1)
+	msg, err := mail.ReadMessage(strings.NewReader(string(messages[0].data)))
+	require.NoError(t, err)
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Equal(t, "Backup digest for 2024-11-21 to 2024-11-22, 2 runs: 4 assets changed, 2 failed", subject)
+	assert.Equal(t, 2, strings.Count(parts(t, msg)["text/plain"], "Run 20241121T000000Z-abcd failed"))
+}
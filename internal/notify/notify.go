@@ -0,0 +1,181 @@
+// Package notify sends the outcome of backup runs to chat and webhook receivers
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// Event is the outcome of a backup run passed to notifiers and message templates
+type Event struct {
+	RunID        string
+	Success      bool
+	Error        string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Progress     model.Progress
+	Destinations []model.DestinationResult
+	Folder       string  // backup folder the run wrote to
+	Assets       []Asset // assets changed since the previous run
+	Deleted      []Asset // assets found deleted from Marketing Cloud
+	// Alerts need attention even when the run succeeded, e.g. a mass deletion. Events
+	// with alerts are always sent.
+	Alerts []string
+}
+
+// Asset describes a changed asset in reports
+type Asset struct {
+	ID           int
+	Name         string
+	Type         string
+	ModifiedBy   string
+	ModifiedDate time.Time
+}
+
+// AssetsOf describes the blocks of a run
+func AssetsOf(blocks []model.ContentBlock) []Asset {
+	assets := make([]Asset, 0, len(blocks))
+	for _, b := range blocks {
+		asset := Asset{ID: b.ID, Name: b.Name, ModifiedDate: b.ModifiedDate}
+		if b.AssetType != nil {
+			asset.Type = b.AssetType.Name
+		}
+		if b.ModifiedBy != nil {
+			asset.ModifiedBy = b.ModifiedBy.Name
+			if asset.ModifiedBy == "" {
+				asset.ModifiedBy = b.ModifiedBy.Email
+			}
+		}
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// DeletedAssets describes the assets found deleted during a run
+func DeletedAssets(tombstones []model.Tombstone) []Asset {
+	assets := make([]Asset, 0, len(tombstones))
+	for _, t := range tombstones {
+		assets = append(assets, Asset{ID: t.ID, Name: t.Name})
+	}
+	return assets
+}
+
+// Status is "succeeded" or "failed"
+func (e Event) Status() string {
+	if e.Success {
+		return "succeeded"
+	}
+	return "failed"
+}
+
+// Duration is the run time rounded to the second
+func (e Event) Duration() time.Duration {
+	return e.FinishedAt.Sub(e.StartedAt).Round(time.Second)
+}
+
+// Failures lists the run error and the error of each failed destination
+func (e Event) Failures() []string {
+	var failures []string
+	if e.Error != "" {
+		failures = append(failures, e.Error)
+	}
+	for _, d := range e.Destinations {
+		if !d.OK && d.Error != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", d.Name, d.Error))
+		}
+	}
+	return failures
+}
+
+// Notifier delivers run events
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Func adapts a function to a Notifier
+type Func func(ctx context.Context, event Event) error
+
+// Notify calls f
+func (f Func) Notify(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Multi sends each event to all notifiers and joins their errors
+type Multi []Notifier
+
+// Notify sends event to every notifier, including those after a failing one
+func (m Multi) Notify(ctx context.Context, event Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Mode selects which runs are notified
+type Mode string
+
+const (
+	OnAlways  Mode = "always"  // every run
+	OnFailure Mode = "failure" // failed runs and the first successful run after a failure
+	OnChange  Mode = "change"  // runs whose outcome differs from the previous run
+)
+
+// ParseMode parses a mode name, defaulting to OnFailure
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(name)); mode {
+	case "":
+		return OnFailure, nil
+	case OnAlways, OnFailure, OnChange:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown notification mode %q, expected always, failure or change", name)
+	}
+}
+
+// Filter passes on the events selected by its mode and every event with alerts. The outcome
+// of the previous run is kept in memory, so the first run after a restart is compared with
+// a successful run.
+type Filter struct {
+	mode     Mode
+	next     Notifier
+	mu       sync.Mutex
+	lastFail bool
+}
+
+// NewFilter creates a filter sending the events selected by mode to next
+func NewFilter(mode Mode, next Notifier) *Filter {
+	return &Filter{mode: mode, next: next}
+}
+
+// Notify sends event to the wrapped notifier if the mode selects it
+func (f *Filter) Notify(ctx context.Context, event Event) error {
+	f.mu.Lock()
+	changed := f.lastFail == event.Success
+	f.lastFail = !event.Success
+	f.mu.Unlock()
+
+	if len(event.Alerts) > 0 {
+		return f.next.Notify(ctx, event)
+	}
+	switch f.mode {
+	case OnAlways:
+	case OnChange:
+		if !changed {
+			return nil
+		}
+	default:
+		if event.Success && !changed {
+			return nil
+		}
+	}
+	return f.next.Notify(ctx, event)
+}
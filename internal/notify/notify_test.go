@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent(t *testing.T) {
+	start := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	event := Event{
+		Error:      "failed to save content blocks",
+		StartedAt:  start,
+		FinishedAt: start.Add(90*time.Second + 400*time.Millisecond),
+		Destinations: []model.DestinationResult{
+			{Name: "local", OK: true},
+			{Name: "s3", Error: "access denied"},
+		},
+	}
+
+	assert.Equal(t, "failed", event.Status())
+	assert.Equal(t, 90*time.Second, event.Duration())
+	assert.Equal(t, []string{"failed to save content blocks", "s3: access denied"}, event.Failures())
+	assert.Equal(t, "succeeded", Event{Success: true}.Status())
+}
+
+func TestParseMode(t *testing.T) {
+	for name, expected := range map[string]Mode{"": OnFailure, "always": OnAlways, "Failure": OnFailure, "change": OnChange} {
+		mode, err := ParseMode(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, mode, name)
+	}
+	_, err := ParseMode("never")
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	// outcomes of consecutive runs
+	outcomes := []bool{true, false, false, true, true, false}
+
+	tests := []struct {
+		mode     Mode
+		expected []bool
+	}{
+		{mode: OnAlways, expected: []bool{true, false, false, true, true, false}},
+		{mode: OnFailure, expected: []bool{false, false, true, false}},
+		{mode: OnChange, expected: []bool{false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			var notified []bool
+			filter := NewFilter(tt.mode, Func(func(ctx context.Context, event Event) error {
+				notified = append(notified, event.Success)
+				return nil
+			}))
+			for _, success := range outcomes {
+				assert.NoError(t, filter.Notify(context.Background(), Event{Success: success}))
+			}
+			assert.Equal(t, tt.expected, notified)
+		})
+	}
+}
+
+func TestFilter_Alerts(t *testing.T) {
+	for _, mode := range []Mode{OnFailure, OnChange} {
+		t.Run(string(mode), func(t *testing.T) {
+			var notified int
+			filter := NewFilter(mode, Func(func(ctx context.Context, event Event) error {
+				notified++
+				return nil
+			}))
+			assert.NoError(t, filter.Notify(context.Background(), Event{Success: true}))
+			assert.NoError(t, filter.Notify(context.Background(), Event{Success: true, Alerts: []string{"mass deletion"}}))
+			assert.Equal(t, 1, notified)
+		})
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var calls int
+	ok := Func(func(ctx context.Context, event Event) error {
+		calls++
+		return nil
+	})
+	failing := Func(func(ctx context.Context, event Event) error {
+		calls++
+		return errors.New("unreachable")
+	})
+
+	err := Multi{failing, ok}.Notify(context.Background(), Event{})
+	assert.EqualError(t, err, "unreachable")
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// DefaultTemplate is the message sent when no template is configured
+const DefaultTemplate = `Backup run {{.RunID}} {{.Status}} in {{.Duration}}: ` +
+	`{{.Progress.Fetched}} fetched, {{.Progress.Saved}} saved, {{.Progress.Failed}} failed` +
+	`{{range .Failures}}
+- {{.}}{{end}}{{range .Alerts}}
+! {{.}}{{end}}`
+
+// Format selects the payload sent to a webhook
+type Format string
+
+const (
+	FormatJSON  Format = "json"  // the event and the message as a JSON object
+	FormatSlack Format = "slack" // a Slack incoming webhook message
+	FormatTeams Format = "teams" // a Microsoft Teams connector card
+)
+
+// WebhookOptions configures a webhook notifier
+type WebhookOptions struct {
+	URL      string
+	Format   Format
+	Template string        // text/template rendered with the Event, DefaultTemplate when empty
+	Attempts int           // deliveries tried before giving up, 3 when 0
+	Backoff  time.Duration // wait before the first retry, doubled after each attempt, 1s when 0
+	Timeout  time.Duration // timeout of each delivery, 10s when 0
+}
+
+// Webhook posts run events to an HTTP endpoint
+type Webhook struct {
+	url      string
+	format   Format
+	template *template.Template
+	attempts int
+	backoff  time.Duration
+	client   *http.Client
+}
+
+// NewWebhook creates a webhook notifier
+func NewWebhook(opts WebhookOptions) (*Webhook, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("webhook URL is required")
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = FormatJSON
+	case FormatJSON, FormatSlack, FormatTeams:
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q, expected json, slack or teams", opts.Format)
+	}
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	tmpl, err := template.New("message").Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %v", err)
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Webhook{
+		url:      opts.URL,
+		format:   opts.Format,
+		template: tmpl,
+		attempts: opts.Attempts,
+		backoff:  opts.Backoff,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Notify renders the message and posts it, retrying failed deliveries
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	message, err := Render(w.template, event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(w.payload(event, message))
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s webhook payload: %v", w.format, err)
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == w.attempts {
+			return fmt.Errorf("failed to deliver %s webhook after %d attempts: %w", w.format, attempt, err)
+		}
+		logging.FromContext(ctx).WarnContext(ctx, "Webhook delivery failed, retrying", "format", w.format, "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to deliver %s webhook: %w", w.format, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one delivery and reports whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (w *Webhook) payload(event Event, message string) interface{} {
+	switch w.format {
+	case FormatSlack:
+		return slackMessage(event, message)
+	case FormatTeams:
+		return teamsCard(event, message)
+	default:
+		return jsonMessage{
+			Message:         message,
+			RunID:           event.RunID,
+			Status:          event.Status(),
+			Success:         event.Success,
+			Error:           event.Error,
+			StartedAt:       event.StartedAt,
+			FinishedAt:      event.FinishedAt,
+			DurationSeconds: event.FinishedAt.Sub(event.StartedAt).Seconds(),
+			Progress:        event.Progress,
+			Destinations:    event.Destinations,
+			Failures:        event.Failures(),
+			Alerts:          event.Alerts,
+			Deleted:         len(event.Deleted),
+		}
+	}
+}
+
+// Render executes a message template with event
+func Render(tmpl *template.Template, event Event) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to render message template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+type jsonMessage struct {
+	Message         string                    `json:"message"`
+	RunID           string                    `json:"runId"`
+	Status          string                    `json:"status"`
+	Success         bool                      `json:"success"`
+	Error           string                    `json:"error,omitempty"`
+	StartedAt       time.Time                 `json:"startedAt"`
+	FinishedAt      time.Time                 `json:"finishedAt"`
+	DurationSeconds float64                   `json:"durationSeconds"`
+	Progress        model.Progress            `json:"progress"`
+	Destinations    []model.DestinationResult `json:"destinations,omitempty"`
+	Failures        []string                  `json:"failures,omitempty"`
+	Alerts          []string                  `json:"alerts,omitempty"`
+	Deleted         int                       `json:"deleted,omitempty"`
+}
+
+func color(event Event) string {
+	switch {
+	case !event.Success:
+		return "E01E5A"
+	case len(event.Alerts) > 0:
+		return "ECB22E"
+	}
+	return "2EB67D"
+}
+
+// slackMessage formats an incoming webhook message with the counts as attachment fields
+func slackMessage(event Event, message string) map[string]interface{} {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	return map[string]interface{}{
+		"text": message,
+		"attachments": []map[string]interface{}{{
+			"color": "#" + color(event),
+			"fields": []field{
+				{Title: "Run", Value: event.RunID, Short: true},
+				{Title: "Duration", Value: event.Duration().String(), Short: true},
+				{Title: "Saved", Value: strconv.Itoa(event.Progress.Saved), Short: true},
+				{Title: "Failed", Value: strconv.Itoa(event.Progress.Failed), Short: true},
+			},
+		}},
+	}
+}
+
+// teamsCard formats a legacy connector MessageCard, accepted by Teams incoming webhooks
+// and workflows
+func teamsCard(event Event, message string) map[string]interface{} {
+	type fact struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	title := fmt.Sprintf("Backup %s", event.Status())
+	return map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    title,
+		"title":      title,
+		"themeColor": color(event),
+		"text":       strings.ReplaceAll(message, "\n", "\n\n"),
+		"sections": []map[string]interface{}{{
+			"facts": []fact{
+				{Name: "Run", Value: event.RunID},
+				{Name: "Duration", Value: event.Duration().String()},
+				{Name: "Fetched", Value: strconv.Itoa(event.Progress.Fetched)},
+				{Name: "Saved", Value: strconv.Itoa(event.Progress.Saved)},
+				{Name: "Failed", Value: strconv.Itoa(event.Progress.Failed)},
+			},
+		}},
+	}
+}
@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the JSON bodies posted to it and answers with the given statuses in turn
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []map[string]interface{}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(req.Body).Decode(&body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func testEvent() Event {
+	start := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	return Event{
+		RunID:        "20241121T000000Z-abcd",
+		Error:        "failed to save content blocks: s3 unavailable",
+		StartedAt:    start,
+		FinishedAt:   start.Add(75 * time.Second),
+		Progress:     model.Progress{Fetched: 3, Saved: 2, Failed: 1},
+		Destinations: []model.DestinationResult{{Name: "s3", Saved: 2, Failed: 1, Error: "s3 unavailable"}},
+	}
+}
+
+func TestWebhook_Formats(t *testing.T) {
+	tests := []struct {
+		format Format
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{
+			format: FormatJSON,
+			check: func(t *testing.T, body map[string]interface{}) {
+				assert.Equal(t, "20241121T000000Z-abcd", body["runId"])
+				assert.Equal(t, "failed", body["status"])
+				assert.Equal(t, 75.0, body["durationSeconds"])
+				assert.Equal(t, map[string]interface{}{"fetched": 3.0, "saved": 2.0, "failed": 1.0}, body["progress"])
+				assert.Len(t, body["failures"], 2)
+				assert.Equal(t, "Backup run 20241121T000000Z-abcd failed in 1m15s: 3 fetched, 2 saved, 1 failed\n"+
+					"- failed to save content blocks: s3 unavailable\n"+
+					"- s3: s3 unavailable", body["message"])
+			},
+		},
+		{
+			format: FormatSlack,
+			check: func(t *testing.T, body map[string]interface{}) {
+				assert.Contains(t, body["text"], "Backup run 20241121T000000Z-abcd failed")
+				attachment := body["attachments"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, "#E01E5A", attachment["color"])
+				assert.Len(t, attachment["fields"], 4)
+			},
+		},
+		{
+			format: FormatTeams,
+			check: func(t *testing.T, body map[string]interface{}) {
+				assert.Equal(t, "MessageCard", body["@type"])
+				assert.Equal(t, "Backup failed", body["title"])
+				assert.Equal(t, "E01E5A", body["themeColor"])
+				assert.Contains(t, body["text"], "\n\n- s3: s3 unavailable")
+				section := body["sections"].([]interface{})[0].(map[string]interface{})
+				assert.Len(t, section["facts"], 5)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			recv := &receiver{}
+			server := httptest.NewServer(recv)
+			defer server.Close()
+
+			webhook, err := NewWebhook(WebhookOptions{URL: server.URL, Format: tt.format})
+			require.NoError(t, err)
+			require.NoError(t, webhook.Notify(context.Background(), testEvent()))
+
+			require.Len(t, recv.bodies, 1)
+			tt.check(t, recv.bodies[0])
+		})
+	}
+}
+
+func TestWebhook_Template(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	webhook, err := NewWebhook(WebhookOptions{
+		URL:      server.URL,
+		Format:   FormatSlack,
+		Template: "{{.RunID}} {{.Status}} after {{.Duration}} ({{len .Failures}} failures)",
+	})
+	require.NoError(t, err)
+	require.NoError(t, webhook.Notify(context.Background(), testEvent()))
+	assert.Equal(t, "20241121T000000Z-abcd failed after 1m15s (2 failures)", recv.bodies[0]["text"])
+
+	_, err = NewWebhook(WebhookOptions{URL: server.URL, Template: "{{.RunID"})
+	assert.Error(t, err)
+	_, err = NewWebhook(WebhookOptions{URL: server.URL, Format: "discord"})
+	assert.Error(t, err)
+}
+
+func TestWebhook_Retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int
+		expectError   bool
+	}{
+		{name: "Recovers after server errors", statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests}, expectedCalls: 3},
+		{name: "Gives up after all attempts", statuses: []int{500, 500, 500, 500}, expectedCalls: 3, expectError: true},
+		{name: "Does not retry client errors", statuses: []int{http.StatusNotFound}, expectedCalls: 1, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(recv)
+			defer server.Close()
+
+			webhook, err := NewWebhook(WebhookOptions{URL: server.URL, Backoff: time.Millisecond})
+			require.NoError(t, err)
+			err = webhook.Notify(context.Background(), testEvent())
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, recv.bodies, tt.expectedCalls)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// maxRunHistory is the number of runs kept in memory for the control API
+const maxRunHistory = 50
+
+var (
+	// ErrRunInProgress is returned when a backup or restore is already running
+	ErrRunInProgress = errors.New("a run is already in progress")
+	// ErrRunNotFound is returned for a run ID that is unknown or no longer in the history
+	ErrRunNotFound = errors.New("run not found")
+	// ErrRunFinished is returned when cancelling a run that has already finished
+	ErrRunFinished = errors.New("run has already finished")
+)
+
+// Triggers of a run
+const (
+	TriggerSchedule = "schedule"
+	TriggerAPI      = "api"
+)
+
+// States of a run
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// RunOptions override what a triggered run backs up
+type RunOptions struct {
+	// Since fetches changes from this time instead of the checkpoint
+	Since *time.Time `json:"since,omitempty"`
+	// AssetTypes only fetches assets of these types, e.g. htmlemail
+	AssetTypes []string `json:"assetTypes,omitempty"`
+	// Full fetches every asset regardless of the checkpoint and marks the folder as a
+	// full snapshot, unless AssetTypes limits it
+	Full bool `json:"full,omitempty"`
+}
+
+// backupType returns the type of backup the options make
+func (o RunOptions) backupType() string {
+	if o.Full && len(o.AssetTypes) == 0 {
+		return model.BackupFull
+	}
+	return model.BackupIncremental
+}
+
+// partial reports whether the options skip changes a scheduled run would back up, so
+// the run must not move the checkpoint past them
+func (o RunOptions) partial(checkpoint time.Time) bool {
+	return len(o.AssetTypes) > 0 || o.Since != nil && o.Since.After(checkpoint)
+}
+
+// RunInfo describes a backup run started since the process started
+type RunInfo struct {
+	RunID      string         `json:"runId"`
+	Trigger    string         `json:"trigger"`
+	State      string         `json:"state"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	Folder     string         `json:"folder,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Progress   model.Progress `json:"progress"`
+	Options    RunOptions     `json:"options"`
+}
+
+// runRecord is a run in the history, guarded by stateMu
+type runRecord struct {
+	info      RunInfo
+	report    *model.RunReport
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// snapshot returns the info of the run with its current progress, stateMu must be held
+func (r *runRecord) snapshot() RunInfo {
+	info := r.info
+	info.Progress = r.report.Snapshot()
+	if report := r.report.Copy(); report != nil {
+		info.Folder = report.Folder
+	}
+	return info
+}
+
+type runRequestKey struct{}
+
+// runRequest is how a run was requested, carried by the context of the run
+type runRequest struct {
+	trigger string
+	options RunOptions
+}
+
+func runRequestFromContext(ctx context.Context) runRequest {
+	req, ok := ctx.Value(runRequestKey{}).(runRequest)
+	if !ok {
+		return runRequest{trigger: TriggerSchedule}
+	}
+	return req
+}
+
+// TriggerBackup starts a backup run in the background and returns its ID. It fails with
+// ErrRunInProgress while another backup or restore is running.
+func (s *Scheduler) TriggerBackup(opts RunOptions) (string, error) {
+	if !s.runLock.TryLock() {
+		return "", ErrRunInProgress
+	}
+	start := time.Now()
+	report := &model.RunReport{RunID: model.NewRunID(start), Type: opts.backupType(), StartedAt: start.UTC()}
+	ctx := model.WithRunID(context.Background(), report.RunID)
+	ctx = model.WithRunReport(ctx, report)
+	ctx = logging.WithLogger(ctx, s.log())
+	ctx = context.WithValue(ctx, runRequestKey{}, runRequest{trigger: TriggerAPI, options: opts})
+	ctx, cancel := context.WithCancel(ctx)
+	// the run is visible, and can be cancelled, as soon as it is accepted
+	s.startRun(ctx, report, start, cancel)
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		defer s.runLock.Unlock()
+		defer cancel()
+		s.log().InfoContext(ctx, "Starting triggered backup")
+		if err := s.executeBackup(ctx); err != nil {
+			s.log().ErrorContext(ctx, "Backup failed", "error", err)
+			return
+		}
+		s.log().InfoContext(ctx, "Backup completed successfully")
+	}()
+	return report.RunID, nil
+}
+
+// RunExclusive calls fn unless a backup is running, and keeps backups from starting
+// until it returns
+func (s *Scheduler) RunExclusive(fn func() error) error {
+	if !s.runLock.TryLock() {
+		return ErrRunInProgress
+	}
+	defer s.runLock.Unlock()
+	return fn()
+}
+
+// Runs returns the recent runs, newest first
+func (s *Scheduler) Runs() []RunInfo {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	runs := make([]RunInfo, 0, len(s.runs))
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		runs = append(runs, s.runs[i].snapshot())
+	}
+	return runs
+}
+
+// LookupRun returns a run and a copy of its report so far
+func (s *Scheduler) LookupRun(runID string) (RunInfo, *model.RunReport, error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	record := s.findRun(runID)
+	if record == nil {
+		return RunInfo{}, nil, ErrRunNotFound
+	}
+	return record.snapshot(), record.report.Copy(), nil
+}
+
+// CancelRun cancels the context of a running backup
+func (s *Scheduler) CancelRun(runID string) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	record := s.findRun(runID)
+	if record == nil {
+		return ErrRunNotFound
+	}
+	if record.info.State != StateRunning || record.cancel == nil {
+		return ErrRunFinished
+	}
+	record.cancelled = true
+	record.cancel()
+	return nil
+}
+
+// findRun returns the newest run with the ID, stateMu must be held
+func (s *Scheduler) findRun(runID string) *runRecord {
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if s.runs[i].info.RunID == runID {
+			return s.runs[i]
+		}
+	}
+	return nil
+}
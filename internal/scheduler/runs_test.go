@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	mock_service "github.com/Feride3d/backup-creator/internal/scheduler/mocks"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForRun waits until the run has left the running state
+func waitForRun(t *testing.T, s *Scheduler, runID string) RunInfo {
+	t.Helper()
+	var info RunInfo
+	require.Eventually(t, func() bool {
+		var err error
+		info, _, err = s.LookupRun(runID)
+		return err == nil && info.State != StateRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	return info
+}
+
+func TestScheduler_TriggerBackup_Cancel(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	fetching := make(chan struct{})
+	mockFetchService := new(mock_service.ContentProvider)
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, lastRun time.Time) ([]model.ContentBlock, error) {
+			close(fetching)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	s := &Scheduler{
+		fetchService:  mockFetchService,
+		backupService: new(mock_service.Backuper),
+		lastRunFile:   lastRunFile,
+	}
+
+	runID, err := s.TriggerBackup(RunOptions{})
+	require.NoError(t, err)
+	<-fetching
+
+	// cron runs, other triggered runs and restores wait for the running backup
+	assert.ErrorIs(t, s.ExecuteBackup(context.Background()), ErrRunInProgress)
+	_, err = s.TriggerBackup(RunOptions{})
+	assert.ErrorIs(t, err, ErrRunInProgress)
+	assert.ErrorIs(t, s.RunExclusive(func() error { return nil }), ErrRunInProgress)
+
+	runs := s.Runs()
+	require.Len(t, runs, 1)
+	assert.Equal(t, runID, runs[0].RunID)
+	assert.Equal(t, TriggerAPI, runs[0].Trigger)
+	assert.Equal(t, StateRunning, runs[0].State)
+	assert.Nil(t, runs[0].FinishedAt)
+
+	require.NoError(t, s.CancelRun(runID))
+	info := waitForRun(t, s, runID)
+	assert.Equal(t, StateCancelled, info.State)
+	assert.Contains(t, info.Error, "context canceled")
+	assert.NotNil(t, info.FinishedAt)
+
+	assert.ErrorIs(t, s.CancelRun(runID), ErrRunFinished)
+	assert.ErrorIs(t, s.CancelRun("unknown"), ErrRunNotFound)
+	_, _, err = s.LookupRun("unknown")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+
+	checkpoint, err := s.GetLastRunTime()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 11, 22, 9, 0, 0, 0, time.UTC), checkpoint)
+	assert.NoError(t, s.RunExclusive(func() error { return nil }))
+}
+
+func TestScheduler_TriggerBackup_CancelImmediately(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	mockFetchService := new(mock_service.ContentProvider)
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, lastRun time.Time) ([]model.ContentBlock, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).Maybe()
+	s := &Scheduler{
+		fetchService:  mockFetchService,
+		backupService: new(mock_service.Backuper),
+		lastRunFile:   lastRunFile,
+	}
+
+	for i := 0; i < 20; i++ {
+		runID, err := s.TriggerBackup(RunOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.CancelRun(runID))
+		info := waitForRun(t, s, runID)
+		assert.Equal(t, StateCancelled, info.State)
+		assert.Contains(t, info.Error, "context canceled")
+		s.jobs.Wait()
+	}
+
+	checkpoint, err := s.GetLastRunTime()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 11, 22, 9, 0, 0, 0, time.UTC), checkpoint)
+}
+
+func TestScheduler_TriggerBackup_Options(t *testing.T) {
+	checkpoint := time.Date(2023, 11, 22, 9, 0, 0, 0, time.UTC)
+	earlier := checkpoint.Add(-48 * time.Hour)
+	later := checkpoint.Add(time.Hour)
+
+	tests := []struct {
+		name           string
+		opts           RunOptions
+		since          time.Time
+		assetTypes     []string
+		backupType     string
+		moveCheckpoint bool
+	}{
+		{name: "Checkpoint", since: checkpoint, backupType: model.BackupIncremental, moveCheckpoint: true},
+		{name: "Earlier since", opts: RunOptions{Since: &earlier}, since: earlier, backupType: model.BackupIncremental, moveCheckpoint: true},
+		{name: "Later since", opts: RunOptions{Since: &later}, since: later, backupType: model.BackupIncremental},
+		{name: "Asset types", opts: RunOptions{AssetTypes: []string{"htmlemail"}}, since: checkpoint, assetTypes: []string{"htmlemail"}, backupType: model.BackupIncremental},
+		{name: "Full snapshot", opts: RunOptions{Full: true}, since: time.Time{}, backupType: model.BackupFull, moveCheckpoint: true},
+		{name: "Full snapshot of some asset types", opts: RunOptions{Full: true, AssetTypes: []string{"htmlemail"}}, since: time.Time{},
+			assetTypes: []string{"htmlemail"}, backupType: model.BackupIncremental},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+			require.NoError(t, os.WriteFile(lastRunFile, []byte(checkpoint.Format(time.RFC3339)), 0644))
+
+			var assetTypes []string
+			mockFetchService := new(mock_service.ContentProvider)
+			mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, tt.since).Run(func(args mock.Arguments) {
+				assetTypes = service.AssetTypesFromContext(args.Get(0).(context.Context))
+			}).Return([]model.ContentBlock{{ID: 1}}, nil)
+			var backupType string
+			mockBackupService := new(mock_service.Backuper)
+			mockBackupService.On("SaveContent", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				backupType = model.BackupTypeFromContext(args.Get(0).(context.Context))
+			}).Return(nil)
+			s := &Scheduler{
+				fetchService:  mockFetchService,
+				backupService: mockBackupService,
+				lastRunFile:   lastRunFile,
+			}
+
+			runID, err := s.TriggerBackup(tt.opts)
+			require.NoError(t, err)
+			info := waitForRun(t, s, runID)
+			assert.Equal(t, StateSucceeded, info.State)
+			assert.Equal(t, tt.opts, info.Options)
+			assert.Equal(t, tt.assetTypes, assetTypes)
+
+			_, report, err := s.LookupRun(runID)
+			require.NoError(t, err)
+			assert.True(t, report.Success)
+			assert.Equal(t, info.Folder, report.Folder)
+			assert.Equal(t, tt.backupType, report.Type)
+			assert.Equal(t, tt.backupType, backupType)
+
+			moved, err := s.GetLastRunTime()
+			require.NoError(t, err)
+			assert.Equal(t, tt.moveCheckpoint, moved.After(checkpoint))
+			mockFetchService.AssertExpectations(t)
+		})
+	}
+}
@@ -9,10 +9,18 @@ import (
 	"time"
 
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/notify"
 	mock_service "github.com/Feride3d/backup-creator/internal/scheduler/mocks"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
 	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestGetLastRunTime(t *testing.T) {
@@ -184,3 +192,359 @@ func TestScheduler_Run(t *testing.T) {
 	mockFetchService.AssertExpectations(t)
 	mockBackupService.AssertExpectations(t)
 }
+
+func TestScheduler_Status(t *testing.T) {
+	mockFetchService := new(mock_service.ContentProvider)
+	mockBackupService := new(mock_service.Backuper)
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+
+	blocks := []model.ContentBlock{{ID: 1}, {ID: 2}}
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return(blocks, nil)
+
+	scheduler := &Scheduler{
+		cronScheduler: cron.New(),
+		fetchService:  mockFetchService,
+		backupService: mockBackupService,
+		lastRunFile:   lastRunFile,
+	}
+
+	// the run in progress is visible while blocks are being saved
+	var during Status
+	mockBackupService.On("SaveContent", mock.Anything, blocks, mock.Anything).Run(func(args mock.Arguments) {
+		ctx := args.Get(0).(context.Context)
+		model.RunReportFromContext(ctx).AddFetched(len(blocks))
+		model.RunReportFromContext(ctx).AddSaved(true)
+		during = scheduler.Status()
+	}).Return(nil).Once()
+	mockBackupService.On("SaveContent", mock.Anything, blocks, mock.Anything).Return(fmt.Errorf("disk full")).Once()
+
+	status := scheduler.Status()
+	assert.False(t, status.Running)
+	assert.Nil(t, status.NextRun)
+	assert.Nil(t, status.Checkpoint)
+	assert.Nil(t, status.LastRun)
+
+	ctx := model.WithRunID(context.Background(), "20241121T000000Z")
+	assert.NoError(t, scheduler.ExecuteBackup(ctx))
+
+	if assert.NotNil(t, during.Current) {
+		assert.Equal(t, "20241121T000000Z", during.Current.RunID)
+		assert.Equal(t, model.Progress{Fetched: 2, Saved: 1}, during.Current.Progress)
+	}
+
+	status = scheduler.Status()
+	assert.Nil(t, status.Current)
+	assert.NotNil(t, status.Checkpoint)
+	if assert.NotNil(t, status.LastRun) {
+		assert.True(t, status.LastRun.Success)
+		assert.Equal(t, "20241121T000000Z", status.LastRun.RunID)
+	}
+
+	assert.Error(t, scheduler.ExecuteBackup(context.Background()))
+	status = scheduler.Status()
+	assert.False(t, status.LastRun.Success)
+	assert.Contains(t, status.LastRun.Error, "disk full")
+
+	assert.NoError(t, scheduler.Run("0 0 * * *"))
+	defer scheduler.Stop()
+	status = scheduler.Status()
+	assert.True(t, status.Running)
+	if assert.NotNil(t, status.NextRun) {
+		assert.True(t, status.NextRun.After(time.Now()))
+		assert.Equal(t, 0, status.NextRun.Hour())
+	}
+}
+
+type fakeProvider struct {
+	blocks []model.ContentBlock
+}
+
+func (p *fakeProvider) GetUpdatedContentBlocksConcurrent(ctx context.Context, lastRun time.Time, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error) {
+	return p.blocks, nil
+}
+
+func (p *fakeProvider) FetchPage(ctx context.Context, query map[string]interface{}, page, pageSize int) ([]model.ContentBlock, error) {
+	return p.blocks, nil
+}
+
+type fakeStorage struct {
+	err error
+}
+
+func (f *fakeStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	return f.err
+}
+
+func TestExecuteBackup_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	fanOut := storage.NewFanOutStorage(storage.FanOutAll, storage.Destination{Name: "local", Storage: &fakeStorage{}})
+	s := &Scheduler{
+		fetchService:  service.NewFetchService(&fakeProvider{blocks: []model.ContentBlock{{ID: 1}, {ID: 2}}}),
+		backupService: service.NewBackupService(fanOut),
+		lastRunFile:   lastRunFile,
+	}
+	ctx := model.WithRunID(context.Background(), "run-1")
+	require.NoError(t, s.ExecuteBackup(ctx))
+
+	spans := recorder.Ended()
+	byID := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		byID[span.SpanContext().SpanID().String()] = span
+	}
+	parentName := func(span sdktrace.ReadOnlySpan) string {
+		if parent, ok := byID[span.Parent().SpanID().String()]; ok {
+			return parent.Name()
+		}
+		return ""
+	}
+
+	tree := make(map[string][]string)
+	for _, span := range spans {
+		tree[parentName(span)] = append(tree[parentName(span)], span.Name())
+	}
+	assert.Equal(t, []string{"backup.run"}, tree[""])
+	assert.ElementsMatch(t, []string{"fetch.updated_blocks", "storage.save", "storage.save", "storage.finalize"}, tree["backup.run"])
+	assert.Equal(t, []string{"storage.write", "storage.write"}, tree["storage.save"])
+
+	for _, span := range spans {
+		assert.Equal(t, spans[0].SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+		if span.Name() == "backup.run" {
+			assert.Contains(t, span.Attributes(), attribute.String("run_id", "run-1"))
+		}
+	}
+}
+
+func TestExecuteBackup_Notifies(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	mockFetchService := new(mock_service.ContentProvider)
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return([]model.ContentBlock{{ID: 1}}, nil)
+	mockBackupService := new(mock_service.Backuper)
+	mockBackupService.On("SaveContent", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("disk full"))
+
+	var events []notify.Event
+	s := &Scheduler{
+		fetchService:  mockFetchService,
+		backupService: mockBackupService,
+		lastRunFile:   lastRunFile,
+	}
+	s.SetNotifier(notify.Func(func(ctx context.Context, event notify.Event) error {
+		events = append(events, event)
+		return fmt.Errorf("webhook unreachable")
+	}))
+
+	ctx := model.WithRunID(context.Background(), "run-1")
+	err := s.ExecuteBackup(ctx)
+	assert.EqualError(t, err, "failed to save content blocks: disk full")
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "run-1", events[0].RunID)
+	assert.False(t, events[0].Success)
+	assert.Equal(t, err.Error(), events[0].Error)
+	assert.False(t, events[0].FinishedAt.Before(events[0].StartedAt))
+	assert.Equal(t, []notify.Asset{{ID: 1}}, events[0].Assets)
+	assert.Regexp(t, `^backup_\d{8}`, events[0].Folder)
+}
+
+func TestExecuteBackup_WritesReport(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+	store := storage.NewLocalStorage(t.TempDir())
+
+	blocks := []model.ContentBlock{
+		{ID: 1, Name: "Welcome", AssetType: &model.AssetType{Name: "htmlemail"}},
+		{ID: 2, Name: "Footer", AssetType: &model.AssetType{Name: "htmlblock"}},
+		{ID: 3, Name: "Header", AssetType: &model.AssetType{Name: "htmlblock"}},
+	}
+	s := &Scheduler{
+		fetchService:  service.NewFetchService(&fakeProvider{blocks: blocks}),
+		backupService: service.NewBackupService(store),
+		lastRunFile:   lastRunFile,
+	}
+	s.AddReportWriter(storage.NewReportWriter(store, false))
+
+	require.NoError(t, s.ExecuteBackup(model.WithRunID(context.Background(), "run-1")))
+
+	report, err := storage.LoadReport(context.Background(), store, storage.FolderName(time.Now()))
+	require.NoError(t, err)
+	assert.Equal(t, "run-1", report.RunID)
+	assert.True(t, report.Success)
+	assert.Equal(t, model.Progress{Fetched: 3, Saved: 3}, report.Progress)
+	assert.Equal(t, map[string]*model.Progress{
+		"htmlemail": {Fetched: 1, Saved: 1},
+		"htmlblock": {Fetched: 2, Saved: 2},
+	}, report.AssetTypes)
+	assert.False(t, report.FinishedAt.Before(report.StartedAt))
+
+	var events []string
+	for _, e := range report.Timeline {
+		events = append(events, e.Event)
+	}
+	assert.Equal(t, []string{"run started", "fetch started", "fetch finished", "save started", "save finished", "run finished"}, events)
+}
+
+type recorderFunc func(ctx context.Context, report *model.RunReport) error
+
+func (f recorderFunc) RecordRun(ctx context.Context, report *model.RunReport) error {
+	return f(ctx, report)
+}
+
+func TestExecuteBackup_RecordsRun(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	mockFetchService := new(mock_service.ContentProvider)
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("fetch error"))
+	s := &Scheduler{
+		fetchService:  mockFetchService,
+		backupService: new(mock_service.Backuper),
+		lastRunFile:   lastRunFile,
+	}
+	var recorded []*model.RunReport
+	s.AddRunRecorder(recorderFunc(func(ctx context.Context, report *model.RunReport) error {
+		recorded = append(recorded, report)
+		return fmt.Errorf("index in use")
+	}))
+
+	assert.Error(t, s.ExecuteBackup(model.WithRunID(context.Background(), "run-1")))
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "run-1", recorded[0].RunID)
+	assert.Empty(t, recorded[0].Folder)
+	assert.Contains(t, recorded[0].Error, "fetch error")
+}
+
+type fakeReconciler struct {
+	rec       *service.Reconciliation
+	committed []*service.Reconciliation
+}
+
+func (f *fakeReconciler) Check(ctx context.Context) (*service.Reconciliation, error) {
+	return f.rec, nil
+}
+
+func (f *fakeReconciler) Commit(rec *service.Reconciliation) error {
+	f.committed = append(f.committed, rec)
+	return nil
+}
+
+func TestExecuteBackup_Reconciles(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	deleted := []model.Tombstone{
+		{AssetRef: model.AssetRef{ID: 2, Name: "Footer"}, DetectedAt: time.Now()},
+		{AssetRef: model.AssetRef{ID: 3, Name: "Header"}, DetectedAt: time.Now()},
+	}
+	reconciler := &fakeReconciler{rec: &service.Reconciliation{Deleted: deleted}}
+	saveErr := fmt.Errorf("disk full")
+	s := &Scheduler{
+		fetchService:  service.NewFetchService(&fakeProvider{blocks: []model.ContentBlock{{ID: 1}}}),
+		backupService: service.NewBackupService(storage.NewFanOutStorage(storage.FanOutAll, storage.Destination{Name: "local", Storage: &fakeStorage{err: saveErr}})),
+		lastRunFile:   lastRunFile,
+	}
+	s.SetReconciler(reconciler, 1)
+	var events []notify.Event
+	s.SetNotifier(notify.Func(func(ctx context.Context, event notify.Event) error {
+		events = append(events, event)
+		return nil
+	}))
+	var reports []*model.RunReport
+	s.AddRunRecorder(recorderFunc(func(ctx context.Context, report *model.RunReport) error {
+		reports = append(reports, report)
+		return nil
+	}))
+
+	// the inventory is kept until the deletions are saved
+	require.Error(t, s.ExecuteBackup(model.WithRunID(context.Background(), "run-1")))
+	assert.Empty(t, reconciler.committed)
+
+	s.backupService = service.NewBackupService(storage.NewFanOutStorage(storage.FanOutAll, storage.Destination{Name: "local", Storage: &fakeStorage{}}))
+	require.NoError(t, s.ExecuteBackup(model.WithRunID(context.Background(), "run-2")))
+	assert.Equal(t, []*service.Reconciliation{reconciler.rec}, reconciler.committed)
+
+	require.Len(t, reports, 2)
+	assert.Equal(t, deleted, reports[1].DeletedAssets())
+	require.Len(t, events, 2)
+	assert.Equal(t, []notify.Asset{{ID: 2, Name: "Footer"}, {ID: 3, Name: "Header"}}, events[1].Deleted)
+	assert.Equal(t, []string{"2 assets were deleted from Marketing Cloud since the last check, more than the alert threshold of 1. This may be an accidental mass deletion."}, events[1].Alerts)
+}
+
+type dataExtensionBackupFunc func(ctx context.Context, folder string) (*model.DataExtensionManifest, error)
+
+func (f dataExtensionBackupFunc) Backup(ctx context.Context, folder string) (*model.DataExtensionManifest, error) {
+	return f(ctx, folder)
+}
+
+func TestExecuteBackup_DataExtensions(t *testing.T) {
+	lastRunFile := filepath.Join(t.TempDir(), "lastrun.txt")
+	require.NoError(t, os.WriteFile(lastRunFile, []byte("2023-11-22T09:00:00Z"), 0644))
+
+	var folders []string
+	s := &Scheduler{
+		fetchService:  service.NewFetchService(&fakeProvider{blocks: []model.ContentBlock{{ID: 1}}}),
+		backupService: service.NewBackupService(storage.NewFanOutStorage(storage.FanOutAll, storage.Destination{Name: "local", Storage: &fakeStorage{}})),
+		lastRunFile:   lastRunFile,
+	}
+	s.SetDataExtensionBackup(dataExtensionBackupFunc(func(ctx context.Context, folder string) (*model.DataExtensionManifest, error) {
+		folders = append(folders, folder)
+		manifest := &model.DataExtensionManifest{Folder: folder, Entries: []model.DataExtensionEntry{{Rows: 10, ExpectedRows: 10}, {Rows: 4, ExpectedRows: 5}}}
+		return manifest, fmt.Errorf("data extension stores: wrote 4 rows, expected 5")
+	}))
+	var reports []*model.RunReport
+	s.AddRunRecorder(recorderFunc(func(ctx context.Context, report *model.RunReport) error {
+		reports = append(reports, report)
+		return nil
+	}))
+
+	err := s.ExecuteBackup(model.WithRunID(context.Background(), "run-1"))
+	assert.EqualError(t, err, "failed to back up data extensions: data extension stores: wrote 4 rows, expected 5")
+	require.Len(t, folders, 1)
+	assert.Equal(t, storage.FolderName(time.Now()), folders[0])
+	require.Len(t, reports, 1)
+	timeline := reports[0].Timeline
+	require.GreaterOrEqual(t, len(timeline), 2)
+	assert.Equal(t, "data extensions finished", timeline[len(timeline)-2].Event)
+	assert.Equal(t, "2 data extensions, 14 rows", timeline[len(timeline)-2].Detail)
+
+	// runs limited to some asset types leave the data extensions out
+	ctx := context.WithValue(model.WithRunID(context.Background(), "run-2"), runRequestKey{}, runRequest{trigger: TriggerAPI, options: RunOptions{AssetTypes: []string{"htmlemail"}}})
+	require.NoError(t, s.ExecuteBackup(ctx))
+	assert.Len(t, folders, 1)
+}
+
+// triggeringPruner tries to start a backup through the API while it prunes
+type triggeringPruner struct {
+	scheduler *Scheduler
+	err       error
+}
+
+func (p *triggeringPruner) Prune(ctx context.Context, dryRun bool) (*storage.PrunePlan, error) {
+	_, p.err = p.scheduler.TriggerBackup(RunOptions{})
+	return &storage.PrunePlan{}, nil
+}
+
+func TestScheduledBackup_PrunesUnderRunLock(t *testing.T) {
+	mockFetchService := new(mock_service.ContentProvider)
+	mockFetchService.On("GetUpdatedContentBlocks", mock.Anything, mock.Anything).Return([]model.ContentBlock{{ID: 1}}, nil)
+	mockBackupService := new(mock_service.Backuper)
+	mockBackupService.On("SaveContent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	s := &Scheduler{
+		fetchService:  mockFetchService,
+		backupService: mockBackupService,
+		lastRunFile:   filepath.Join(t.TempDir(), "lastrun.txt"),
+	}
+	pruner := &triggeringPruner{scheduler: s}
+	s.AddPruner(pruner)
+
+	s.scheduledBackup(RunOptions{})()
+	assert.ErrorIs(t, pruner.err, ErrRunInProgress)
+	assert.Len(t, s.Runs(), 1)
+}
@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// RunResult is the outcome of a finished backup run
+type RunResult struct {
+	RunID      string         `json:"runId"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Success    bool           `json:"success"`
+	Error      string         `json:"error,omitempty"`
+	Progress   model.Progress `json:"progress"`
+}
+
+// CurrentRun describes a backup run in progress
+type CurrentRun struct {
+	RunID     string         `json:"runId"`
+	StartedAt time.Time      `json:"startedAt"`
+	Progress  model.Progress `json:"progress"`
+}
+
+// Status is a snapshot of the scheduler state
+type Status struct {
+	Running    bool        `json:"running"`
+	NextRun    *time.Time  `json:"nextRun,omitempty"`
+	Checkpoint *time.Time  `json:"checkpoint,omitempty"`
+	LastRun    *RunResult  `json:"lastRun,omitempty"`
+	Current    *CurrentRun `json:"current,omitempty"`
+}
+
+// Running reports whether the cron scheduler has been started and not stopped
+func (s *Scheduler) Running() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.running
+}
+
+// Status returns the next scheduled run, the checkpoint the next run fetches changes
+// from, the result of the last run and the progress of the run in progress
+func (s *Scheduler) Status() Status {
+	s.stateMu.Lock()
+	status := Status{Running: s.running}
+	if s.last != nil {
+		last := *s.last
+		status.LastRun = &last
+	}
+	if s.current != nil {
+		status.Current = &CurrentRun{
+			RunID:     s.current.RunID,
+			StartedAt: s.currentStart,
+			Progress:  s.current.Snapshot(),
+		}
+	}
+	s.stateMu.Unlock()
+
+	if s.cronScheduler != nil && s.backupEntry != 0 {
+		entry := s.cronScheduler.Entry(s.backupEntry)
+		next := entry.Next
+		if next.IsZero() && entry.Schedule != nil {
+			// entries are only scheduled once the cron scheduler has started
+			next = entry.Schedule.Next(time.Now())
+		}
+		if !next.IsZero() {
+			status.NextRun = &next
+		}
+	}
+	if checkpoint, err := s.GetLastRunTime(); err == nil {
+		status.Checkpoint = &checkpoint
+	}
+	return status
+}
+
+// startRun records report as the run in progress and adds it to the history. cancel
+// cancels the run; a run already in the history gets the cancel of its execution.
+func (s *Scheduler) startRun(ctx context.Context, report *model.RunReport, start time.Time, cancel context.CancelFunc) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.current = report
+	s.currentStart = start
+
+	for _, record := range s.runs {
+		if record.report == report {
+			record.cancel = cancel
+			return
+		}
+	}
+	req := runRequestFromContext(ctx)
+	s.runs = append(s.runs, &runRecord{
+		info: RunInfo{
+			RunID:     report.RunID,
+			Trigger:   req.trigger,
+			State:     StateRunning,
+			StartedAt: start,
+			Options:   req.options,
+		},
+		report: report,
+		cancel: cancel,
+	})
+	if len(s.runs) > maxRunHistory {
+		s.runs = s.runs[len(s.runs)-maxRunHistory:]
+	}
+}
+
+// finishRun records the result of the run in progress
+func (s *Scheduler) finishRun(report *model.RunReport, start time.Time, err error) RunResult {
+	result := &RunResult{
+		RunID:      report.RunID,
+		StartedAt:  start,
+		FinishedAt: time.Now(),
+		Success:    err == nil,
+		Progress:   report.Snapshot(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.current == report {
+		s.current = nil
+	}
+	s.last = result
+	for _, record := range s.runs {
+		if record.report != report {
+			continue
+		}
+		record.info.FinishedAt = &result.FinishedAt
+		record.info.Error = result.Error
+		record.cancel = nil
+		switch {
+		case err == nil:
+			record.info.State = StateSucceeded
+		case record.cancelled:
+			record.info.State = StateCancelled
+		default:
+			record.info.State = StateFailed
+		}
+	}
+	return *result
+}
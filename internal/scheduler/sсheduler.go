@@ -3,14 +3,20 @@ package scheduler
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/notify"
 	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/storage"
+	"github.com/Feride3d/backup-creator/internal/tracing"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Backuper interface {
@@ -25,13 +31,59 @@ type BackupExecutor interface {
 	ExecuteBackup(ctx context.Context) error
 }
 
+// Pruner applies the retention policy after a successful backup
+type Pruner interface {
+	Prune(ctx context.Context, dryRun bool) (*storage.PrunePlan, error)
+}
+
+// ReportWriter stores the report of each run next to its backup
+type ReportWriter interface {
+	WriteReport(ctx context.Context, report *model.RunReport) error
+}
+
+// RunRecorder records every finished run, including runs that failed before saving
+type RunRecorder interface {
+	RecordRun(ctx context.Context, report *model.RunReport) error
+}
+
+// Reconciler detects assets deleted from Marketing Cloud
+type Reconciler interface {
+	Check(ctx context.Context) (*service.Reconciliation, error)
+	Commit(rec *service.Reconciliation) error
+}
+
+// DataExtensionBackuper saves the configured data extensions to the folder of a run
+type DataExtensionBackuper interface {
+	Backup(ctx context.Context, folder string) (*model.DataExtensionManifest, error)
+}
+
 type Scheduler struct {
 	cronScheduler *cron.Cron
+	backupEntry   cron.EntryID
 	executor      BackupExecutor
 	fetchService  ContentProvider
 	backupService Backuper
+	pruners       []Pruner
+	reporters     []ReportWriter
+	recorders     []RunRecorder
+	notifier      notify.Notifier
+	reconciler    Reconciler
+	deletionAlert int
+	dataExts      DataExtensionBackuper
 	lastRunFile   string
+	logger        *slog.Logger
 	mu            sync.Mutex
+	// runLock keeps backups and restores from overlapping, whoever started them
+	runLock sync.Mutex
+	// jobs tracks the runs started outside of cron
+	jobs sync.WaitGroup
+
+	stateMu      sync.Mutex
+	running      bool
+	current      *model.RunReport
+	currentStart time.Time
+	last         *RunResult
+	runs         []*runRecord
 }
 
 func NewScheduler(fetch *service.FetchService, backup *service.BackupService, lastRunFile string) *Scheduler {
@@ -40,55 +92,360 @@ func NewScheduler(fetch *service.FetchService, backup *service.BackupService, la
 		fetchService:  fetch,
 		backupService: backup,
 		lastRunFile:   lastRunFile,
+		logger:        slog.Default(),
 		mu:            sync.Mutex{},
 	}
 }
 
-func (s *Scheduler) Run(cronExpr string) {
+func (s *Scheduler) log() *slog.Logger {
+	if s.logger == nil {
+		return slog.Default()
+	}
+	return s.logger
+}
+
+// SetLogger changes the logger used by the scheduler and passed down to each backup run
+func (s *Scheduler) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// AddPruner enables an automatic prune of a destination after each successful scheduled backup
+func (s *Scheduler) AddPruner(pruner Pruner) {
+	s.pruners = append(s.pruners, pruner)
+}
+
+// AddReportWriter writes the report of each run that reached the save step
+func (s *Scheduler) AddReportWriter(writer ReportWriter) {
+	s.reporters = append(s.reporters, writer)
+}
+
+// AddRunRecorder records each run once it has finished
+func (s *Scheduler) AddRunRecorder(recorder RunRecorder) {
+	s.recorders = append(s.recorders, recorder)
+}
+
+// SetNotifier sets the notifier told about the outcome of each backup run
+func (s *Scheduler) SetNotifier(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
+// SetReconciler checks for deleted assets during each backup. More than alertThreshold
+// deletions found at once raise an alert, 0 disables it.
+func (s *Scheduler) SetReconciler(reconciler Reconciler, alertThreshold int) {
+	s.reconciler = reconciler
+	s.deletionAlert = alertThreshold
+}
+
+// SetDataExtensionBackup saves the data extensions to the folder of each backup that is
+// not limited to some asset types
+func (s *Scheduler) SetDataExtensionBackup(backup DataExtensionBackuper) {
+	s.dataExts = backup
+}
+
+// AddJob runs job on its own cron schedule next to the backups once the scheduler runs
+func (s *Scheduler) AddJob(name, cronExpr string, job func(ctx context.Context) error) error {
 	_, err := s.cronScheduler.AddFunc(cronExpr, func() {
-		log.Println("Starting scheduled backup...")
-		ctx := context.Background()
-		if err := s.ExecuteBackup(ctx); err != nil {
-			log.Printf("Backup failed: %v", err)
-		} else {
-			log.Println("Backup completed successfully!")
+		ctx := logging.WithLogger(context.Background(), s.log())
+		if err := job(ctx); err != nil {
+			s.log().ErrorContext(ctx, "Scheduled job failed", "job", name, "error", err)
 		}
 	})
 	if err != nil {
-		log.Fatalf("Failed to add cron job: %v", err)
+		return fmt.Errorf("failed to add %s job: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Scheduler) Run(cronExpr string) error {
+	entry, err := s.cronScheduler.AddFunc(cronExpr, s.scheduledBackup(RunOptions{}))
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
 	}
+	s.backupEntry = entry
 
+	if lastRun, err := s.GetLastRunTime(); err == nil {
+		metrics.SetCheckpoint(lastRun)
+	}
 	s.cronScheduler.Start()
-	log.Printf("Scheduler started with cron expression: %s", cronExpr)
+	s.stateMu.Lock()
+	s.running = true
+	s.stateMu.Unlock()
+	s.log().Info("Scheduler started", "cron", cronExpr)
+	return nil
+}
+
+// ScheduleFull takes a full snapshot of every asset on its own cron schedule, e.g.
+// weekly, next to the incremental backups once the scheduler runs
+func (s *Scheduler) ScheduleFull(cronExpr string) error {
+	if _, err := s.cronScheduler.AddFunc(cronExpr, s.scheduledBackup(RunOptions{Full: true})); err != nil {
+		return fmt.Errorf("failed to add full snapshot job: %w", err)
+	}
+	return nil
+}
+
+// scheduledBackup returns the cron job running a backup with opts and pruning after it
+func (s *Scheduler) scheduledBackup(opts RunOptions) func() {
+	return func() {
+		ctx := model.WithRunID(context.Background(), model.NewRunID(time.Now()))
+		ctx = logging.WithLogger(ctx, s.log())
+		ctx = context.WithValue(ctx, runRequestKey{}, runRequest{trigger: TriggerSchedule, options: opts})
+		s.log().InfoContext(ctx, "Starting scheduled backup", "type", opts.backupType())
+		// the prune runs under the lock too: a backup starting in between could reuse an
+		// unreferenced object the garbage collection is about to delete
+		if !s.runLock.TryLock() {
+			s.log().WarnContext(ctx, "Skipping scheduled backup, another run is in progress")
+			return
+		}
+		defer s.runLock.Unlock()
+		err := s.executeBackup(ctx)
+		if err != nil {
+			s.log().ErrorContext(ctx, "Backup failed", "error", err)
+			return
+		}
+		s.log().InfoContext(ctx, "Backup completed successfully")
+		for _, pruner := range s.pruners {
+			plan, err := pruner.Prune(ctx, false)
+			if err != nil {
+				s.log().ErrorContext(ctx, "Prune failed", "error", err)
+				continue
+			}
+			s.log().InfoContext(ctx, "Prune completed", "kept", len(plan.Keep), "deleted", len(plan.Delete), "locked", len(plan.Locked))
+		}
+	}
+}
+
+// Stop stops the scheduler and returns a context that is done once running jobs,
+// including triggered runs, have completed
+func (s *Scheduler) Stop() context.Context {
+	s.stateMu.Lock()
+	s.running = false
+	s.stateMu.Unlock()
+	cronDone := s.cronScheduler.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cronDone.Done()
+		s.jobs.Wait()
+		cancel()
+	}()
+	return ctx
 }
 
+// ExecuteBackup backs up the changes since the checkpoint. It fails with
+// ErrRunInProgress while another backup or restore is running.
 func (s *Scheduler) ExecuteBackup(ctx context.Context) error {
-	lastRun, err := s.GetLastRunTime()
+	if !s.runLock.TryLock() {
+		return ErrRunInProgress
+	}
+	defer s.runLock.Unlock()
+	return s.executeBackup(ctx)
+}
+
+// executeBackup runs a backup, the caller holds runLock
+func (s *Scheduler) executeBackup(ctx context.Context) (err error) {
+	start := time.Now()
+	if model.RunIDFromContext(ctx) == "" {
+		ctx = model.WithRunID(ctx, model.NewRunID(start))
+	}
+	ctx = logging.WithLogger(ctx, s.log())
+	report := model.RunReportFromContext(ctx)
+	if report == nil {
+		report = &model.RunReport{RunID: model.RunIDFromContext(ctx)}
+		ctx = model.WithRunReport(ctx, report)
+	}
+
+	if report.StartedAt.IsZero() {
+		report.StartedAt = start.UTC()
+	}
+	opts := runRequestFromContext(ctx).options
+	if report.Type == "" {
+		report.Type = opts.backupType()
+	}
+	ctx = model.WithBackupType(ctx, report.Type)
+	report.AddEvent("run started", "")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "backup.run", attribute.String("run_id", report.RunID))
+	var blocks []model.ContentBlock
+	var folder string
+	s.startRun(ctx, report, start, cancel)
+	defer func() {
+		tracing.End(span, err)
+		report.Finish(err)
+		s.writeReports(ctx, report)
+		s.recordRun(ctx, report)
+		result := s.finishRun(report, start, err)
+		metrics.RunDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.Runs.WithLabelValues("failure").Inc()
+		} else {
+			metrics.Runs.WithLabelValues("success").Inc()
+			metrics.LastSuccess.SetToCurrentTime()
+		}
+		s.notify(ctx, result, report, folder, blocks)
+	}()
+	// a triggered run may be cancelled before it started executing
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	checkpoint, err := s.GetLastRunTime()
 	if err != nil {
-		log.Printf("Warning: unable to determine last run time: %v. Using default.", err)
-		lastRun = time.Now().Add(-24 * time.Hour)
+		s.log().WarnContext(ctx, "Unable to determine last run time, using default", "error", err)
+		checkpoint = time.Now().Add(-24 * time.Hour)
 	}
+	lastRun := checkpoint
+	if opts.Since != nil {
+		lastRun = *opts.Since
+	}
+	if opts.Full {
+		lastRun = time.Time{}
+	}
+	ctx = service.WithAssetTypes(ctx, opts.AssetTypes)
 	if s.fetchService == nil {
 		return fmt.Errorf("fetchService is not initialized")
 	}
-	log.Println("Fetching updated content blocks...")
-	blocks, err := s.fetchService.GetUpdatedContentBlocks(ctx, lastRun)
+	s.log().InfoContext(ctx, "Fetching updated content blocks", "since", lastRun, "type", report.Type)
+	if opts.Full {
+		report.AddEvent("fetch started", "all assets")
+	} else {
+		report.AddEvent("fetch started", "since "+lastRun.UTC().Format(time.RFC3339))
+	}
+	blocks, err = s.fetchService.GetUpdatedContentBlocks(ctx, lastRun)
 	if err != nil {
 		return fmt.Errorf("failed to fetch content blocks: %w", err)
 	}
+	report.AddEvent("fetch finished", fmt.Sprintf("%d assets", len(blocks)))
+	reconciliation := s.reconcile(ctx, report)
 
 	if s.backupService == nil {
 		return fmt.Errorf("backupService is not initialized")
 	}
-	folder := time.Now().Format("backup_20241121")
-	log.Println("Saving content blocks...")
-	if err := s.backupService.SaveContent(ctx, blocks, folder); err != nil {
+	folder = storage.FolderName(time.Now())
+	report.SetFolder(folder)
+	s.log().InfoContext(ctx, "Saving content blocks", "count", len(blocks), "folder", folder)
+	report.AddEvent("save started", folder)
+	err = s.backupService.SaveContent(ctx, blocks, folder)
+	progress := report.Snapshot()
+	report.AddEvent("save finished", fmt.Sprintf("%d saved, %d failed", progress.Saved, progress.Failed))
+	for _, d := range report.Destinations {
+		s.log().InfoContext(ctx, "Destination result", "destination", d.Name, "saved", d.Saved, "failed", d.Failed, "ok", d.OK, "error", d.Error)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to save content blocks: %w", err)
 	}
+	if reconciliation != nil {
+		if err := s.reconciler.Commit(reconciliation); err != nil {
+			s.log().ErrorContext(ctx, "Failed to save asset inventory", "error", err)
+		} else {
+			metrics.AssetsDeleted.Add(float64(len(reconciliation.Deleted)))
+		}
+	}
+	if s.dataExts != nil && len(opts.AssetTypes) == 0 {
+		report.AddEvent("data extensions started", folder)
+		manifest, err := s.dataExts.Backup(ctx, folder)
+		if manifest != nil {
+			var rows int
+			for _, e := range manifest.Entries {
+				rows += e.Rows
+			}
+			report.AddEvent("data extensions finished", fmt.Sprintf("%d data extensions, %d rows", len(manifest.Entries), rows))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to back up data extensions: %w", err)
+		}
+	}
 
+	if opts.partial(checkpoint) {
+		s.log().InfoContext(ctx, "Keeping the checkpoint, the run did not cover every change since it", "checkpoint", checkpoint)
+		return nil
+	}
 	return s.UpdateLastRunTime()
 }
 
+// reconcile records the assets deleted since the last inventory in the report, which
+// passes them on to the storage and the notifications. A failed check is logged and does
+// not fail the run.
+func (s *Scheduler) reconcile(ctx context.Context, report *model.RunReport) *service.Reconciliation {
+	if s.reconciler == nil {
+		return nil
+	}
+	rec, err := s.reconciler.Check(ctx)
+	if err != nil {
+		s.log().ErrorContext(ctx, "Failed to check for deleted assets", "error", err)
+		report.AddEvent("reconcile failed", err.Error())
+		return nil
+	}
+	if rec == nil {
+		return nil
+	}
+	report.AddEvent("reconciled", fmt.Sprintf("%d assets, %d deleted", len(rec.Inventory.Assets), len(rec.Deleted)))
+	report.AddDeleted(rec.Deleted)
+	for _, t := range rec.Deleted {
+		s.log().InfoContext(ctx, "Asset deleted from Marketing Cloud", "asset_id", t.ID, "name", t.Name)
+	}
+	if alert := s.deletionAlertFor(len(rec.Deleted)); alert != "" {
+		s.log().WarnContext(ctx, alert)
+	}
+	return rec
+}
+
+// deletionAlertFor returns the alert for deleted assets found at once, if there are too many
+func (s *Scheduler) deletionAlertFor(deleted int) string {
+	if s.deletionAlert <= 0 || deleted <= s.deletionAlert {
+		return ""
+	}
+	return fmt.Sprintf("%d assets were deleted from Marketing Cloud since the last check, more than the alert threshold of %d. This may be an accidental mass deletion.", deleted, s.deletionAlert)
+}
+
+// writeReports stores the report of a run in its backup folder. Runs that failed before
+// choosing a folder have no report. Write failures are logged and do not fail the run.
+func (s *Scheduler) writeReports(ctx context.Context, report *model.RunReport) {
+	if report.Folder == "" {
+		return
+	}
+	for _, writer := range s.reporters {
+		if err := writer.WriteReport(ctx, report); err != nil {
+			s.log().ErrorContext(ctx, "Failed to write run report", "folder", report.Folder, "error", err)
+		}
+	}
+}
+
+// recordRun hands a finished run to the recorders. Failures are logged and do not fail
+// the run.
+func (s *Scheduler) recordRun(ctx context.Context, report *model.RunReport) {
+	for _, recorder := range s.recorders {
+		if err := recorder.RecordRun(ctx, report); err != nil {
+			s.log().ErrorContext(ctx, "Failed to record run", "error", err)
+		}
+	}
+}
+
+// notify sends the outcome of a run to the notifier. Delivery failures are logged and
+// do not fail the run.
+func (s *Scheduler) notify(ctx context.Context, result RunResult, report *model.RunReport, folder string, blocks []model.ContentBlock) {
+	if s.notifier == nil {
+		return
+	}
+	event := notify.Event{
+		RunID:        result.RunID,
+		Success:      result.Success,
+		Error:        result.Error,
+		StartedAt:    result.StartedAt,
+		FinishedAt:   result.FinishedAt,
+		Progress:     result.Progress,
+		Destinations: report.Destinations,
+		Folder:       folder,
+		Assets:       notify.AssetsOf(blocks),
+		Deleted:      notify.DeletedAssets(report.DeletedAssets()),
+	}
+	if alert := s.deletionAlertFor(len(event.Deleted)); alert != "" {
+		event.Alerts = append(event.Alerts, alert)
+	}
+	if err := s.notifier.Notify(ctx, event); err != nil {
+		s.log().ErrorContext(ctx, "Failed to send run notification", "error", err)
+	}
+}
+
 func (s *Scheduler) GetLastRunTime() (time.Time, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -102,6 +459,10 @@ func (s *Scheduler) GetLastRunTime() (time.Time, error) {
 func (s *Scheduler) UpdateLastRunTime() error {
 	s.mu.Lock() // instead of mutex as option github.com/gofrs/flock
 	defer s.mu.Unlock()
-	data := []byte(time.Now().Format(time.RFC3339))
-	return os.WriteFile(s.lastRunFile, data, 0644)
+	now := time.Now()
+	if err := os.WriteFile(s.lastRunFile, []byte(now.Format(time.RFC3339)), 0644); err != nil {
+		return err
+	}
+	metrics.SetCheckpoint(now)
+	return nil
 }
@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// SecretsManagerAPI is the subset of the Secrets Manager client used to read secrets
+type SecretsManagerAPI interface {
+	GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager. The path of a reference is the
+// secret name or ARN, and the key selects a field of a secret stored as a JSON object,
+// e.g. awssm:prod/sfmc#client_secret.
+type AWSSecretsManager struct {
+	Client SecretsManagerAPI
+}
+
+// NewAWSSecretsManager creates a Secrets Manager provider using the default AWS credential
+// chain, such as the instance or task role
+func NewAWSSecretsManager(region string) (*AWSSecretsManager, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return &AWSSecretsManager{Client: secretsmanager.New(sess)}, nil
+}
+
+// Secret reads the current version of the secret at path
+func (m *AWSSecretsManager) Secret(ctx context.Context, path, key string) (string, error) {
+	output, err := m.Client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(path),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret from Secrets Manager: %v", err)
+	}
+
+	value := aws.StringValue(output.SecretString)
+	if output.SecretString == nil {
+		value = string(output.SecretBinary)
+	}
+	if key == "" {
+		return value, nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object of strings, so it has no key %q", path, key)
+	}
+	return field(values, key)
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretsManager serves the current version of each secret
+type fakeSecretsManager map[string]*secretsmanager.GetSecretValueOutput
+
+func (f fakeSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	output, ok := f[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "Secrets Manager can't find the specified secret.", nil)
+	}
+	return output, nil
+}
+
+func TestAWSSecretsManager_Secret(t *testing.T) {
+	manager := &AWSSecretsManager{Client: fakeSecretsManager{
+		"prod/sfmc":   {SecretString: aws.String(`{"client_id":"id","client_secret":"s3cr3t"}`)},
+		"prod/token":  {SecretString: aws.String("plain-token")},
+		"prod/binary": {SecretBinary: []byte("binary-secret")},
+	}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		expected string
+		err      string
+	}{
+		{name: "JSON key", path: "prod/sfmc", key: "client_secret", expected: "s3cr3t"},
+		{name: "Plain string", path: "prod/token", expected: "plain-token"},
+		{name: "Binary", path: "prod/binary", expected: "binary-secret"},
+		{name: "Whole JSON", path: "prod/sfmc", expected: `{"client_id":"id","client_secret":"s3cr3t"}`},
+		{name: "Missing key", path: "prod/sfmc", key: "password", err: `key "password": secret not found`},
+		{name: "Key of plain string", path: "prod/token", key: "password", err: "is not a JSON object"},
+		{name: "Missing secret", path: "prod/other", err: "secret not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := manager.Secret(ctx, tt.path, tt.key)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
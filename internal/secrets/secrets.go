@@ -0,0 +1,105 @@
+// Package secrets resolves secret settings that reference a secret store instead of
+// holding the secret itself, such as vault:secret/sfmc#client_secret.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when a referenced secret or key does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Provider reads secrets from a secret store
+type Provider interface {
+	// Secret returns the secret at path. key selects a field of a secret holding several
+	// values and is empty for a secret holding a single value.
+	Secret(ctx context.Context, path, key string) (string, error)
+}
+
+// Resolver resolves references of the form <scheme>:<path>[#<key>] with the provider
+// registered for the scheme
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver creates a resolver with the file provider registered as "file"
+func NewResolver() *Resolver {
+	return &Resolver{providers: map[string]Provider{"file": File{}}}
+}
+
+// Register makes a provider resolve the references with the given scheme
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.providers[scheme] = provider
+}
+
+// IsReference reports whether value refers to a registered provider
+func (r *Resolver) IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, ":")
+	if !ok {
+		return false
+	}
+	_, ok = r.providers[scheme]
+	return ok
+}
+
+// Resolve returns the secret value refers to. Values that are not references, such as
+// the secret itself or an https URL, are returned unchanged.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !r.IsReference(value) {
+		return value, nil
+	}
+	scheme, ref, _ := strings.Cut(value, ":")
+	path, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	if path == "" {
+		return "", fmt.Errorf("secret reference %s has no path", value)
+	}
+	secret, err := r.providers[scheme].Secret(ctx, path, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+// File reads a secret from a file, such as a Docker or Kubernetes secret mounted under
+// /run/secrets. Surrounding whitespace is trimmed.
+type File struct{}
+
+// Secret reads the file at path
+func (File) Secret(ctx context.Context, path, key string) (string, error) {
+	if key != "" {
+		return "", fmt.Errorf("file secrets have no keys")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// field returns key of a secret holding several values. Without a key the secret must
+// hold a single value.
+func field(values map[string]string, key string) (string, error) {
+	if key == "" {
+		if len(values) != 1 {
+			return "", fmt.Errorf("secret holds %d values, select one with #<key>", len(values))
+		}
+		for _, value := range values {
+			return value, nil
+		}
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("key %q: %w", key, ErrNotFound)
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticProvider serves secrets from a map keyed by path#key
+type staticProvider map[string]string
+
+func (p staticProvider) Secret(ctx context.Context, path, key string) (string, error) {
+	value, ok := p[path+"#"+key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client_secret")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0600))
+
+	resolver := NewResolver()
+	resolver.Register("test", staticProvider{"sfmc#client_secret": "from-store", "plain#": "single"})
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      string
+	}{
+		{name: "Plain value", value: "s3cr3t", expected: "s3cr3t"},
+		{name: "URL", value: "https://hooks.slack.com/services/T0/B0/x", expected: "https://hooks.slack.com/services/T0/B0/x"},
+		{name: "Unregistered scheme", value: "other:sfmc#client_secret", expected: "other:sfmc#client_secret"},
+		{name: "File", value: "file:" + path, expected: "from-file"},
+		{name: "Key", value: "test:sfmc#client_secret", expected: "from-store"},
+		{name: "No key", value: "test:plain", expected: "single"},
+		{name: "Missing", value: "test:sfmc#client_id", err: "failed to resolve test:sfmc#client_id: secret not found"},
+		{name: "Missing file", value: "file:" + path + ".old", err: "secret not found"},
+		{name: "No path", value: "test:#key", err: "has no path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := resolver.Resolve(context.Background(), tt.value)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// VaultOptions configures the HashiCorp Vault KV provider
+type VaultOptions struct {
+	Addr      string       // e.g. https://vault.example.com:8200
+	Token     string       // sent in X-Vault-Token
+	Namespace string       // Vault Enterprise namespace, optional
+	KVVersion int          // version of the KV secrets engine, 2 (default) or 1
+	Client    *http.Client // defaults to a client with a 10s timeout
+}
+
+// Vault reads secrets from a Vault KV secrets engine. The path of a reference starts
+// with the mount of the engine, e.g. vault:secret/sfmc#client_secret reads the
+// client_secret key of sfmc in the engine mounted at secret.
+type Vault struct {
+	opts VaultOptions
+}
+
+// NewVault creates a Vault KV provider
+func NewVault(opts VaultOptions) (*Vault, error) {
+	u, err := url.Parse(opts.Addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Vault address %q", opts.Addr)
+	}
+	if opts.Token == "" {
+		return nil, fmt.Errorf("a Vault token is required")
+	}
+	switch opts.KVVersion {
+	case 0:
+		opts.KVVersion = 2
+	case 1, 2:
+	default:
+		return nil, fmt.Errorf("unsupported KV version %d, expected 1 or 2", opts.KVVersion)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	opts.Addr = strings.TrimRight(opts.Addr, "/")
+	return &Vault{opts: opts}, nil
+}
+
+// Secret reads a key of the secret at path
+func (v *Vault) Secret(ctx context.Context, path, key string) (string, error) {
+	mount, name, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || name == "" {
+		return "", fmt.Errorf("Vault path %q must start with the mount of the KV engine", path)
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/%s", v.opts.Addr, mount, name)
+	if v.opts.KVVersion == 2 {
+		endpoint = fmt.Sprintf("%s/v1/%s/data/%s", v.opts.Addr, mount, name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.opts.Token)
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret from Vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	default:
+		var vaultError struct {
+			Errors []string `json:"errors"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(body, &vaultError)
+		return "", fmt.Errorf("Vault responded %d: %s", resp.StatusCode, strings.Join(vaultError.Errors, "; "))
+	}
+
+	var secret struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode Vault response: %v", err)
+	}
+	data := secret.Data
+	if v.opts.KVVersion == 2 {
+		var versioned struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &versioned); err != nil {
+			return "", fmt.Errorf("failed to decode Vault response: %v", err)
+		}
+		data = versioned.Data
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("secret %s does not hold string values: %v", path, err)
+	}
+	if values == nil {
+		// KV v2 returns null data for a deleted latest version
+		return "", ErrNotFound
+	}
+	return field(values, key)
+}
@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves the KV secrets of a dev-mode Vault server, v2 under secret/ and v1
+// under kv/
+func fakeVault(t *testing.T, secrets map[string]map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		var body map[string]any
+		if name, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/"); ok && secrets[name] != nil {
+			body = map[string]any{"data": map[string]any{"data": secrets[name], "metadata": map[string]any{"version": 3}}}
+		} else if name, ok := strings.CutPrefix(r.URL.Path, "/v1/kv/"); ok && secrets[name] != nil {
+			body = map[string]any{"data": secrets[name]}
+		}
+		if body == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVault_Secret(t *testing.T) {
+	server := fakeVault(t, map[string]map[string]string{
+		"sfmc": {"client_id": "id", "client_secret": "s3cr3t"},
+		"s3":   {"secret_key": "key"},
+	})
+	ctx := context.Background()
+
+	v2, err := NewVault(VaultOptions{Addr: server.URL + "/", Token: "root"})
+	require.NoError(t, err)
+	v1, err := NewVault(VaultOptions{Addr: server.URL, Token: "root", KVVersion: 1})
+	require.NoError(t, err)
+	denied, err := NewVault(VaultOptions{Addr: server.URL, Token: "expired"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		vault    *Vault
+		path     string
+		key      string
+		expected string
+		err      string
+	}{
+		{name: "KV v2", vault: v2, path: "secret/sfmc", key: "client_secret", expected: "s3cr3t"},
+		{name: "KV v1", vault: v1, path: "kv/sfmc", key: "client_id", expected: "id"},
+		{name: "Single value", vault: v2, path: "secret/s3", expected: "key"},
+		{name: "Several values", vault: v2, path: "secret/sfmc", err: "secret holds 2 values, select one with #<key>"},
+		{name: "Missing key", vault: v2, path: "secret/sfmc", key: "password", err: `key "password": secret not found`},
+		{name: "Missing secret", vault: v2, path: "secret/other", key: "password", err: "secret not found"},
+		{name: "No mount", vault: v2, path: "sfmc", key: "client_id", err: "must start with the mount"},
+		{name: "Permission denied", vault: denied, path: "secret/sfmc", key: "client_id", err: "Vault responded 403: permission denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := tt.vault.Secret(ctx, tt.path, tt.key)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestNewVault_Options(t *testing.T) {
+	_, err := NewVault(VaultOptions{Addr: "vault:8200", Token: "root"})
+	assert.ErrorContains(t, err, "invalid Vault address")
+	_, err = NewVault(VaultOptions{Addr: "http://vault:8200"})
+	assert.ErrorContains(t, err, "a Vault token is required")
+	_, err = NewVault(VaultOptions{Addr: "http://vault:8200", Token: "root", KVVersion: 3})
+	assert.ErrorContains(t, err, "unsupported KV version 3")
+}
@@ -3,10 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Storage defines an interface for saving content blocks to a storage system
@@ -14,26 +17,68 @@ type Storage interface {
 	SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error
 }
 
+// Finalizer is implemented by storages that need to complete a folder once all of
+// its content blocks have been saved, e.g. to write a manifest
+type Finalizer interface {
+	FinalizeFolder(ctx context.Context, folder string) error
+}
+
+// Releaser is implemented by storages that keep state for a folder while a run saves it.
+// ReleaseFolder is called once the run is done with the folder, whether it was finalized
+// or the save failed.
+type Releaser interface {
+	ReleaseFolder(ctx context.Context, folder string)
+}
+
+// DefaultSaveWorkers is the number of blocks saved concurrently unless configured
+const DefaultSaveWorkers = 10
+
 type BackupService struct {
 	storage Storage
+	workers int
 }
 
 func NewBackupService(storage Storage) *BackupService {
-	return &BackupService{storage: storage}
+	return &BackupService{storage: storage, workers: DefaultSaveWorkers}
+}
+
+// SetWorkers limits the number of blocks saved concurrently
+func (s *BackupService) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = DefaultSaveWorkers
+	}
+	s.workers = workers
 }
 
-// Saving each block to storage is an independent operation using goroutines
+// Saving each block to storage is an independent operation using goroutines, at most
+// workers of them at a time
 func (s *BackupService) SaveContent(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	if r, ok := s.storage.(Releaser); ok {
+		defer r.ReleaseFolder(ctx, folder)
+	}
 	var wg sync.WaitGroup
 	errCh := make(chan error, len(blocks))
+	sem := make(chan struct{}, max(s.workers, 1))
 
 	for _, block := range blocks {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(b model.ContentBlock) {
 			defer wg.Done()
-			if err := s.storage.SaveContentBlocks(ctx, []model.ContentBlock{b}, folder); err != nil {
+			defer func() { <-sem }()
+			ctx := logging.With(ctx, "asset_id", b.ID)
+			ctx, span := tracing.Start(ctx, "storage.save", attribute.Int("asset_id", b.ID), attribute.String("folder", folder))
+			err := s.storage.SaveContentBlocks(ctx, []model.ContentBlock{b}, folder)
+			tracing.End(span, err)
+			if err != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "Error saving block", "error", err)
+				metrics.AssetsFailed.Inc()
+				model.RunReportFromContext(ctx).AddSavedBlock(b, err)
 				errCh <- fmt.Errorf("block ID %d: %v", b.ID, err)
+				return
 			}
+			metrics.AssetsSaved.Inc()
+			model.RunReportFromContext(ctx).AddSavedBlock(b, nil)
 		}(block)
 	}
 
@@ -42,11 +87,21 @@ func (s *BackupService) SaveContent(ctx context.Context, blocks []model.ContentB
 
 	var finalErr error
 	for err := range errCh {
-		log.Printf("Error saving block: %v", err)
 		if finalErr == nil {
 			finalErr = err
 		}
 	}
+	if finalErr != nil {
+		return finalErr
+	}
 
-	return finalErr
+	if f, ok := s.storage.(Finalizer); ok {
+		ctx, span := tracing.Start(ctx, "storage.finalize", attribute.String("folder", folder))
+		err := f.FinalizeFolder(ctx, folder)
+		tracing.End(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to finalize folder %s: %v", folder, err)
+		}
+	}
+	return nil
 }
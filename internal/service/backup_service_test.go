@@ -5,7 +5,9 @@ import (
 	"errors"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
 	"github.com/Feride3d/backup-creator/internal/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -21,6 +23,26 @@ func (m *MockStorage) SaveContentBlocks(ctx context.Context, blocks []model.Cont
 	return args.Error(0)
 }
 
+// runCtx matches the run context passed on to folder-level calls, which carries the
+// span of the call but no per-asset attributes
+func runCtx() interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		return len(logging.Attrs(ctx)) == 0
+	})
+}
+
+// assetCtx matches the context a block is saved with, which carries its asset ID for logging
+func assetCtx(id int) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		for _, attr := range logging.Attrs(ctx) {
+			if attr.Key == "asset_id" {
+				return attr.Value.Int64() == int64(id)
+			}
+		}
+		return false
+	})
+}
+
 func TestBackupService_SaveContentBlocks_Success(t *testing.T) {
 
 	mockStorage := new(MockStorage)
@@ -35,7 +57,7 @@ func TestBackupService_SaveContentBlocks_Success(t *testing.T) {
 
 	// Mock behavior: no errors
 	for _, block := range blocks {
-		mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{block}, folder).Return(nil).Once()
+		mockStorage.On("SaveContentBlocks", assetCtx(block.ID), []model.ContentBlock{block}, folder).Return(nil).Once()
 	}
 
 	err := backupService.SaveContent(ctx, blocks, folder)
@@ -58,9 +80,9 @@ func TestBackupService_SaveContentBlocks_PartialFailure(t *testing.T) {
 	folder := "backup_20230101"
 
 	// Mock behavior: one block fails
-	mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{blocks[0]}, folder).Return(nil).Once()
-	mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{blocks[1]}, folder).Return(errors.New("disk full")).Once()
-	mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{blocks[2]}, folder).Return(nil).Once()
+	mockStorage.On("SaveContentBlocks", assetCtx(blocks[0].ID), []model.ContentBlock{blocks[0]}, folder).Return(nil).Once()
+	mockStorage.On("SaveContentBlocks", assetCtx(blocks[1].ID), []model.ContentBlock{blocks[1]}, folder).Return(errors.New("disk full")).Once()
+	mockStorage.On("SaveContentBlocks", assetCtx(blocks[2].ID), []model.ContentBlock{blocks[2]}, folder).Return(nil).Once()
 
 	err := backupService.SaveContent(ctx, blocks, folder)
 
@@ -83,7 +105,7 @@ func TestBackupService_SaveContentBlocks_AllFailure(t *testing.T) {
 
 	// Mock behavior: all blocks fail
 	for _, block := range blocks {
-		mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{block}, folder).Return(errors.New("network error")).Once()
+		mockStorage.On("SaveContentBlocks", assetCtx(block.ID), []model.ContentBlock{block}, folder).Return(errors.New("network error")).Once()
 	}
 
 	err := backupService.SaveContent(ctx, blocks, folder)
@@ -112,7 +134,7 @@ func TestBackupService_SaveContentBlocks_Concurrency(t *testing.T) {
 	// Mock behavior with concurrency handling
 	for _, block := range blocks {
 		blockCopy := block
-		mockStorage.On("SaveContentBlocks", ctx, []model.ContentBlock{blockCopy}, folder).
+		mockStorage.On("SaveContentBlocks", assetCtx(blockCopy.ID), []model.ContentBlock{blockCopy}, folder).
 			Run(func(args mock.Arguments) {
 				defer wg.Done()
 			}).Return(nil).Once()
@@ -126,3 +148,72 @@ func TestBackupService_SaveContentBlocks_Concurrency(t *testing.T) {
 	wg.Wait()
 	mockStorage.AssertExpectations(t)
 }
+
+// countingStorage records the largest number of concurrent saves
+type countingStorage struct {
+	mu      sync.Mutex
+	current int
+	peak    int
+}
+
+func (s *countingStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	s.mu.Lock()
+	s.current++
+	s.peak = max(s.peak, s.current)
+	s.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	return nil
+}
+
+func TestBackupService_SaveContentBlocks_Workers(t *testing.T) {
+	storage := &countingStorage{}
+	backupService := NewBackupService(storage)
+	backupService.SetWorkers(2)
+
+	blocks := make([]model.ContentBlock, 10)
+	for i := range blocks {
+		blocks[i] = model.ContentBlock{ID: i + 1}
+	}
+	assert.NoError(t, backupService.SaveContent(context.Background(), blocks, "backup_20230101"))
+	assert.Equal(t, 2, storage.peak)
+}
+
+// MockFinalizingStorage is a mock storage that also implements Finalizer
+type MockFinalizingStorage struct {
+	MockStorage
+}
+
+func (m *MockFinalizingStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	args := m.Called(ctx, folder)
+	return args.Error(0)
+}
+
+func TestBackupService_SaveContentBlocks_Finalize(t *testing.T) {
+	ctx := context.Background()
+	folder := "backup_20230101"
+	block := model.ContentBlock{ID: 1, Name: "Block 1", Content: "Content 1"}
+
+	t.Run("Finalizes folder after success", func(t *testing.T) {
+		mockStorage := new(MockFinalizingStorage)
+		mockStorage.On("SaveContentBlocks", assetCtx(block.ID), []model.ContentBlock{block}, folder).Return(nil).Once()
+		mockStorage.On("FinalizeFolder", runCtx(), folder).Return(nil).Once()
+
+		err := NewBackupService(mockStorage).SaveContent(ctx, []model.ContentBlock{block}, folder)
+
+		assert.NoError(t, err)
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("Skips finalize after failure", func(t *testing.T) {
+		mockStorage := new(MockFinalizingStorage)
+		mockStorage.On("SaveContentBlocks", assetCtx(block.ID), []model.ContentBlock{block}, folder).Return(errors.New("disk full")).Once()
+
+		err := NewBackupService(mockStorage).SaveContent(ctx, []model.ContentBlock{block}, folder)
+
+		assert.Error(t, err)
+		mockStorage.AssertNotCalled(t, "FinalizeFolder", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Data extension row formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// DataExtensionReader reads the data extensions to back up, their schema and their rows
+// page by page
+type DataExtensionReader interface {
+	DataExtensions(ctx context.Context, keys, folderIDs []string) ([]model.DataExtension, error)
+	DataExtensionFields(ctx context.Context, key string) ([]model.DataExtensionField, error)
+	DataExtensionRows(ctx context.Context, key string, fn func(rows []map[string]string) error) (int, error)
+}
+
+// DataExtensionStorage writes data extensions to a backup folder. SaveDataExtension
+// stores the schema and the rows written by writeRows, which returns the number of rows.
+type DataExtensionStorage interface {
+	SaveDataExtension(ctx context.Context, folder string, de model.DataExtension, format string, writeRows func(w io.Writer) (int, error)) (model.DataExtensionEntry, error)
+	SaveDataExtensionManifest(ctx context.Context, manifest model.DataExtensionManifest) error
+}
+
+// DataExtensionService backs up the schema and rows of the data extensions with the
+// configured customer keys and of those in the configured folders
+type DataExtensionService struct {
+	reader  DataExtensionReader
+	storage DataExtensionStorage
+	Keys    []string
+	Folders []string
+	// Format is FormatCSV or FormatJSONL
+	Format string
+}
+
+func NewDataExtensionService(reader DataExtensionReader, storage DataExtensionStorage) *DataExtensionService {
+	return &DataExtensionService{reader: reader, storage: storage, Format: FormatCSV}
+}
+
+// Backup saves every data extension to folder, streaming the rows into the storage as
+// they are fetched, then writes the manifest. A data extension that fails or whose row
+// count differs from the one reported by Marketing Cloud is an error once the others are
+// saved.
+func (s *DataExtensionService) Backup(ctx context.Context, folder string) (*model.DataExtensionManifest, error) {
+	dataExtensions, err := s.reader.DataExtensions(ctx, s.Keys, s.Folders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data extensions: %w", err)
+	}
+
+	manifest := &model.DataExtensionManifest{Folder: folder, CreatedAt: time.Now().UTC(), Entries: []model.DataExtensionEntry{}}
+	var errs []error
+	for _, de := range dataExtensions {
+		ctx := logging.With(ctx, "data_extension", de.CustomerKey)
+		ctx, span := tracing.Start(ctx, "data_extension.save", attribute.String("key", de.CustomerKey), attribute.String("folder", folder))
+		entry, err := s.save(ctx, folder, de)
+		tracing.End(span, err)
+		if err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "Error saving data extension", "error", err)
+			errs = append(errs, fmt.Errorf("data extension %s: %v", de.CustomerKey, err))
+			continue
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		if !entry.RowCountMatches() {
+			logging.FromContext(ctx).WarnContext(ctx, "Row count mismatch", "rows", entry.Rows, "expected", entry.ExpectedRows)
+			errs = append(errs, fmt.Errorf("data extension %s: wrote %d rows, expected %d", de.CustomerKey, entry.Rows, entry.ExpectedRows))
+			continue
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "Saved data extension", "rows", entry.Rows)
+	}
+
+	if err := s.storage.SaveDataExtensionManifest(ctx, *manifest); err != nil {
+		errs = append(errs, fmt.Errorf("failed to write data extension manifest: %w", err))
+	}
+	return manifest, errors.Join(errs...)
+}
+
+// save writes the schema and rows of a data extension
+func (s *DataExtensionService) save(ctx context.Context, folder string, de model.DataExtension) (model.DataExtensionEntry, error) {
+	fields, err := s.reader.DataExtensionFields(ctx, de.CustomerKey)
+	if err != nil {
+		return model.DataExtensionEntry{}, fmt.Errorf("failed to read schema: %w", err)
+	}
+	de.Fields = fields
+
+	var expected int
+	entry, err := s.storage.SaveDataExtension(ctx, folder, de, s.Format, func(w io.Writer) (int, error) {
+		encoder, err := newRowEncoder(s.Format, fields, w)
+		if err != nil {
+			return 0, err
+		}
+		var written int
+		expected, err = s.reader.DataExtensionRows(ctx, de.CustomerKey, func(rows []map[string]string) error {
+			for _, row := range rows {
+				if err := encoder.Write(row); err != nil {
+					return err
+				}
+				written++
+			}
+			return nil
+		})
+		if err != nil {
+			return written, err
+		}
+		return written, encoder.Flush()
+	})
+	if err != nil {
+		return model.DataExtensionEntry{}, err
+	}
+	entry.ExpectedRows = expected
+	return entry, nil
+}
+
+// rowEncoder writes rows, keyed by lowercased field name, in the column order of the schema
+type rowEncoder interface {
+	Write(row map[string]string) error
+	Flush() error
+}
+
+func newRowEncoder(format string, fields []model.DataExtensionField, w io.Writer) (rowEncoder, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVRowEncoder(fields, w)
+	case FormatJSONL:
+		return &jsonlRowEncoder{fields: fields, encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown data extension format %q", format)
+	}
+}
+
+// csvRowEncoder writes a header with the field names, then one line per row
+type csvRowEncoder struct {
+	fields []model.DataExtensionField
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVRowEncoder(fields []model.DataExtensionField, w io.Writer) (*csvRowEncoder, error) {
+	e := &csvRowEncoder{fields: fields, writer: csv.NewWriter(w), record: make([]string, len(fields))}
+	for i, f := range fields {
+		e.record[i] = f.Name
+	}
+	return e, e.writer.Write(e.record)
+}
+
+func (e *csvRowEncoder) Write(row map[string]string) error {
+	for i, f := range e.fields {
+		e.record[i] = row[strings.ToLower(f.Name)]
+	}
+	return e.writer.Write(e.record)
+}
+
+func (e *csvRowEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// jsonlRowEncoder writes each row as a JSON object keyed by field name, leaving out
+// fields without a value
+type jsonlRowEncoder struct {
+	fields  []model.DataExtensionField
+	encoder *json.Encoder
+}
+
+func (e *jsonlRowEncoder) Write(row map[string]string) error {
+	object := make(map[string]string, len(e.fields))
+	for _, f := range e.fields {
+		if value, ok := row[strings.ToLower(f.Name)]; ok {
+			object[f.Name] = value
+		}
+	}
+	return e.encoder.Encode(object)
+}
+
+func (e *jsonlRowEncoder) Flush() error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDataExtensionReader struct {
+	dataExtensions []model.DataExtension
+	fields         map[string][]model.DataExtensionField
+	pages          map[string][][]map[string]string
+	// counts overrides the row count reported for a key
+	counts map[string]int
+}
+
+func (f *fakeDataExtensionReader) DataExtensions(ctx context.Context, keys, folderIDs []string) ([]model.DataExtension, error) {
+	return f.dataExtensions, nil
+}
+
+func (f *fakeDataExtensionReader) DataExtensionFields(ctx context.Context, key string) ([]model.DataExtensionField, error) {
+	fields, ok := f.fields[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown data extension")
+	}
+	return fields, nil
+}
+
+func (f *fakeDataExtensionReader) DataExtensionRows(ctx context.Context, key string, fn func(rows []map[string]string) error) (int, error) {
+	count := 0
+	for _, page := range f.pages[key] {
+		count += len(page)
+		if err := fn(page); err != nil {
+			return 0, err
+		}
+	}
+	if c, ok := f.counts[key]; ok {
+		count = c
+	}
+	return count, nil
+}
+
+type memoryDataExtensionStorage struct {
+	files    map[string]string
+	manifest *model.DataExtensionManifest
+}
+
+func (m *memoryDataExtensionStorage) SaveDataExtension(ctx context.Context, folder string, de model.DataExtension, format string, writeRows func(w io.Writer) (int, error)) (model.DataExtensionEntry, error) {
+	var buf bytes.Buffer
+	rows, err := writeRows(&buf)
+	if err != nil {
+		return model.DataExtensionEntry{}, err
+	}
+	object := folder + "/" + de.CustomerKey + "." + format
+	m.files[object] = buf.String()
+	return model.DataExtensionEntry{CustomerKey: de.CustomerKey, Name: de.Name, Object: object, Format: format, Rows: rows}, nil
+}
+
+func (m *memoryDataExtensionStorage) SaveDataExtensionManifest(ctx context.Context, manifest model.DataExtensionManifest) error {
+	m.manifest = &manifest
+	return nil
+}
+
+func TestDataExtensionService_Backup(t *testing.T) {
+	reader := &fakeDataExtensionReader{
+		dataExtensions: []model.DataExtension{{CustomerKey: "broken"}, {CustomerKey: "regions", Name: "Regions"}, {CustomerKey: "stores"}},
+		fields: map[string][]model.DataExtensionField{
+			"regions": {{Name: "Code", IsPrimaryKey: true}, {Name: "Label"}},
+			"stores":  {{Name: "ID"}},
+		},
+		pages: map[string][][]map[string]string{
+			"regions": {{{"code": "FR", "label": "France"}, {"code": "DE"}}, {{"code": "IT", "label": `Italy, "IT"`}}},
+			"stores":  {{{"id": "1"}}},
+		},
+		counts: map[string]int{"stores": 2},
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: FormatCSV, expected: "Code,Label\nFR,France\nDE,\nIT,\"Italy, \"\"IT\"\"\"\n"},
+		{format: FormatJSONL, expected: `{"Code":"FR","Label":"France"}` + "\n" + `{"Code":"DE"}` + "\n" + `{"Code":"IT","Label":"Italy, \"IT\""}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			storage := &memoryDataExtensionStorage{files: map[string]string{}}
+			service := NewDataExtensionService(reader, storage)
+			service.Keys = []string{"regions"}
+			service.Format = tt.format
+
+			manifest, err := service.Backup(context.Background(), "backup_20241121")
+			assert.EqualError(t, err, "data extension broken: failed to read schema: unknown data extension\n"+
+				"data extension stores: wrote 1 rows, expected 2")
+			assert.Equal(t, tt.expected, storage.files["backup_20241121/regions."+tt.format])
+
+			require.NotNil(t, storage.manifest)
+			assert.Equal(t, manifest, storage.manifest)
+			assert.Equal(t, "backup_20241121", manifest.Folder)
+			require.Len(t, manifest.Entries, 2)
+			assert.Equal(t, 3, manifest.Entries[0].Rows)
+			assert.True(t, manifest.Entries[0].RowCountMatches())
+			assert.False(t, manifest.Entries[1].RowCountMatches())
+		})
+	}
+}
@@ -2,9 +2,14 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ContentProvider interface {
@@ -12,16 +17,116 @@ type ContentProvider interface {
 	FetchPage(ctx context.Context, query map[string]interface{}, page, pageSize int) ([]model.ContentBlock, error)
 }
 
+// FullContentProvider is implemented by providers that can fetch every asset, which a
+// full snapshot needs
+type FullContentProvider interface {
+	GetAllContentBlocks(ctx context.Context, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error)
+}
+
+// CategoryProvider is implemented by providers that can list Content Builder categories
+type CategoryProvider interface {
+	GetCategories(ctx context.Context) ([]model.Category, error)
+}
+
+// DefaultFetchWorkers is the number of pages fetched concurrently unless configured
+const DefaultFetchWorkers = 5
+
+type assetTypesKey struct{}
+
+// WithAssetTypes returns a copy of ctx limiting the blocks fetched to the given asset
+// types, e.g. for an ad-hoc run of a single type
+func WithAssetTypes(ctx context.Context, assetTypes []string) context.Context {
+	return context.WithValue(ctx, assetTypesKey{}, assetTypes)
+}
+
+// AssetTypesFromContext returns the asset types the fetch is limited to, or nil for all
+func AssetTypesFromContext(ctx context.Context) []string {
+	assetTypes, _ := ctx.Value(assetTypesKey{}).([]string)
+	return assetTypes
+}
+
 type FetchService struct {
 	Provider ContentProvider
+	Workers  int // pages fetched concurrently
 }
 
 func NewFetchService(Provider ContentProvider) *FetchService {
-	return &FetchService{Provider: Provider}
+	return &FetchService{Provider: Provider, Workers: DefaultFetchWorkers}
 }
 
-func (s *FetchService) GetUpdatedContentBlocks(ctx context.Context, lastRun time.Time) ([]model.ContentBlock, error) {
+func (s *FetchService) GetUpdatedContentBlocks(ctx context.Context, lastRun time.Time) (blocks []model.ContentBlock, err error) {
+	ctx, span := tracing.Start(ctx, "fetch.updated_blocks", attribute.String("since", lastRun.UTC().Format(time.RFC3339)))
+	defer func() {
+		span.SetAttributes(attribute.Int("blocks", len(blocks)))
+		tracing.End(span, err)
+	}()
+
 	query := make(map[string]interface{})
-	workerCount := 5
-	return s.Provider.GetUpdatedContentBlocksConcurrent(ctx, lastRun, workerCount, query)
+	if assetTypes := AssetTypesFromContext(ctx); len(assetTypes) > 0 {
+		query["query"] = map[string]interface{}{
+			"property":       "assetType.name",
+			"simpleOperator": "in",
+			"value":          assetTypes,
+		}
+	}
+	workerCount := s.Workers
+	if workerCount < 1 {
+		workerCount = DefaultFetchWorkers
+	}
+	if model.BackupTypeFromContext(ctx) == model.BackupFull {
+		provider, ok := s.Provider.(FullContentProvider)
+		if !ok {
+			return nil, fmt.Errorf("content provider cannot fetch every asset for a full snapshot")
+		}
+		blocks, err = provider.GetAllContentBlocks(ctx, workerCount, query)
+	} else {
+		blocks, err = s.Provider.GetUpdatedContentBlocksConcurrent(ctx, lastRun, workerCount, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolveCategoryPaths(ctx, blocks); err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "Fetched updated content blocks", "count", len(blocks))
+	metrics.AssetsFetched.Add(float64(len(blocks)))
+	model.RunReportFromContext(ctx).AddFetchedBlocks(blocks)
+	return blocks, nil
+}
+
+// resolveCategoryPaths fills in the category path of each block so storages can mirror
+// the Content Builder folder tree
+func (s *FetchService) resolveCategoryPaths(ctx context.Context, blocks []model.ContentBlock) error {
+	provider, ok := s.Provider.(CategoryProvider)
+	if !ok || len(blocks) == 0 {
+		return nil
+	}
+	categories, err := provider.GetCategories(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch categories: %w", err)
+	}
+
+	byID := make(map[int]model.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+	for i := range blocks {
+		category := blocks[i].Category
+		if category == nil {
+			continue
+		}
+		var path []string
+		seen := make(map[int]bool)
+		for id := category.ID; id != 0 && !seen[id]; {
+			seen[id] = true
+			c, ok := byID[id]
+			if !ok {
+				break
+			}
+			path = append([]string{c.Name}, path...)
+			id = c.ParentID
+		}
+		category.Path = path
+	}
+	return nil
 }
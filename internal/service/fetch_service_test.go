@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockContentProvider is a mock implementation of the ContentProvider and CategoryProvider interfaces
+type MockContentProvider struct {
+	mock.Mock
+}
+
+func (m *MockContentProvider) GetUpdatedContentBlocksConcurrent(ctx context.Context, lastRun time.Time, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error) {
+	args := m.Called(ctx, lastRun, workerCount, query)
+	return args.Get(0).([]model.ContentBlock), args.Error(1)
+}
+
+func (m *MockContentProvider) FetchPage(ctx context.Context, query map[string]interface{}, page, pageSize int) ([]model.ContentBlock, error) {
+	args := m.Called(ctx, query, page, pageSize)
+	return args.Get(0).([]model.ContentBlock), args.Error(1)
+}
+
+func (m *MockContentProvider) GetAllContentBlocks(ctx context.Context, workerCount int, query map[string]interface{}) ([]model.ContentBlock, error) {
+	args := m.Called(ctx, workerCount, query)
+	return args.Get(0).([]model.ContentBlock), args.Error(1)
+}
+
+func (m *MockContentProvider) GetCategories(ctx context.Context) ([]model.Category, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]model.Category), args.Error(1)
+}
+
+func TestFetchService_GetUpdatedContentBlocks_ResolvesCategoryPaths(t *testing.T) {
+	ctx := context.Background()
+	lastRun := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+
+	provider := new(MockContentProvider)
+	provider.On("GetUpdatedContentBlocksConcurrent", runCtx(), lastRun, 5, mock.Anything).Return([]model.ContentBlock{
+		{ID: 1, Category: &model.Category{ID: 3}},
+		{ID: 2},
+	}, nil)
+	provider.On("GetCategories", runCtx()).Return([]model.Category{
+		{ID: 1, Name: "Content Builder"},
+		{ID: 2, Name: "Emails", ParentID: 1},
+		{ID: 3, Name: "Newsletters", ParentID: 2},
+	}, nil)
+
+	blocks, err := NewFetchService(provider).GetUpdatedContentBlocks(ctx, lastRun)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Content Builder", "Emails", "Newsletters"}, blocks[0].Category.Path)
+	assert.Nil(t, blocks[1].Category)
+	provider.AssertExpectations(t)
+}
+
+func TestFetchService_GetUpdatedContentBlocks_AssetTypes(t *testing.T) {
+	lastRun := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	filtered := map[string]interface{}{
+		"query": map[string]interface{}{
+			"property":       "assetType.name",
+			"simpleOperator": "in",
+			"value":          []string{"htmlemail"},
+		},
+	}
+
+	provider := new(MockContentProvider)
+	provider.On("GetUpdatedContentBlocksConcurrent", mock.Anything, lastRun, 3, filtered).Return([]model.ContentBlock{{ID: 1}}, nil)
+	provider.On("GetCategories", mock.Anything).Return([]model.Category{}, nil)
+
+	fetchService := NewFetchService(provider)
+	fetchService.Workers = 3
+	blocks, err := fetchService.GetUpdatedContentBlocks(WithAssetTypes(context.Background(), []string{"htmlemail"}), lastRun)
+
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+	provider.AssertExpectations(t)
+}
+
+func TestFetchService_GetUpdatedContentBlocks_Full(t *testing.T) {
+	ctx := model.WithBackupType(context.Background(), model.BackupFull)
+
+	provider := new(MockContentProvider)
+	provider.On("GetAllContentBlocks", mock.Anything, 5, map[string]interface{}{}).Return([]model.ContentBlock{{ID: 1}, {ID: 2}}, nil)
+	provider.On("GetCategories", mock.Anything).Return([]model.Category{}, nil)
+
+	blocks, err := NewFetchService(provider).GetUpdatedContentBlocks(ctx, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+	provider.AssertExpectations(t)
+	provider.AssertNotCalled(t, "GetUpdatedContentBlocksConcurrent", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// a provider limited to the recent pages must not pass for a full snapshot
+	limited := struct{ ContentProvider }{provider}
+	_, err = NewFetchService(limited).GetUpdatedContentBlocks(ctx, time.Time{})
+	assert.EqualError(t, err, "content provider cannot fetch every asset for a full snapshot")
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// AssetLister lists every asset in Marketing Cloud with minimal fields
+type AssetLister interface {
+	ListAssets(ctx context.Context) ([]model.AssetRef, error)
+}
+
+// Inventory is the list of assets seen by the last reconciliation
+type Inventory struct {
+	CheckedAt time.Time        `json:"checkedAt"`
+	Assets    []model.AssetRef `json:"assets"`
+}
+
+// Reconciliation is the outcome of comparing the assets in Marketing Cloud with the last
+// inventory
+type Reconciliation struct {
+	Inventory Inventory
+	// Deleted are the assets of the last inventory that no longer exist, none on the first check
+	Deleted []model.Tombstone
+}
+
+// ReconcileService detects deleted assets, which an incremental query by modified date
+// never returns, by comparing the asset IDs with the inventory kept in a file
+type ReconcileService struct {
+	lister AssetLister
+	path   string
+	// Interval is the minimum time between two checks, 0 checks every time
+	Interval time.Duration
+}
+
+func NewReconcileService(lister AssetLister, inventoryFile string) *ReconcileService {
+	return &ReconcileService{lister: lister, path: inventoryFile}
+}
+
+// Check lists the assets and compares them with the last inventory. It returns nil while
+// the inventory is younger than Interval. The new inventory is only saved by Commit.
+func (s *ReconcileService) Check(ctx context.Context) (*Reconciliation, error) {
+	previous, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if previous != nil && now.Sub(previous.CheckedAt) < s.Interval {
+		return nil, nil
+	}
+
+	assets, err := s.lister.ListAssets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assets: %w", err)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].ID < assets[j].ID
+	})
+	rec := &Reconciliation{Inventory: Inventory{CheckedAt: now, Assets: assets}}
+	if previous == nil {
+		logging.FromContext(ctx).InfoContext(ctx, "Recorded the first asset inventory", "assets", len(assets))
+		return rec, nil
+	}
+
+	current := make(map[int]bool, len(assets))
+	for _, a := range assets {
+		current[a.ID] = true
+	}
+	for _, a := range previous.Assets {
+		if !current[a.ID] {
+			rec.Deleted = append(rec.Deleted, model.Tombstone{AssetRef: a, DetectedAt: now})
+		}
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "Reconciled asset inventory", "assets", len(assets), "deleted", len(rec.Deleted))
+	return rec, nil
+}
+
+// Commit saves the inventory of a reconciliation once its deletions are recorded, so that
+// a run failing before that detects them again
+func (s *ReconcileService) Commit(rec *Reconciliation) error {
+	if rec == nil {
+		return nil
+	}
+	data, err := json.Marshal(rec.Inventory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %v", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write inventory file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write inventory file: %v", err)
+	}
+	return nil
+}
+
+// load reads the last inventory, nil if there is none yet
+func (s *ReconcileService) load() (*Inventory, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %v", err)
+	}
+	var inventory Inventory
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return nil, fmt.Errorf("failed to decode inventory file %s: %v", s.path, err)
+	}
+	return &inventory, nil
+}
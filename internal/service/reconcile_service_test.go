@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAssetLister []model.AssetRef
+
+func (f *fakeAssetLister) ListAssets(ctx context.Context) ([]model.AssetRef, error) {
+	return append([]model.AssetRef(nil), *f...), nil
+}
+
+func TestReconcileService_Check(t *testing.T) {
+	ctx := context.Background()
+	lister := &fakeAssetLister{{ID: 3, Name: "Header"}, {ID: 1, Name: "Welcome"}, {ID: 2, Name: "Footer", CustomerKey: "footer"}}
+	reconciler := NewReconcileService(lister, filepath.Join(t.TempDir(), "inventory.json"))
+
+	// the first check only takes the inventory
+	rec, err := reconciler.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, rec.Deleted)
+	assert.Equal(t, []int{1, 2, 3}, assetIDs(rec.Inventory.Assets))
+	require.NoError(t, reconciler.Commit(rec))
+
+	*lister = fakeAssetLister{{ID: 1, Name: "Welcome"}, {ID: 4, Name: "New"}}
+	rec, err = reconciler.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, rec.Deleted, 2)
+	assert.Equal(t, model.AssetRef{ID: 2, Name: "Footer", CustomerKey: "footer"}, rec.Deleted[0].AssetRef)
+	assert.Equal(t, 3, rec.Deleted[1].ID)
+	assert.WithinDuration(t, time.Now(), rec.Deleted[0].DetectedAt, time.Minute)
+
+	// without a commit the deletions are detected again
+	rec, err = reconciler.Check(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rec.Deleted, 2)
+	require.NoError(t, reconciler.Commit(rec))
+
+	rec, err = reconciler.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, rec.Deleted)
+
+	reconciler.Interval = time.Hour
+	rec, err = reconciler.Check(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, rec)
+}
+
+func assetIDs(assets []model.AssetRef) []int {
+	ids := make([]int, 0, len(assets))
+	for _, a := range assets {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Feride3d/backup-creator/internal/client"
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// AssetWriter reads and writes assets in Marketing Cloud
+type AssetWriter interface {
+	GetAsset(ctx context.Context, id int) (model.ContentBlock, error)
+	UpdateAsset(ctx context.Context, block model.ContentBlock) error
+	CreateAsset(ctx context.Context, block model.ContentBlock) (model.ContentBlock, error)
+}
+
+// Restore actions
+const (
+	RestoreUpdate = "update"
+	RestoreCreate = "create"
+	RestoreSkip   = "skip"
+)
+
+// RestoreResult is the outcome of restoring one asset
+type RestoreResult struct {
+	AssetID int    `json:"assetId"`
+	Name    string `json:"name,omitempty"`
+	Action  string `json:"action"`
+	NewID   int    `json:"newId,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RestoreService writes backed up assets back to Marketing Cloud
+type RestoreService struct {
+	writer AssetWriter
+}
+
+func NewRestoreService(writer AssetWriter) *RestoreService {
+	return &RestoreService{writer: writer}
+}
+
+// Restore updates each asset that still exists to its backed up version and recreates
+// the deleted ones, which get a new ID. Assets that already match their backup are
+// skipped. With dryRun it only reports what it would do.
+func (s *RestoreService) Restore(ctx context.Context, blocks []model.ContentBlock, dryRun bool) ([]RestoreResult, error) {
+	plan, err := s.Plan(ctx, blocks)
+	if dryRun || ctx.Err() != nil {
+		return plan, err
+	}
+	return s.Apply(ctx, blocks, plan)
+}
+
+// Plan compares each block with the live asset and decides whether to create, update or
+// skip it. Assets that could not be read are reported with an error.
+func (s *RestoreService) Plan(ctx context.Context, blocks []model.ContentBlock) ([]RestoreResult, error) {
+	plan := make([]RestoreResult, 0, len(blocks))
+	failed := 0
+	for _, block := range blocks {
+		ctx := logging.With(ctx, "asset_id", block.ID)
+		result := RestoreResult{AssetID: block.ID, Name: block.Name, Action: RestoreUpdate}
+		live, err := s.writer.GetAsset(ctx, block.ID)
+		switch {
+		case errors.Is(err, client.ErrAssetNotFound):
+			result.Action = RestoreCreate
+		case err != nil:
+			failed++
+			result.Error = err.Error()
+			logging.FromContext(ctx).ErrorContext(ctx, "Failed to read asset", "error", err)
+		case sameAsset(live, block):
+			result.Action = RestoreSkip
+		}
+		plan = append(plan, result)
+		if ctx.Err() != nil {
+			return plan, ctx.Err()
+		}
+	}
+	if failed > 0 {
+		return plan, fmt.Errorf("failed to read %d of %d assets", failed, len(blocks))
+	}
+	return plan, nil
+}
+
+// Apply executes a plan made by Plan for the same blocks, one asset at a time to stay
+// within the API rate limits. Skipped assets and those that failed planning are left as
+// they are.
+func (s *RestoreService) Apply(ctx context.Context, blocks []model.ContentBlock, plan []RestoreResult) ([]RestoreResult, error) {
+	if len(plan) != len(blocks) {
+		return nil, fmt.Errorf("plan has %d assets, expected %d", len(plan), len(blocks))
+	}
+	results := make([]RestoreResult, 0, len(plan))
+	failed := 0
+	for i, result := range plan {
+		if result.Error != "" {
+			failed++
+		}
+		if result.Error != "" || result.Action == RestoreSkip {
+			results = append(results, result)
+			continue
+		}
+		ctx := logging.With(ctx, "asset_id", result.AssetID)
+		if err := s.apply(ctx, blocks[i], &result); err != nil {
+			failed++
+			result.Error = err.Error()
+			logging.FromContext(ctx).ErrorContext(ctx, "Failed to restore asset", "error", err)
+		} else {
+			logging.FromContext(ctx).InfoContext(ctx, "Restored asset", "action", result.Action, "new_id", result.NewID)
+		}
+		results = append(results, result)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d assets failed to restore", failed, len(plan))
+	}
+	return results, nil
+}
+
+func (s *RestoreService) apply(ctx context.Context, block model.ContentBlock, result *RestoreResult) error {
+	if result.Action == RestoreUpdate {
+		return s.writer.UpdateAsset(ctx, block)
+	}
+	created, err := s.writer.CreateAsset(ctx, block)
+	if err != nil {
+		return err
+	}
+	result.NewID = created.ID
+	return nil
+}
+
+// restorable is the part of an asset a restore writes back
+type restorable struct {
+	CustomerKey string                 `json:"customerKey"`
+	Name        string                 `json:"name"`
+	CategoryID  int                    `json:"categoryId"`
+	Content     interface{}            `json:"content"`
+	Views       map[string]interface{} `json:"views"`
+}
+
+// sameAsset reports whether the live asset already matches the backed up block. Both are
+// compared as JSON since their content is decoded from it.
+func sameAsset(live, block model.ContentBlock) bool {
+	encode := func(b model.ContentBlock) []byte {
+		r := restorable{CustomerKey: b.CustomerKey, Name: b.Name, Content: b.Content, Views: b.Views}
+		if b.Category != nil {
+			r.CategoryID = b.Category.ID
+		}
+		data, _ := json.Marshal(r)
+		return data
+	}
+	return bytes.Equal(encode(live), encode(block))
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/client"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAssetWriter holds the live assets by ID and fails writes of assets named "broken"
+type fakeAssetWriter struct {
+	assets map[int]model.ContentBlock
+	nextID int
+}
+
+func (f *fakeAssetWriter) GetAsset(ctx context.Context, id int) (model.ContentBlock, error) {
+	block, ok := f.assets[id]
+	if !ok {
+		return model.ContentBlock{}, fmt.Errorf("asset %d: %w", id, client.ErrAssetNotFound)
+	}
+	return block, nil
+}
+
+func (f *fakeAssetWriter) UpdateAsset(ctx context.Context, block model.ContentBlock) error {
+	if block.Name == "broken" {
+		return errors.New("invalid asset")
+	}
+	f.assets[block.ID] = block
+	return nil
+}
+
+func (f *fakeAssetWriter) CreateAsset(ctx context.Context, block model.ContentBlock) (model.ContentBlock, error) {
+	f.nextID++
+	block.ID = f.nextID
+	f.assets[block.ID] = block
+	return block, nil
+}
+
+func TestRestoreService_Restore(t *testing.T) {
+	blocks := []model.ContentBlock{
+		{ID: 1, Name: "Welcome", Content: "v1"},
+		{ID: 2, Name: "Deleted", Content: "v1"},
+		{ID: 3, Name: "broken"},
+		{ID: 4, Name: "Unchanged", Content: "v1", Category: &model.Category{ID: 9, Path: []string{"Content Builder"}}},
+	}
+	newWriter := func() *fakeAssetWriter {
+		return &fakeAssetWriter{
+			assets: map[int]model.ContentBlock{
+				1: {ID: 1, Name: "Welcome", Content: "v2"},
+				3: {ID: 3, Name: "Footer"},
+				4: {ID: 4, Name: "Unchanged", Content: "v1", Category: &model.Category{ID: 9}},
+			},
+			nextID: 100,
+		}
+	}
+
+	t.Run("Dry run", func(t *testing.T) {
+		writer := newWriter()
+		results, err := NewRestoreService(writer).Restore(context.Background(), blocks, true)
+		require.NoError(t, err)
+		assert.Equal(t, []RestoreResult{
+			{AssetID: 1, Name: "Welcome", Action: RestoreUpdate},
+			{AssetID: 2, Name: "Deleted", Action: RestoreCreate},
+			{AssetID: 3, Name: "broken", Action: RestoreUpdate},
+			{AssetID: 4, Name: "Unchanged", Action: RestoreSkip},
+		}, results)
+		assert.Equal(t, "v2", writer.assets[1].Content)
+		assert.Len(t, writer.assets, 3)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		writer := newWriter()
+		results, err := NewRestoreService(writer).Restore(context.Background(), blocks, false)
+		assert.EqualError(t, err, "1 of 4 assets failed to restore")
+		assert.Equal(t, []RestoreResult{
+			{AssetID: 1, Name: "Welcome", Action: RestoreUpdate},
+			{AssetID: 2, Name: "Deleted", Action: RestoreCreate, NewID: 101},
+			{AssetID: 3, Name: "broken", Action: RestoreUpdate, Error: "invalid asset"},
+			{AssetID: 4, Name: "Unchanged", Action: RestoreSkip},
+		}, results)
+		assert.Equal(t, "v1", writer.assets[1].Content)
+		assert.Equal(t, "Deleted", writer.assets[101].Name)
+	})
+}
+
+func TestRestoreService_Apply(t *testing.T) {
+	ctx := context.Background()
+	writer := &fakeAssetWriter{assets: map[int]model.ContentBlock{1: {ID: 1, Name: "Welcome", Content: "v2"}}}
+	service := NewRestoreService(writer)
+	blocks := []model.ContentBlock{
+		{ID: 1, Name: "Welcome", Content: "v1"},
+		{ID: 2, Name: "Deleted", Content: "v1"},
+	}
+
+	plan, err := service.Plan(ctx, blocks)
+	require.NoError(t, err)
+	// an asset that failed planning is not written
+	plan[1].Error = "timeout"
+	results, err := service.Apply(ctx, blocks, plan)
+	assert.EqualError(t, err, "1 of 2 assets failed to restore")
+	assert.Equal(t, []RestoreResult{
+		{AssetID: 1, Name: "Welcome", Action: RestoreUpdate},
+		{AssetID: 2, Name: "Deleted", Action: RestoreCreate, Error: "timeout"},
+	}, results)
+	assert.Equal(t, "v1", writer.assets[1].Content)
+	assert.Len(t, writer.assets, 1)
+
+	_, err = service.Apply(ctx, blocks[:1], plan)
+	assert.EqualError(t, err, "plan has 2 assets, expected 1")
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file in the target directory, syncs it to
+// disk and renames it over path, so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	_, err := copyFileAtomic(path, bytes.NewReader(data), perm)
+	return err
+}
+
+// copyFileAtomic is writeFileAtomic for data read from r, it returns the bytes written
+func copyFileAtomic(path string, r io.Reader, perm os.FileMode) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return n, err
+	}
+	return n, syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory entry change such as a rename to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+const (
+	// DataExtensionsDir is the directory of a backup folder holding the data extensions
+	DataExtensionsDir = "data_extensions"
+	// DataExtensionManifestFile lists the data extensions of a backup folder with their row counts
+	DataExtensionManifestFile = "data_extensions.json"
+)
+
+// DataExtensionManifestKey returns the key of the data extension manifest of a folder
+func DataExtensionManifestKey(folder string) string {
+	return path.Join(folder, DataExtensionManifestFile)
+}
+
+// DataExtensionStorage writes data extensions to every object store: the schema as
+// <key>.schema.json and the rows as <key>.csv or <key>.jsonl in the data_extensions
+// directory of the backup folder. The rows are spooled to a temporary file as they are
+// fetched and streamed from it to the local, S3 and SFTP stores, so a large data extension
+// is never held in memory.
+type DataExtensionStorage struct {
+	stores []ObjectStore
+	// TempDir holds the spooled rows, the system temporary directory if empty
+	TempDir string
+}
+
+func NewDataExtensionStorage(stores ...ObjectStore) *DataExtensionStorage {
+	return &DataExtensionStorage{stores: stores}
+}
+
+func (s *DataExtensionStorage) SaveDataExtension(ctx context.Context, folder string, de model.DataExtension, format string, writeRows func(w io.Writer) (int, error)) (model.DataExtensionEntry, error) {
+	name := sanitizeName(de.CustomerKey)
+	entry := model.DataExtensionEntry{
+		CustomerKey: de.CustomerKey,
+		Name:        de.Name,
+		Schema:      path.Join(folder, DataExtensionsDir, name+".schema.json"),
+		Object:      path.Join(folder, DataExtensionsDir, name+"."+format),
+		Format:      format,
+	}
+
+	schema, err := json.MarshalIndent(de, "", "  ")
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal schema: %v", err)
+	}
+	for _, store := range s.stores {
+		if err := store.PutObject(ctx, entry.Schema, schema); err != nil {
+			return entry, err
+		}
+	}
+
+	spool, err := os.CreateTemp(s.TempDir, "data-extension-*."+format)
+	if err != nil {
+		return entry, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(spool, hash)}
+	if entry.Rows, err = writeRows(counter); err != nil {
+		return entry, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	entry.Size = counter.n
+
+	for _, store := range s.stores {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return entry, fmt.Errorf("failed to read spool file: %v", err)
+		}
+		if err := putObjectFrom(ctx, store, entry.Object, spool); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+func (s *DataExtensionStorage) SaveDataExtensionManifest(ctx context.Context, manifest model.DataExtensionManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal data extension manifest: %v", err)
+	}
+	for _, store := range s.stores {
+		if err := store.PutObject(ctx, DataExtensionManifestKey(manifest.Folder), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDataExtensionManifest reads the data extension manifest of a backup folder
+func LoadDataExtensionManifest(ctx context.Context, store ObjectStore, folder string) (*model.DataExtensionManifest, error) {
+	data, err := store.GetObject(ctx, DataExtensionManifestKey(folder))
+	if err != nil {
+		return nil, err
+	}
+	var manifest model.DataExtensionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode data extension manifest of %s: %v", folder, err)
+	}
+	return &manifest, nil
+}
+
+// isDataExtensionKey reports whether key belongs to the data extensions of a folder
+func isDataExtensionKey(folder, key string) bool {
+	return key == DataExtensionManifestKey(folder) || strings.HasPrefix(key, path.Join(folder, DataExtensionsDir)+"/")
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
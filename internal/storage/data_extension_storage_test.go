@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExtensionStorage(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	// wrapping the store hides PutObjectFrom, so the rows are uploaded at once
+	mirror := &lockedStore{ObjectStore: NewLocalStorage(t.TempDir())}
+	deStorage := NewDataExtensionStorage(local, mirror)
+	deStorage.TempDir = t.TempDir()
+
+	de := model.DataExtension{CustomerKey: "regions/EU", Name: "Regions", Fields: []model.DataExtensionField{{Name: "Code", FieldType: "Text"}}}
+	rows := "Code\nFR\nDE\n"
+	entry, err := deStorage.SaveDataExtension(ctx, "backup_20241121", de, "csv", func(w io.Writer) (int, error) {
+		_, err := io.WriteString(w, rows)
+		return 2, err
+	})
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(rows))
+	assert.Equal(t, model.DataExtensionEntry{
+		CustomerKey: "regions/EU",
+		Name:        "Regions",
+		Schema:      "backup_20241121/data_extensions/regions_EU.schema.json",
+		Object:      "backup_20241121/data_extensions/regions_EU.csv",
+		Format:      "csv",
+		SHA256:      hex.EncodeToString(sum[:]),
+		Size:        int64(len(rows)),
+		Rows:        2,
+	}, entry)
+
+	for _, store := range []ObjectStore{local, mirror} {
+		data, err := store.GetObject(ctx, entry.Object)
+		require.NoError(t, err)
+		assert.Equal(t, rows, string(data))
+		data, err = store.GetObject(ctx, entry.Schema)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"fieldType": "Text"`)
+	}
+
+	_, err = deStorage.SaveDataExtension(ctx, "backup_20241121", model.DataExtension{CustomerKey: "stores"}, "csv", func(w io.Writer) (int, error) {
+		return 0, fmt.Errorf("connection reset")
+	})
+	assert.EqualError(t, err, "connection reset")
+	exists, err := local.ObjectExists(ctx, "backup_20241121/data_extensions/stores.csv")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	entry.ExpectedRows = 2
+	require.NoError(t, deStorage.SaveDataExtensionManifest(ctx, model.DataExtensionManifest{Folder: "backup_20241121", Entries: []model.DataExtensionEntry{entry}}))
+	manifest, err := LoadDataExtensionManifest(ctx, mirror, "backup_20241121")
+	require.NoError(t, err)
+	require.Len(t, manifest.Entries, 1)
+	assert.True(t, manifest.Entries[0].RowCountMatches())
+
+	// the data extensions are not mistaken for content blocks of a raw folder
+	require.NoError(t, local.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Name: "Welcome"}}, "backup_20241121"))
+	require.NoError(t, local.FinalizeFolder(ctx, "backup_20241121"))
+	entries, err := LoadFolderEntries(ctx, local, "backup_20241121")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].ID)
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// ObjectsPrefix is the key prefix under which deduplicated block bodies are stored
+const ObjectsPrefix = "objects/sha256"
+
+// DedupStorage stores every distinct block body once under objects/sha256/<hash>
+// and keeps only a manifest of pointers in each backup folder.
+type DedupStorage struct {
+	store ObjectStore
+	mu    sync.Mutex
+	// manifests holds the entries saved by each run into a folder until it is finalized
+	manifests map[string]*model.Manifest
+}
+
+func NewDedupStorage(store ObjectStore) *DedupStorage {
+	return &DedupStorage{store: store, manifests: make(map[string]*model.Manifest)}
+}
+
+// ObjectKey returns the key of the object holding a body with the given hash
+func ObjectKey(hash string) string {
+	return path.Join(ObjectsPrefix, hash)
+}
+
+// SaveContentBlocks uploads the bodies that are not stored yet and records them in the folder
+// manifest. Bodies already stored have their retention extended on stores that lock objects.
+func (s *DedupStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	for _, block := range blocks {
+		data, err := json.Marshal(block)
+		if err != nil {
+			return fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		key := ObjectKey(hash)
+
+		exists, err := s.store.ObjectExists(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check object for block %d: %v", block.ID, err)
+		}
+		if !exists {
+			if err := s.store.PutObject(ctx, key, data); err != nil {
+				return fmt.Errorf("failed to store block %d: %v", block.ID, err)
+			}
+		} else if extender, ok := s.store.(RetentionExtender); ok {
+			// the reused object must stay locked as long as this backup
+			if err := extender.ExtendRetention(ctx, key); err != nil {
+				return fmt.Errorf("failed to lock object for block %d: %v", block.ID, err)
+			}
+		}
+
+		s.addEntry(ctx, folder, model.ManifestEntry{
+			ID:           block.ID,
+			CustomerKey:  block.CustomerKey,
+			Name:         block.Name,
+			ModifiedDate: block.ModifiedDate,
+			SHA256:       hash,
+			Size:         len(data),
+			Object:       key,
+		})
+	}
+	return nil
+}
+
+func (s *DedupStorage) addEntry(ctx context.Context, folder string, entry model.ManifestEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := runFolderKey(ctx, folder)
+	m, ok := s.manifests[key]
+	if !ok {
+		m = &model.Manifest{Folder: folder}
+		s.manifests[key] = m
+	}
+	m.Entries = append(m.Entries, entry)
+}
+
+// FinalizeFolder writes the manifest collected for folder, merging it with a manifest
+// left by an earlier run into the same folder. A full run marks the folder as a full
+// snapshot, which later incremental runs into the same folder keep; other folders are
+// linked to the previous folder and the snapshot their chain starts from. Assets the run
+// found deleted are recorded as tombstones, even if no asset changed.
+func (s *DedupStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	s.mu.Lock()
+	pending, ok := s.manifests[runFolderKey(ctx, folder)]
+	delete(s.manifests, runFolderKey(ctx, folder))
+	s.mu.Unlock()
+	tombstones := model.RunReportFromContext(ctx).DeletedAssets()
+	if !ok && len(tombstones) == 0 {
+		return nil
+	}
+	if !ok {
+		pending = &model.Manifest{Folder: folder}
+	}
+
+	manifest, err := LoadManifest(ctx, s.store, folder)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	if manifest == nil {
+		manifest = &model.Manifest{Folder: folder}
+	}
+	manifest.CreatedAt = time.Now().UTC()
+	manifest.Entries = mergeEntries(manifest.Entries, pending.Entries)
+	manifest.Tombstones = mergeTombstones(manifest.Tombstones, tombstones)
+	if model.BackupTypeFromContext(ctx) == model.BackupFull {
+		manifest.Type, manifest.Base, manifest.Previous = model.BackupFull, "", ""
+	} else if manifest.Type != model.BackupFull {
+		manifest.Type = model.BackupIncremental
+		if manifest.Previous, manifest.Base, err = s.chain(ctx, folder); err != nil {
+			return fmt.Errorf("failed to find the previous backup: %v", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := s.store.PutObject(ctx, ManifestKey(folder), data); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "Wrote manifest", "key", ManifestKey(folder), "entries", len(manifest.Entries))
+	return nil
+}
+
+// ReleaseFolder drops the entries of a run that failed before its folder was finalized
+func (s *DedupStorage) ReleaseFolder(ctx context.Context, folder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.manifests, runFolderKey(ctx, folder))
+}
+
+// chain returns the folder before folder and the full snapshot its chain starts from,
+// which is empty when a folder without a snapshot or manifest breaks the chain
+func (s *DedupStorage) chain(ctx context.Context, folder string) (previous, base string, err error) {
+	folders, err := ListBackupFolders(ctx, s.store)
+	if err != nil {
+		return "", "", err
+	}
+	for _, f := range folders {
+		if f.Name >= folder {
+			continue
+		}
+		manifest, err := LoadManifest(ctx, s.store, f.Name)
+		if errors.Is(err, ErrObjectNotFound) {
+			return f.Name, "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if manifest.Type == model.BackupFull {
+			return f.Name, f.Name, nil
+		}
+		return f.Name, manifest.Base, nil
+	}
+	return "", "", nil
+}
+
+// mergeTombstones adds tombstones to those of an earlier run, keeping the first detection
+// of each asset, and sorts them by asset ID
+func mergeTombstones(older, newer []model.Tombstone) []model.Tombstone {
+	if len(newer) == 0 {
+		return older
+	}
+	byID := make(map[int]model.Tombstone, len(older)+len(newer))
+	for _, t := range newer {
+		byID[t.ID] = t
+	}
+	for _, t := range older {
+		byID[t.ID] = t
+	}
+	merged := make([]model.Tombstone, 0, len(byID))
+	for _, t := range byID {
+		merged = append(merged, t)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].ID < merged[j].ID
+	})
+	return merged
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupStorage_SaveContentBlocks(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+
+	block := model.ContentBlock{ID: 1, Name: "Block1", Content: "Content1", ModifiedDate: time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)}
+
+	for _, folder := range []string{"backup_20241121", "backup_20241122"} {
+		assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{block}, folder))
+		assert.NoError(t, dedup.FinalizeFolder(ctx, folder))
+	}
+
+	objects, err := store.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	manifest, err := LoadManifest(ctx, store, "backup_20241122")
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Entries, 1)
+	assert.Equal(t, 1, manifest.Entries[0].ID)
+	assert.Equal(t, objects[0], manifest.Entries[0].Object)
+
+	refs, err := CountReferences(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, refs[objects[0]])
+}
+
+func TestDedupStorage_FinalizeFolderMergesEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+	folder := "backup_20241121"
+
+	assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Content: "v1"}, {ID: 2, Content: "v1"}}, folder))
+	assert.NoError(t, dedup.FinalizeFolder(ctx, folder))
+	assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 2, Content: "v2"}}, folder))
+	assert.NoError(t, dedup.FinalizeFolder(ctx, folder))
+
+	manifest, err := LoadManifest(ctx, store, folder)
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Entries, 2)
+
+	data, err := store.GetObject(ctx, manifest.Entries[1].Object)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"v2"`)
+}
+
+func TestDedupStorage_RetryAfterFailedRun(t *testing.T) {
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+	folder := "backup_20241121"
+
+	// the first run fails after saving block 1 and never finalizes the folder
+	failed := model.WithRunID(context.Background(), "run-1")
+	assert.NoError(t, dedup.SaveContentBlocks(failed, []model.ContentBlock{{ID: 1, Content: "v1"}}, folder))
+	// the retry runs before the failed run is released and must not pick up its entries
+	retry := model.WithRunID(context.Background(), "run-2")
+	assert.NoError(t, dedup.SaveContentBlocks(retry, []model.ContentBlock{{ID: 2, Content: "v1"}}, folder))
+	dedup.ReleaseFolder(failed, folder)
+	assert.NoError(t, dedup.FinalizeFolder(retry, folder))
+
+	manifest, err := LoadManifest(retry, store, folder)
+	assert.NoError(t, err)
+	if assert.Len(t, manifest.Entries, 1) {
+		assert.Equal(t, 2, manifest.Entries[0].ID)
+	}
+	assert.Empty(t, dedup.manifests)
+}
+
+func TestDedupStorage_FinalizeFolderChain(t *testing.T) {
+	ctx := context.Background()
+	full := model.WithBackupType(ctx, model.BackupFull)
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+
+	runs := []struct {
+		ctx    context.Context
+		folder string
+	}{
+		{ctx, "backup_20241120"},
+		{full, "backup_20241121"},
+		{ctx, "backup_20241122"},
+		{ctx, "backup_20241123"},
+		// an incremental run into a snapshot folder keeps it a snapshot
+		{ctx, "backup_20241121"},
+	}
+	for _, run := range runs {
+		assert.NoError(t, dedup.SaveContentBlocks(run.ctx, []model.ContentBlock{{ID: 1, Content: run.folder}}, run.folder))
+		assert.NoError(t, dedup.FinalizeFolder(run.ctx, run.folder))
+	}
+
+	expected := map[string]model.Manifest{
+		"backup_20241120": {Type: model.BackupIncremental},
+		"backup_20241121": {Type: model.BackupFull},
+		"backup_20241122": {Type: model.BackupIncremental, Base: "backup_20241121", Previous: "backup_20241121"},
+		"backup_20241123": {Type: model.BackupIncremental, Base: "backup_20241121", Previous: "backup_20241122"},
+	}
+	for folder, want := range expected {
+		manifest, err := LoadManifest(ctx, store, folder)
+		assert.NoError(t, err)
+		assert.Equal(t, want.Type, manifest.Type, folder)
+		assert.Equal(t, want.Base, manifest.Base, folder)
+		assert.Equal(t, want.Previous, manifest.Previous, folder)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+
+	assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Content: "old"}}, "backup_20241121"))
+	assert.NoError(t, dedup.FinalizeFolder(ctx, "backup_20241121"))
+	assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Content: "new"}}, "backup_20241122"))
+	assert.NoError(t, dedup.FinalizeFolder(ctx, "backup_20241122"))
+
+	assert.NoError(t, store.DeleteObject(ctx, ManifestKey("backup_20241121")))
+
+	// the unreferenced object was just written, as if by a backup still in progress
+	recent, err := CollectGarbage(ctx, store, false, time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, recent)
+
+	dryRun, err := CollectGarbage(ctx, store, true, 0)
+	assert.NoError(t, err)
+	assert.Len(t, dryRun, 1)
+
+	deleted, err := CollectGarbage(ctx, store, false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, dryRun, deleted)
+
+	objects, err := store.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	manifest, err := LoadManifest(ctx, store, "backup_20241122")
+	assert.NoError(t, err)
+	assert.Equal(t, objects[0], manifest.Entries[0].Object)
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/Feride3d/backup-creator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Destination is a named storage written by FanOutStorage
+type Destination struct {
+	Name    string
+	Storage service.Storage
+}
+
+// FanOutPolicy decides how many destinations must succeed for a run to succeed
+type FanOutPolicy string
+
+const (
+	FanOutAll    FanOutPolicy = "all"    // every destination must succeed
+	FanOutAny    FanOutPolicy = "any"    // at least one destination must succeed
+	FanOutQuorum FanOutPolicy = "quorum" // a majority of destinations must succeed
+)
+
+// ParseFanOutPolicy parses a policy name, defaulting to FanOutAll
+func ParseFanOutPolicy(name string) (FanOutPolicy, error) {
+	switch FanOutPolicy(name) {
+	case "":
+		return FanOutAll, nil
+	case FanOutAll, FanOutAny, FanOutQuorum:
+		return FanOutPolicy(name), nil
+	default:
+		return "", fmt.Errorf("unknown fan-out policy %q", name)
+	}
+}
+
+// required returns the number of destinations out of total that must succeed
+func (p FanOutPolicy) required(total int) int {
+	switch p {
+	case FanOutAny:
+		return 1
+	case FanOutQuorum:
+		return total/2 + 1
+	default:
+		return total
+	}
+}
+
+type destinationState struct {
+	saved  int
+	failed int
+	err    error
+}
+
+// FanOutStorage writes every content block to several destinations concurrently.
+// A destination that fails any block is considered failed for the whole folder;
+// the folder fails once the policy can no longer be met. The state of the destinations
+// is kept per run, so a retry into the same folder starts with every destination.
+type FanOutStorage struct {
+	destinations []Destination
+	policy       FanOutPolicy
+	mu           sync.Mutex
+	states       map[string][]*destinationState
+}
+
+// runFolderKey identifies the folder of the current run in the state kept across calls
+func runFolderKey(ctx context.Context, folder string) string {
+	return model.RunIDFromContext(ctx) + "/" + folder
+}
+
+func NewFanOutStorage(policy FanOutPolicy, destinations ...Destination) *FanOutStorage {
+	return &FanOutStorage{
+		destinations: destinations,
+		policy:       policy,
+		states:       make(map[string][]*destinationState),
+	}
+}
+
+func (s *FanOutStorage) folderStates(ctx context.Context, folder string) []*destinationState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := runFolderKey(ctx, folder)
+	states, ok := s.states[key]
+	if !ok {
+		states = make([]*destinationState, len(s.destinations))
+		for i := range states {
+			states[i] = &destinationState{}
+		}
+		s.states[key] = states
+	}
+	return states
+}
+
+// takeStates removes the state of the folder of the current run, nil if there is none
+func (s *FanOutStorage) takeStates(ctx context.Context, folder string) []*destinationState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := runFolderKey(ctx, folder)
+	states := s.states[key]
+	delete(s.states, key)
+	return states
+}
+
+// SaveContentBlocks saves the blocks to all destinations concurrently
+func (s *FanOutStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	states := s.folderStates(ctx, folder)
+
+	var wg sync.WaitGroup
+	for i, d := range s.destinations {
+		s.mu.Lock()
+		failed := states[i].err != nil
+		if failed {
+			// the destination already misses blocks of this folder, so skip it
+			states[i].failed += len(blocks)
+		}
+		s.mu.Unlock()
+		if failed {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d Destination) {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "storage.write", attribute.String("destination", d.Name), attribute.Int("blocks", len(blocks)))
+			err := d.Storage.SaveContentBlocks(ctx, blocks, folder)
+			tracing.End(span, err)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if err != nil {
+				states[i].failed += len(blocks)
+				if states[i].err == nil {
+					states[i].err = err
+				}
+				logging.FromContext(ctx).ErrorContext(ctx, "Destination failed to save blocks", "destination", d.Name, "error", err)
+				return
+			}
+			states[i].saved += len(blocks)
+		}(i, d)
+	}
+	wg.Wait()
+
+	return s.checkPolicy(states)
+}
+
+// FinalizeFolder finalizes the destinations that saved every block, records the result of
+// each destination in the run report and fails if the policy is not met
+func (s *FanOutStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	states := s.folderStates(ctx, folder)
+	defer s.takeStates(ctx, folder)
+
+	var wg sync.WaitGroup
+	for i, d := range s.destinations {
+		f, ok := d.Storage.(service.Finalizer)
+		if !ok || states[i].err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			if err := f.FinalizeFolder(ctx, folder); err != nil {
+				s.mu.Lock()
+				states[i].err = fmt.Errorf("failed to finalize: %w", err)
+				s.mu.Unlock()
+				logging.FromContext(ctx).ErrorContext(ctx, "Destination failed to finalize folder", "destination", name, "folder", folder, "error", err)
+			}
+		}(i, d.Name)
+	}
+	wg.Wait()
+
+	s.report(ctx, states)
+	return s.checkPolicy(states)
+}
+
+// ReleaseFolder records the result of each destination of a run that failed before the
+// folder was finalized and drops its state
+func (s *FanOutStorage) ReleaseFolder(ctx context.Context, folder string) {
+	if states := s.takeStates(ctx, folder); states != nil {
+		s.report(ctx, states)
+	}
+	for _, d := range s.destinations {
+		if r, ok := d.Storage.(service.Releaser); ok {
+			r.ReleaseFolder(ctx, folder)
+		}
+	}
+}
+
+// report records the result of each destination in the run report
+func (s *FanOutStorage) report(ctx context.Context, states []*destinationState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := model.RunReportFromContext(ctx)
+	for i, d := range s.destinations {
+		result := model.DestinationResult{
+			Name:   d.Name,
+			Saved:  states[i].saved,
+			Failed: states[i].failed,
+			OK:     states[i].err == nil,
+		}
+		if states[i].err != nil {
+			result.Error = states[i].err.Error()
+		}
+		report.AddDestination(result)
+	}
+}
+
+// checkPolicy fails once too many destinations have failed for the policy to be met
+func (s *FanOutStorage) checkPolicy(states []*destinationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failed []string
+	var firstErr error
+	for i, state := range states {
+		if state.err != nil {
+			failed = append(failed, s.destinations[i].Name)
+			if firstErr == nil {
+				firstErr = state.err
+			}
+		}
+	}
+	healthy := len(states) - len(failed)
+	if required := s.policy.required(len(states)); healthy < required {
+		return fmt.Errorf("fan-out policy %q not met: %d of %d destinations succeeded, need %d (failed: %v): %w",
+			s.policy, healthy, len(states), required, failed, firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/Feride3d/backup-creator/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockBlockStorage is a mock destination that also implements service.Finalizer
+type MockBlockStorage struct {
+	mock.Mock
+}
+
+func (m *MockBlockStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	args := m.Called(blocks, folder)
+	return args.Error(0)
+}
+
+func (m *MockBlockStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	args := m.Called(folder)
+	return args.Error(0)
+}
+
+func TestFanOutStorage(t *testing.T) {
+	blocks := []model.ContentBlock{{ID: 1, Name: "Block1", Content: "Content1"}}
+	folder := "backup_20241121"
+
+	tests := []struct {
+		name        string
+		policy      FanOutPolicy
+		failing     int
+		expectError bool
+	}{
+		{name: "All succeed", policy: FanOutAll, failing: 0},
+		{name: "All policy with one failure", policy: FanOutAll, failing: 1, expectError: true},
+		{name: "Quorum with one failure", policy: FanOutQuorum, failing: 1},
+		{name: "Quorum with two failures", policy: FanOutQuorum, failing: 2, expectError: true},
+		{name: "Any with two failures", policy: FanOutAny, failing: 2},
+		{name: "Any with all failures", policy: FanOutAny, failing: 3, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var destinations []Destination
+			var mocks []*MockBlockStorage
+			for i, name := range []string{"nas", "s3", "gcs"} {
+				m := new(MockBlockStorage)
+				if i < tt.failing {
+					m.On("SaveContentBlocks", blocks, folder).Return(errors.New(name + " unavailable"))
+				} else {
+					m.On("SaveContentBlocks", blocks, folder).Return(nil)
+					m.On("FinalizeFolder", folder).Return(nil).Maybe()
+				}
+				mocks = append(mocks, m)
+				destinations = append(destinations, Destination{Name: name, Storage: m})
+			}
+
+			report := &model.RunReport{}
+			ctx := model.WithRunReport(context.Background(), report)
+			fanOut := NewFanOutStorage(tt.policy, destinations...)
+
+			err := fanOut.SaveContentBlocks(ctx, blocks, folder)
+			if err == nil {
+				err = fanOut.FinalizeFolder(ctx, folder)
+				assert.Len(t, report.Destinations, 3)
+				for i, d := range report.Destinations {
+					assert.Equal(t, i >= tt.failing, d.OK, d.Name)
+				}
+			}
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "fan-out policy")
+			} else {
+				assert.NoError(t, err)
+			}
+			for i, m := range mocks {
+				m.AssertCalled(t, "SaveContentBlocks", blocks, folder)
+				if i < tt.failing {
+					m.AssertNotCalled(t, "FinalizeFolder", folder)
+				}
+			}
+		})
+	}
+}
+
+func TestFanOutStorage_SkipsFailedDestination(t *testing.T) {
+	failing := new(MockBlockStorage)
+	failing.On("SaveContentBlocks", mock.Anything, "backup_20241121").Return(errors.New("disk full")).Once()
+	healthy := new(MockBlockStorage)
+	healthy.On("SaveContentBlocks", mock.Anything, "backup_20241121").Return(nil).Twice()
+	healthy.On("FinalizeFolder", "backup_20241121").Return(nil).Once()
+
+	report := &model.RunReport{}
+	ctx := model.WithRunReport(context.Background(), report)
+	fanOut := NewFanOutStorage(FanOutAny, Destination{Name: "local", Storage: failing}, Destination{Name: "s3", Storage: healthy})
+
+	assert.NoError(t, fanOut.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1}}, "backup_20241121"))
+	assert.NoError(t, fanOut.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 2}}, "backup_20241121"))
+	assert.NoError(t, fanOut.FinalizeFolder(ctx, "backup_20241121"))
+
+	assert.Equal(t, []model.DestinationResult{
+		{Name: "local", Saved: 0, Failed: 2, OK: false, Error: "disk full"},
+		{Name: "s3", Saved: 2, Failed: 0, OK: true},
+	}, report.Destinations)
+	failing.AssertExpectations(t)
+	healthy.AssertExpectations(t)
+}
+
+func TestFanOutStorage_RetryAfterFailedRun(t *testing.T) {
+	blocks := []model.ContentBlock{{ID: 1}}
+	flaky := new(MockBlockStorage)
+	flaky.On("SaveContentBlocks", blocks, "backup_20241121").Return(errors.New("disk full")).Once()
+	flaky.On("SaveContentBlocks", blocks, "backup_20241121").Return(nil).Once()
+	flaky.On("FinalizeFolder", "backup_20241121").Return(nil).Once()
+	healthy := new(MockBlockStorage)
+	healthy.On("SaveContentBlocks", blocks, "backup_20241121").Return(nil).Twice()
+	healthy.On("FinalizeFolder", "backup_20241121").Return(nil).Once()
+	backup := service.NewBackupService(NewFanOutStorage(FanOutAll, Destination{Name: "local", Storage: flaky}, Destination{Name: "s3", Storage: healthy}))
+
+	failed := &model.RunReport{}
+	ctx := model.WithRunReport(model.WithRunID(context.Background(), "run-1"), failed)
+	assert.ErrorContains(t, backup.SaveContent(ctx, blocks, "backup_20241121"), "disk full")
+	// the failed run still reports its destinations
+	assert.Equal(t, []model.DestinationResult{
+		{Name: "local", Failed: 1, Error: "disk full"},
+		{Name: "s3", Saved: 1, OK: true},
+	}, failed.Destinations)
+
+	// a retry the same day writes to the destination that failed before
+	retry := &model.RunReport{}
+	ctx = model.WithRunReport(model.WithRunID(context.Background(), "run-2"), retry)
+	assert.NoError(t, backup.SaveContent(ctx, blocks, "backup_20241121"))
+	assert.Equal(t, []model.DestinationResult{
+		{Name: "local", Saved: 1, OK: true},
+		{Name: "s3", Saved: 1, OK: true},
+	}, retry.Destinations)
+	flaky.AssertExpectations(t)
+	healthy.AssertExpectations(t)
+}
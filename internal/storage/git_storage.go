@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// GitOptions configures the repository written by GitStorage
+type GitOptions struct {
+	Remote      string // optional URL the repository is pushed to after each run
+	Branch      string // defaults to "main"
+	AuthorName  string // defaults to "backup-creator"
+	AuthorEmail string // defaults to "backup-creator@localhost"
+}
+
+// GitStorage writes the human-readable export into a git working tree and records
+// each run as one commit tagged with the run ID. Files live at stable paths at the
+// root of the tree, so `git log` and `git blame` follow an asset across runs.
+type GitStorage struct {
+	dir    string
+	opts   GitOptions
+	layout HumanLayout
+	mu     sync.Mutex
+	index  map[int][]string // asset ID -> files currently in the tree
+}
+
+// assetFilePattern extracts the asset ID from a file written by HumanLayout
+var assetFilePattern = regexp.MustCompile(`_(\d+)(\.meta\.json|\.[A-Za-z0-9]+)$`)
+
+// NewGitStorage opens the git repository in dir, initializing it if needed
+func NewGitStorage(ctx context.Context, dir string, opts GitOptions) (*GitStorage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("git repository path cannot be empty")
+	}
+	if opts.Branch == "" {
+		opts.Branch = "main"
+	}
+	if opts.AuthorName == "" {
+		opts.AuthorName = "backup-creator"
+	}
+	if opts.AuthorEmail == "" {
+		opts.AuthorEmail = "backup-creator@localhost"
+	}
+	s := &GitStorage{dir: dir, opts: opts}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create repository directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if _, err := s.git(ctx, "init"); err != nil {
+			return nil, err
+		}
+		if _, err := s.git(ctx, "symbolic-ref", "HEAD", "refs/heads/"+opts.Branch); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Remote != "" {
+		if _, err := s.git(ctx, "remote", "get-url", "origin"); err != nil {
+			_, err = s.git(ctx, "remote", "add", "origin", opts.Remote)
+			if err != nil {
+				return nil, err
+			}
+		} else if _, err := s.git(ctx, "remote", "set-url", "origin", opts.Remote); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SaveContentBlocks writes the blocks into the working tree, removing files left
+// behind when an asset was renamed or moved to another category
+func (s *GitStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		files, err := s.layout.Files(block)
+		if err != nil {
+			return err
+		}
+		written := make(map[string]bool, len(files))
+		for _, file := range files {
+			filePath := filepath.Join(s.dir, filepath.FromSlash(file.Path))
+			if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+				return fmt.Errorf("failed to create directory for block %d: %v", block.ID, err)
+			}
+			if err := os.WriteFile(filePath, file.Data, 0644); err != nil {
+				return fmt.Errorf("failed to write block %d to file: %v", block.ID, err)
+			}
+			metrics.BytesWritten.WithLabelValues("git").Add(float64(len(file.Data)))
+			written[file.Path] = true
+		}
+		for _, old := range s.index[block.ID] {
+			if written[old] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(old))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to remove stale file %s: %v", old, err)
+			}
+		}
+		s.index[block.ID] = s.index[block.ID][:0]
+		for p := range written {
+			s.index[block.ID] = append(s.index[block.ID], p)
+		}
+	}
+	return nil
+}
+
+// FinalizeFolder commits the changes of the run, tags the commit with the run ID and
+// pushes both to the configured remote
+func (s *GitStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runID := model.RunIDFromContext(ctx)
+	if runID == "" {
+		runID = folder
+	}
+
+	if _, err := s.git(ctx, "add", "--all"); err != nil {
+		return err
+	}
+	status, err := s.git(ctx, "status", "--porcelain", "-z", "--no-renames")
+	if err != nil {
+		return err
+	}
+	changed := changedAssets(status)
+	if len(changed) > 0 {
+		message := fmt.Sprintf("Backup run %s: %d assets changed", runID, len(changed))
+		if _, err := s.git(ctx, "commit", "--quiet", "-m", message); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "Committed backup run", "repository", s.dir, "changed", len(changed))
+	}
+
+	if _, err := s.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// nothing has ever been committed, so there is nothing to tag or push
+		return nil
+	}
+	tag := "run-" + runID
+	if _, err := s.git(ctx, "tag", "--force", "-a", tag, "-m", "Backup run "+runID); err != nil {
+		return err
+	}
+	if s.opts.Remote != "" {
+		if _, err := s.git(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+s.opts.Branch); err != nil {
+			return err
+		}
+		if _, err := s.git(ctx, "push", "--quiet", "--force", "origin", "refs/tags/"+tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseFolder discards what a run left uncommitted in the working tree, so a run that
+// failed before FinalizeFolder does not end up in the commit of the next run
+func (s *GitStorage) ReleaseFolder(ctx context.Context, folder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the files are read again by the next run
+	s.index = nil
+
+	var err error
+	if _, headErr := s.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); headErr != nil {
+		// nothing has been committed yet, so unstage everything
+		_, err = s.git(ctx, "rm", "-r", "--cached", "--quiet", "--ignore-unmatch", ".")
+	} else {
+		_, err = s.git(ctx, "reset", "--hard", "--quiet", "HEAD")
+	}
+	if err == nil {
+		_, err = s.git(ctx, "clean", "-fd", "--quiet")
+	}
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to discard uncommitted changes", "repository", s.dir, "error", err)
+	}
+}
+
+// loadIndex maps asset IDs to the files already in the working tree
+func (s *GitStorage) loadIndex() error {
+	if s.index != nil {
+		return nil
+	}
+	s.index = make(map[int][]string)
+	return filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if id, ok := assetID(rel); ok {
+			s.index[id] = append(s.index[id], rel)
+		}
+		return nil
+	})
+}
+
+func (s *GitStorage) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+s.opts.AuthorName,
+		"GIT_AUTHOR_EMAIL="+s.opts.AuthorEmail,
+		"GIT_COMMITTER_NAME="+s.opts.AuthorName,
+		"GIT_COMMITTER_EMAIL="+s.opts.AuthorEmail,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// changedAssets returns the IDs of the assets touched by the staged changes in
+// `git status --porcelain -z` output
+func changedAssets(status string) map[int]bool {
+	changed := make(map[int]bool)
+	for _, entry := range strings.Split(status, "\x00") {
+		if len(entry) < 4 {
+			continue
+		}
+		if id, ok := assetID(entry[3:]); ok {
+			changed[id] = true
+		}
+	}
+	return changed
+}
+
+func assetID(p string) (int, bool) {
+	m := assetFilePattern.FindStringSubmatch(p)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	return id, err == nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitStorage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tmpDir := t.TempDir()
+	remote := filepath.Join(tmpDir, "remote.git")
+	gitOutput(t, tmpDir, "init", "--quiet", "--bare", remote)
+
+	workTree := filepath.Join(tmpDir, "work")
+	gitStorage, err := NewGitStorage(context.Background(), workTree, GitOptions{Remote: "file://" + remote})
+	require.NoError(t, err)
+
+	header := model.ContentBlock{
+		ID:        1,
+		Name:      "Header",
+		AssetType: &model.AssetType{Name: "htmlblock"},
+		Category:  &model.Category{ID: 2, Path: []string{"Content Builder", "Blocks"}},
+		Content:   "<h1>Hello</h1>",
+	}
+	footer := model.ContentBlock{ID: 2, Name: "Footer", AssetType: &model.AssetType{Name: "htmlblock"}, Content: "<p>Bye</p>"}
+
+	run := func(runID string, blocks ...model.ContentBlock) {
+		ctx := model.WithRunID(context.Background(), runID)
+		require.NoError(t, gitStorage.SaveContentBlocks(ctx, blocks, "backup_"+runID))
+		require.NoError(t, gitStorage.FinalizeFolder(ctx, "backup_"+runID))
+	}
+
+	run("20241121T000000Z", header, footer)
+	assert.Equal(t, "Backup run 20241121T000000Z: 2 assets changed", gitOutput(t, remote, "log", "-1", "--format=%s", "main"))
+
+	// renaming an asset moves its files instead of leaving a copy behind
+	header.Name = "Page Header"
+	run("20241122T000000Z", header, footer)
+	assert.Equal(t, "Backup run 20241122T000000Z: 1 assets changed", gitOutput(t, remote, "log", "-1", "--format=%s", "main"))
+	assert.NoFileExists(t, filepath.Join(workTree, "Content Builder", "Blocks", "Header_1.html"))
+	data, err := os.ReadFile(filepath.Join(workTree, "Content Builder", "Blocks", "Page Header_1.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>Hello</h1>", string(data))
+
+	// an unchanged run is tagged without a new commit
+	run("20241123T000000Z", header, footer)
+	assert.Equal(t, "2", gitOutput(t, remote, "rev-list", "--count", "main"))
+	assert.Equal(t, "run-20241121T000000Z\nrun-20241122T000000Z\nrun-20241123T000000Z", gitOutput(t, remote, "tag", "--list"))
+}
+
+func TestGitStorage_ReleaseAfterFailedRun(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	workTree := t.TempDir()
+	gitStorage, err := NewGitStorage(context.Background(), workTree, GitOptions{})
+	require.NoError(t, err)
+
+	header := model.ContentBlock{ID: 1, Name: "Header", AssetType: &model.AssetType{Name: "htmlblock"}, Content: "<h1>Hello</h1>"}
+	footer := model.ContentBlock{ID: 2, Name: "Footer", AssetType: &model.AssetType{Name: "htmlblock"}, Content: "<p>Bye</p>"}
+	banner := model.ContentBlock{ID: 3, Name: "Banner", AssetType: &model.AssetType{Name: "htmlblock"}, Content: "<div>Sale</div>"}
+
+	// the first run fails before its folder is finalized
+	failed := model.WithRunID(context.Background(), "20241121T000000Z")
+	require.NoError(t, gitStorage.SaveContentBlocks(failed, []model.ContentBlock{header, banner}, "backup_20241121"))
+	gitStorage.ReleaseFolder(failed, "backup_20241121")
+	assert.NoFileExists(t, filepath.Join(workTree, "Uncategorized", "Banner_3.html"))
+
+	run := func(runID string, blocks ...model.ContentBlock) {
+		ctx := model.WithRunID(context.Background(), runID)
+		require.NoError(t, gitStorage.SaveContentBlocks(ctx, blocks, "backup_"+runID))
+		require.NoError(t, gitStorage.FinalizeFolder(ctx, "backup_"+runID))
+		gitStorage.ReleaseFolder(ctx, "backup_"+runID)
+	}
+	run("20241122T000000Z", header, footer)
+	assert.Equal(t, "Backup run 20241122T000000Z: 2 assets changed", gitOutput(t, workTree, "log", "-1", "--format=%s"))
+
+	// a failed run after a commit leaves the tree as committed
+	header.Name = "Page Header"
+	require.NoError(t, gitStorage.SaveContentBlocks(failed, []model.ContentBlock{header, banner}, "backup_20241123"))
+	gitStorage.ReleaseFolder(failed, "backup_20241123")
+
+	footer.Content = "<p>Goodbye</p>"
+	run("20241124T000000Z", footer)
+	assert.Equal(t, "Backup run 20241124T000000Z: 1 assets changed", gitOutput(t, workTree, "log", "-1", "--format=%s"))
+	assert.Equal(t, "Uncategorized/Footer_2.html", gitOutput(t, workTree, "show", "--name-only", "--format=", "HEAD"))
+	assert.Equal(t, "Uncategorized/Footer_2.html\nUncategorized/Footer_2.meta.json\nUncategorized/Header_1.html\nUncategorized/Header_1.meta.json",
+		gitOutput(t, workTree, "ls-files"))
+}
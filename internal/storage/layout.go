@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// MetaSuffix is the suffix of the metadata sidecar written next to each asset by HumanLayout
+const MetaSuffix = ".meta.json"
+
+// File is a single file written to a backup folder
+type File struct {
+	Path        string // slash-separated, relative to the backup folder
+	Data        []byte
+	ContentType string
+}
+
+// Layout decides which files a content block is written as within a backup folder
+type Layout interface {
+	Files(block model.ContentBlock) ([]File, error)
+}
+
+// NewLayout returns the layout with the given name: "raw" (default) or "human"
+func NewLayout(name string) (Layout, error) {
+	switch name {
+	case "", "raw":
+		return RawLayout{Indent: true}, nil
+	case "human":
+		return HumanLayout{}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage layout %q", name)
+	}
+}
+
+// RawLayout writes each block as <id>.json
+type RawLayout struct {
+	Indent bool
+}
+
+func (l RawLayout) Files(block model.ContentBlock) ([]File, error) {
+	var data []byte
+	var err error
+	if l.Indent {
+		data, err = json.MarshalIndent(block, "", "  ")
+	} else {
+		data, err = json.Marshal(block)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+	}
+	return []File{{Path: fmt.Sprintf("%d.json", block.ID), Data: data, ContentType: "application/json"}}, nil
+}
+
+// HumanLayout mirrors the Content Builder category tree as directories and writes the
+// primary content of each asset as a file named after the asset, e.g.
+// "Content Builder/Emails/Welcome_4512.html", with a "Welcome_4512.meta.json" sidecar
+// holding the remaining fields. The asset ID suffix keeps file names unique.
+type HumanLayout struct{}
+
+// extensions maps asset type names to the extension of their primary content file
+var extensions = map[string]string{
+	"htmlemail":          ".html",
+	"templatebasedemail": ".html",
+	"template":           ".html",
+	"htmlblock":          ".html",
+	"freeformblock":      ".html",
+	"webpage":            ".html",
+	"ampblock":           ".amp",
+	"ampemail":           ".amp",
+	"codesnippetblock":   ".ssjs",
+	"jscoderesource":     ".ssjs",
+	"csscoderesource":    ".css",
+	"textblock":          ".txt",
+	"textplusblock":      ".txt",
+}
+
+var contentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".amp":  "text/html; charset=utf-8",
+	".ssjs": "text/plain; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+}
+
+// Extension returns the primary content file extension for a block
+func (HumanLayout) Extension(block model.ContentBlock) string {
+	if block.AssetType != nil {
+		if ext, ok := extensions[strings.ToLower(block.AssetType.Name)]; ok {
+			return ext
+		}
+	}
+	return ".txt"
+}
+
+// Base returns the path of a block's files without extension
+func (HumanLayout) Base(block model.ContentBlock) string {
+	dirs := []string{"Uncategorized"}
+	if block.Category != nil && len(block.Category.Path) > 0 {
+		dirs = make([]string, len(block.Category.Path))
+		for i, name := range block.Category.Path {
+			dirs[i] = sanitizeName(name)
+		}
+	}
+	name := fmt.Sprintf("%s_%d", sanitizeName(block.Name), block.ID)
+	return path.Join(append(dirs, name)...)
+}
+
+func (l HumanLayout) Files(block model.ContentBlock) ([]File, error) {
+	content, meta, ok := splitPrimaryContent(block)
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+	}
+
+	base := l.Base(block)
+	files := []File{{Path: base + MetaSuffix, Data: metaData, ContentType: "application/json"}}
+	if ok {
+		ext := l.Extension(block)
+		files = append([]File{{Path: base + ext, Data: []byte(content), ContentType: contentTypes[ext]}}, files...)
+	}
+	return files, nil
+}
+
+// ReadBlock rebuilds a content block from its sidecar and primary content file. content is
+// nil when the block has no primary content file. Content goes back to the html view when
+// the sidecar has one without content, and to the content field otherwise.
+func (l HumanLayout) ReadBlock(metaData, content []byte) (model.ContentBlock, error) {
+	var block model.ContentBlock
+	if err := json.Unmarshal(metaData, &block); err != nil {
+		return block, fmt.Errorf("failed to decode metadata: %v", err)
+	}
+	if content == nil {
+		return block, nil
+	}
+	if html, ok := block.Views["html"].(map[string]interface{}); ok {
+		if _, ok := html["content"]; !ok {
+			html["content"] = string(content)
+			return block, nil
+		}
+	}
+	block.Content = string(content)
+	return block, nil
+}
+
+// splitPrimaryContent separates the primary content of a block, taken from its content
+// field or, for emails, from the html view, from the rest of its fields
+func splitPrimaryContent(block model.ContentBlock) (string, model.ContentBlock, bool) {
+	if content, ok := block.Content.(string); ok {
+		block.Content = nil
+		return content, block, true
+	}
+	if block.Content == nil {
+		if html, ok := block.Views["html"].(map[string]interface{}); ok {
+			if content, ok := html["content"].(string); ok {
+				views := make(map[string]interface{}, len(block.Views))
+				for k, v := range block.Views {
+					views[k] = v
+				}
+				trimmed := make(map[string]interface{}, len(html))
+				for k, v := range html {
+					if k != "content" {
+						trimmed[k] = v
+					}
+				}
+				views["html"] = trimmed
+				block.Views = views
+				return content, block, true
+			}
+		}
+	}
+	return "", block, false
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._ -]+`)
+
+// sanitizeName turns an asset or category name into a portable file name
+func sanitizeName(name string) string {
+	name = unsafeChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, " ._")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	if name == "" {
+		return "unnamed"
+	}
+	return name
+}
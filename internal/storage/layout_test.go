@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHumanLayout_Files(t *testing.T) {
+	layout := HumanLayout{}
+
+	block := model.ContentBlock{
+		ID:        4512,
+		Name:      "Welcome / Spring: 50% off",
+		AssetType: &model.AssetType{ID: 197, Name: "htmlblock"},
+		Category:  &model.Category{ID: 3, Path: []string{"Content Builder", "Emails?"}},
+		Content:   "<h1>Welcome</h1>",
+	}
+
+	files, err := layout.Files(block)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "Content Builder/Emails/Welcome _ Spring_ 50_ off_4512.html", files[0].Path)
+	assert.Equal(t, "<h1>Welcome</h1>", string(files[0].Data))
+	assert.Equal(t, "text/html; charset=utf-8", files[0].ContentType)
+	assert.Equal(t, "Content Builder/Emails/Welcome _ Spring_ 50_ off_4512.meta.json", files[1].Path)
+	assert.NotContains(t, string(files[1].Data), "<h1>")
+
+	block.AssetType = &model.AssetType{Name: "codesnippetblock"}
+	block.Category = nil
+	assert.Equal(t, "Uncategorized/Welcome _ Spring_ 50_ off_4512", layout.Base(block))
+	assert.Equal(t, ".ssjs", layout.Extension(block))
+}
+
+func TestHumanLayout_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	localStorage := NewLocalStorage(tmpDir)
+	localStorage.SetLayout(HumanLayout{})
+
+	modified := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+	blocks := []model.ContentBlock{
+		{
+			ID:           1,
+			Name:         "Header",
+			AssetType:    &model.AssetType{ID: 197, Name: "htmlblock"},
+			Category:     &model.Category{ID: 2, Path: []string{"Content Builder", "Blocks"}},
+			ModifiedDate: modified,
+			Content:      "<h1>Hello</h1>",
+		},
+		{
+			ID:           2,
+			Name:         "Newsletter",
+			AssetType:    &model.AssetType{ID: 208, Name: "htmlemail"},
+			ModifiedDate: modified,
+			Views: map[string]interface{}{
+				"html":    map[string]interface{}{"content": "<html></html>"},
+				"subject": map[string]interface{}{"content": "Hi"},
+			},
+		},
+	}
+
+	err := localStorage.SaveContentBlocks(ctx, blocks, "backup_20241121")
+	assert.NoError(t, err)
+	assert.NoError(t, localStorage.FinalizeFolder(ctx, "backup_20241121"))
+	assert.FileExists(t, filepath.Join(tmpDir, "backup_20241121", "Content Builder", "Blocks", "Header_1.html"))
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "backup_20241121", "Uncategorized", "Newsletter_2.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "<html></html>", string(data))
+
+	restored, err := LoadFolderBlocks(ctx, localStorage, "backup_20241121")
+	assert.NoError(t, err)
+	assert.Equal(t, blocks, restored)
+}
+
+func TestLoadFolderEntries_HumanLayoutContentChange(t *testing.T) {
+	ctx := context.Background()
+	localStorage := NewLocalStorage(t.TempDir())
+	localStorage.SetLayout(HumanLayout{})
+
+	block := model.ContentBlock{ID: 1, Name: "Header", AssetType: &model.AssetType{Name: "htmlblock"}, Content: "<h1>Hello</h1>"}
+	entries := make(map[string]model.ManifestEntry)
+	for folder, content := range map[string]string{"backup_20241121": "<h1>Hello</h1>", "backup_20241122": "<h1>Hello!</h1>"} {
+		block.Content = content
+		require.NoError(t, localStorage.SaveContentBlocks(ctx, []model.ContentBlock{block}, folder))
+		require.NoError(t, localStorage.FinalizeFolder(ctx, folder))
+		folderEntries, err := LoadFolderEntries(ctx, localStorage, folder)
+		require.NoError(t, err)
+		require.Len(t, folderEntries, 1)
+		entries[folder] = folderEntries[0]
+	}
+
+	// only the content file differs, the sidecars are identical
+	before, after := entries["backup_20241121"], entries["backup_20241122"]
+	assert.Equal(t, "backup_20241121/Uncategorized/Header_1.meta.json", before.Object)
+	assert.NotEqual(t, before.SHA256, after.SHA256)
+	assert.Equal(t, before.Size+1, after.Size)
+}
@@ -2,40 +2,282 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
 )
 
+const (
+	// stagingDir holds the folders of runs in progress until they are finalized
+	stagingDir = ".inprogress"
+	// quarantineDir receives abandoned staging folders when they are not removed
+	quarantineDir = ".quarantine"
+)
+
 type LocalStorage struct {
 	storagePath string
+	layout      Layout
+	fileMode    os.FileMode
+	dirMode     os.FileMode
 }
 
 func NewLocalStorage(storagePath string) *LocalStorage {
-	return &LocalStorage{storagePath: storagePath}
+	return &LocalStorage{
+		storagePath: storagePath,
+		layout:      RawLayout{Indent: true},
+		fileMode:    0644,
+		dirMode:     os.ModePerm,
+	}
+}
+
+// SetLayout changes how content blocks are written to backup folders
+func (s *LocalStorage) SetLayout(layout Layout) {
+	s.layout = layout
+}
+
+// SetPermissions changes the permissions of the files and directories created by the storage
+func (s *LocalStorage) SetPermissions(fileMode, dirMode os.FileMode) {
+	s.fileMode = fileMode
+	s.dirMode = dirMode
+}
+
+// stagingPath returns the directory the current run writes folder to before it is finalized
+func (s *LocalStorage) stagingPath(ctx context.Context, folder string) string {
+	run := model.RunIDFromContext(ctx)
+	if run == "" {
+		run = folder
+	}
+	return filepath.Join(s.storagePath, stagingDir, run)
 }
 
-// SaveContentBlocks saves content blocks to the local file system
+// SaveContentBlocks saves content blocks to the staging directory of the current run.
+// They appear in the backup folder once FinalizeFolder is called.
 func (s *LocalStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
-	backupPath := filepath.Join(s.storagePath, folder)
+	backupPath := s.stagingPath(ctx, folder)
 
-	if err := os.MkdirAll(backupPath, os.ModePerm); err != nil {
+	if err := os.MkdirAll(backupPath, s.dirMode); err != nil {
 		return fmt.Errorf("failed to create backup directory: %v", err)
 	}
 
 	for _, block := range blocks {
-		filePath := filepath.Join(backupPath, fmt.Sprintf("%d.json", block.ID))
-		data, err := json.MarshalIndent(block, "", "  ")
+		files, err := s.layout.Files(block)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			filePath := filepath.Join(backupPath, filepath.FromSlash(file.Path))
+			if err := os.MkdirAll(filepath.Dir(filePath), s.dirMode); err != nil {
+				return fmt.Errorf("failed to create backup directory: %v", err)
+			}
+			if err := writeFileAtomic(filePath, file.Data, s.fileMode); err != nil {
+				return fmt.Errorf("failed to write block %d to file: %v", block.ID, err)
+			}
+			metrics.BytesWritten.WithLabelValues("local").Add(float64(len(file.Data)))
+		}
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "Saved content blocks to local directory", "path", backupPath, "count", len(blocks))
+	return nil
+}
+
+// FinalizeFolder moves the staging directory of the current run to the backup folder,
+// merging it into a folder left by an earlier run of the same day
+func (s *LocalStorage) FinalizeFolder(ctx context.Context, folder string) error {
+	staging := s.stagingPath(ctx, folder)
+	target := filepath.Join(s.storagePath, folder)
+
+	if _, err := os.Stat(staging); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := os.Rename(staging, target); err == nil {
+		return syncDir(filepath.Dir(target))
+	}
+
+	err := filepath.WalkDir(staging, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+			return err
+		}
+		rel, err := filepath.Rel(staging, p)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, s.dirMode)
 		}
-		if err := os.WriteFile(filePath, data, 0644); err != nil {
-			return fmt.Errorf("failed to write block %d to file: %v", block.ID, err)
+		return os.Rename(p, dest)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move staged files to %s: %v", target, err)
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("failed to remove staging directory: %v", err)
+	}
+	return syncDir(target)
+}
+
+// SweepStaging cleans up staging directories of runs that never finished and were last
+// modified more than olderThan ago. They are moved to .quarantine when quarantine is set
+// and removed otherwise. The names of the swept directories are returned.
+func (s *LocalStorage) SweepStaging(olderThan time.Duration, quarantine bool) ([]string, error) {
+	stagingRoot := filepath.Join(s.storagePath, stagingDir)
+	entries, err := os.ReadDir(stagingRoot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging directory: %v", err)
+	}
+
+	var swept []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return swept, err
+		}
+		if time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+		dir := filepath.Join(stagingRoot, entry.Name())
+		if quarantine {
+			quarantineRoot := filepath.Join(s.storagePath, quarantineDir)
+			if err := os.MkdirAll(quarantineRoot, s.dirMode); err != nil {
+				return swept, fmt.Errorf("failed to create quarantine directory: %v", err)
+			}
+			err = os.Rename(dir, filepath.Join(quarantineRoot, entry.Name()))
+		} else {
+			err = os.RemoveAll(dir)
+		}
+		if err != nil {
+			return swept, fmt.Errorf("failed to sweep %s: %v", entry.Name(), err)
+		}
+		swept = append(swept, entry.Name())
+	}
+	return swept, nil
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.storagePath, filepath.FromSlash(key))
+}
+
+// PutObject atomically writes data under key, creating parent directories as needed
+func (s *LocalStorage) PutObject(ctx context.Context, key string, data []byte) error {
+	filePath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(filePath), s.dirMode); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", key, err)
+	}
+	if err := writeFileAtomic(filePath, data, s.fileMode); err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	metrics.BytesWritten.WithLabelValues("local").Add(float64(len(data)))
+	return nil
+}
+
+// PutObjectFrom writes an object read from r without holding it in memory
+func (s *LocalStorage) PutObjectFrom(ctx context.Context, key string, r io.Reader) error {
+	filePath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(filePath), s.dirMode); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", key, err)
+	}
+	n, err := copyFileAtomic(filePath, r, s.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	metrics.BytesWritten.WithLabelValues("local").Add(float64(n))
+	return nil
+}
+
+func (s *LocalStorage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return data, nil
+}
+
+func (s *LocalStorage) ObjectExists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %v", key, err)
+	}
+	return true, nil
+}
+
+func (s *LocalStorage) ObjectModTime(ctx context.Context, key string) (time.Time, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat %s: %v", key, err)
+	}
+	return info.ModTime(), nil
+}
+
+// ListObjects returns the keys of all files under the storage root starting with prefix
+func (s *LocalStorage) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	root := s.storagePath
+	if root == "" {
+		root = "."
+	}
+	var keys []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				// staging, quarantine and other hidden directories are not part of any backup
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %v", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DeleteObject removes key and any directories left empty by the removal
+func (s *LocalStorage) DeleteObject(ctx context.Context, key string) error {
+	filePath := s.path(key)
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	root := filepath.Clean(s.path(""))
+	for dir := filepath.Dir(filePath); dir != root && dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
 		}
 	}
-	fmt.Printf("Saved content blocks to local directory: %s\n", backupPath)
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Feride3d/backup-creator/internal/model"
 	"github.com/stretchr/testify/assert"
@@ -33,8 +34,12 @@ func TestLocalStorage_SaveContentBlocks(t *testing.T) {
 
 		ctx := context.Background()
 		err := localStorage.SaveContentBlocks(ctx, blocks, folder)
+		assert.NoError(t, err)
+		assert.NoDirExists(t, filepath.Join(tmpDir, folder))
 
+		err = localStorage.FinalizeFolder(ctx, folder)
 		assert.NoError(t, err)
+		assert.NoDirExists(t, filepath.Join(tmpDir, stagingDir, folder))
 
 		for _, block := range blocks {
 			filePath := filepath.Join(tmpDir, folder, fmt.Sprintf("%d.json", block.ID))
@@ -82,3 +87,78 @@ func TestLocalStorage_SaveContentBlocks(t *testing.T) {
 		assert.Contains(t, err.Error(), "failed to marshal block")
 	})
 }
+
+func TestLocalStorage_Objects(t *testing.T) {
+	ctx := context.Background()
+	localStorage := NewLocalStorage(t.TempDir())
+
+	assert.NoError(t, localStorage.PutObject(ctx, "backup_20241121/1.json", []byte("one")))
+	assert.NoError(t, localStorage.PutObject(ctx, "backup_20241122/2.json", []byte("two")))
+
+	data, err := localStorage.GetObject(ctx, "backup_20241121/1.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+
+	_, err = localStorage.GetObject(ctx, "backup_20241121/missing.json")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+
+	keys, err := localStorage.ListObjects(ctx, "backup_2024112")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241121/1.json", "backup_20241122/2.json"}, keys)
+
+	assert.NoError(t, localStorage.DeleteObject(ctx, "backup_20241121/1.json"))
+	exists, err := localStorage.ObjectExists(ctx, "backup_20241121/1.json")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.NoDirExists(t, filepath.Join(localStorage.storagePath, "backup_20241121"))
+}
+
+func TestLocalStorage_FinalizeFolderMergesRuns(t *testing.T) {
+	tmpDir := t.TempDir()
+	localStorage := NewLocalStorage(tmpDir)
+	localStorage.SetPermissions(0600, 0700)
+	folder := "backup_20241121"
+
+	for i, runID := range []string{"20241121T000000Z", "20241121T120000Z"} {
+		ctx := model.WithRunID(context.Background(), runID)
+		block := model.ContentBlock{ID: i + 1, Name: "Block", Content: runID}
+		assert.NoError(t, localStorage.SaveContentBlocks(ctx, []model.ContentBlock{block}, folder))
+		assert.DirExists(t, filepath.Join(tmpDir, stagingDir, runID))
+		assert.NoError(t, localStorage.FinalizeFolder(ctx, folder))
+	}
+
+	keys, err := localStorage.ListObjects(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241121/1.json", "backup_20241121/2.json"}, keys)
+
+	info, err := os.Stat(filepath.Join(tmpDir, folder, "2.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Join(tmpDir, stagingDir))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLocalStorage_SweepStaging(t *testing.T) {
+	tmpDir := t.TempDir()
+	localStorage := NewLocalStorage(tmpDir)
+
+	ctx := model.WithRunID(context.Background(), "20241121T000000Z")
+	err := localStorage.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Content: "partial"}}, "backup_20241121")
+	assert.NoError(t, err)
+
+	swept, err := localStorage.SweepStaging(time.Hour, false)
+	assert.NoError(t, err)
+	assert.Empty(t, swept)
+
+	swept, err = localStorage.SweepStaging(0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20241121T000000Z"}, swept)
+	assert.FileExists(t, filepath.Join(tmpDir, quarantineDir, "20241121T000000Z", "1.json"))
+	assert.NoDirExists(t, filepath.Join(tmpDir, "backup_20241121"))
+
+	keys, err := localStorage.ListObjects(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
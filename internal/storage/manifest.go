@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// ManifestFile is the name of the manifest written to every deduplicated backup folder
+const ManifestFile = "manifest.json"
+
+// ManifestKey returns the key of the manifest of a backup folder
+func ManifestKey(folder string) string {
+	return path.Join(folder, ManifestFile)
+}
+
+// LoadManifest reads the manifest of a backup folder
+func LoadManifest(ctx context.Context, store ObjectStore, folder string) (*model.Manifest, error) {
+	data, err := store.GetObject(ctx, ManifestKey(folder))
+	if err != nil {
+		return nil, err
+	}
+	var manifest model.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of %s: %v", folder, err)
+	}
+	return &manifest, nil
+}
+
+// mergeEntries overlays newer entries on older ones by block ID and sorts the result
+func mergeEntries(older, newer []model.ManifestEntry) []model.ManifestEntry {
+	byID := make(map[int]model.ManifestEntry, len(older)+len(newer))
+	for _, e := range older {
+		byID[e.ID] = e
+	}
+	for _, e := range newer {
+		byID[e.ID] = e
+	}
+	merged := make([]model.ManifestEntry, 0, len(byID))
+	for _, e := range byID {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].ID < merged[j].ID
+	})
+	return merged
+}
+
+// LoadFolderEntries returns the content blocks stored in a backup folder. Folders without
+// a manifest are indexed by reading the raw <id>.json files they contain, or for HumanLayout
+// the blocks reassembled from each sidecar and its content file.
+func LoadFolderEntries(ctx context.Context, store ObjectStore, folder string) ([]model.ManifestEntry, error) {
+	manifest, err := LoadManifest(ctx, store, folder)
+	if err == nil {
+		return manifest.Entries, nil
+	}
+	if !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	keys, err := store.ListObjects(ctx, folder+"/")
+	if err != nil {
+		return nil, err
+	}
+	var entries []model.ManifestEntry
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") || path.Base(key) == ReportFile || isDataExtensionKey(folder, key) {
+			continue
+		}
+		data, err := store.GetObject(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		var block model.ContentBlock
+		if strings.HasSuffix(key, MetaSuffix) {
+			// the sidecar leaves out the content, so the entry covers the reassembled block
+			if block, err = readHumanBlock(ctx, store, key, data); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+			}
+			if data, err = json.Marshal(block); err != nil {
+				return nil, fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+			}
+		} else if err := json.Unmarshal(data, &block); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+		}
+		sum := sha256.Sum256(data)
+		entries = append(entries, model.ManifestEntry{
+			ID:           block.ID,
+			CustomerKey:  block.CustomerKey,
+			Name:         block.Name,
+			ModifiedDate: block.ModifiedDate,
+			SHA256:       hex.EncodeToString(sum[:]),
+			Size:         len(data),
+			Object:       key,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// LoadFolderBlocks returns the content blocks stored in a backup folder, sorted by ID
+func LoadFolderBlocks(ctx context.Context, store ObjectStore, folder string) ([]model.ContentBlock, error) {
+	entries, err := LoadFolderEntries(ctx, store, folder)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]model.ContentBlock, 0, len(entries))
+	for _, entry := range entries {
+		block, err := LoadEntryBlock(ctx, store, entry)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// LoadEntryBlock reads the content block a manifest entry points to
+func LoadEntryBlock(ctx context.Context, store ObjectStore, entry model.ManifestEntry) (model.ContentBlock, error) {
+	data, err := store.GetObject(ctx, entry.Object)
+	if err != nil {
+		return model.ContentBlock{}, err
+	}
+	var block model.ContentBlock
+	if strings.HasSuffix(entry.Object, MetaSuffix) {
+		block, err = readHumanBlock(ctx, store, entry.Object, data)
+	} else {
+		err = json.Unmarshal(data, &block)
+	}
+	if err != nil {
+		return model.ContentBlock{}, fmt.Errorf("failed to decode %s: %v", entry.Object, err)
+	}
+	return block, nil
+}
+
+// readHumanBlock rebuilds a block written by HumanLayout from its sidecar and content file
+func readHumanBlock(ctx context.Context, store ObjectStore, metaKey string, metaData []byte) (model.ContentBlock, error) {
+	var layout HumanLayout
+	var meta model.ContentBlock
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return meta, err
+	}
+	contentKey := strings.TrimSuffix(metaKey, MetaSuffix) + layout.Extension(meta)
+	content, err := store.GetObject(ctx, contentKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		content = nil
+	} else if err != nil {
+		return meta, err
+	}
+	return layout.ReadBlock(metaData, content)
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrObjectNotFound is returned when a requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrObjectLocked is returned when an object cannot be deleted because it is write-protected.
+var ErrObjectLocked = errors.New("object is locked")
+
+// ObjectStore is a key/value view of a backup destination. Keys are slash-separated
+// paths relative to the storage root, e.g. "backup_20241121/1.json".
+type ObjectStore interface {
+	PutObject(ctx context.Context, key string, data []byte) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	ObjectExists(ctx context.Context, key string) (bool, error)
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// ObjectWriter is implemented by stores that can write an object from a reader, e.g. a
+// large export, without holding it in memory
+type ObjectWriter interface {
+	PutObjectFrom(ctx context.Context, key string, r io.Reader) error
+}
+
+// putObjectFrom writes an object read from r, in memory unless the store is an ObjectWriter
+func putObjectFrom(ctx context.Context, store ObjectStore, key string, r io.Reader) error {
+	if w, ok := store.(ObjectWriter); ok {
+		return w.PutObjectFrom(ctx, key, r)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return store.PutObject(ctx, key, data)
+}
+
+// LockChecker is implemented by stores whose objects can be write-protected, e.g. by S3 Object Lock
+type LockChecker interface {
+	ObjectLocked(ctx context.Context, key string) (bool, error)
+}
+
+// ModTimer is implemented by stores that report when an object was last written
+type ModTimer interface {
+	ObjectModTime(ctx context.Context, key string) (time.Time, error)
+}
+
+// RetentionExtender is implemented by stores that lock uploaded objects for a retention
+// period. ExtendRetention locks an existing object as if it had just been uploaded, for
+// objects a backup reuses instead of uploading them again.
+type RetentionExtender interface {
+	ExtendRetention(ctx context.Context, key string) error
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// PointInTimeEntry is the version of an asset in effect at a point in time and the backup
+// folder holding it
+type PointInTimeEntry struct {
+	model.ManifestEntry
+	Folder string `json:"folder"`
+}
+
+// versionTime is when a version became current: its modified date, or the date of its
+// folder for blocks without one
+func (e PointInTimeEntry) versionTime(folder BackupFolder) time.Time {
+	if e.ModifiedDate.IsZero() {
+		return folder.Date
+	}
+	return e.ModifiedDate
+}
+
+// ResolvePointInTime returns, sorted by asset ID, the latest version of each asset modified
+// at or before at. Incremental folders only hold the assets that changed, so folders are
+// read back to the newest full snapshot finished by then, which holds every asset that
+// existed; without one every folder is read. A change is often backed up in the folder of
+// the next day, so newer folders are read too. Assets with a tombstone detected at or
+// before at and after their latest version had been deleted by then and are left out.
+func ResolvePointInTime(ctx context.Context, store ObjectStore, at time.Time) ([]PointInTimeEntry, error) {
+	folders, err := ListBackupFolders(ctx, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup folders: %w", err)
+	}
+
+	type candidate struct {
+		entry PointInTimeEntry
+		time  time.Time
+	}
+	latest := make(map[int]candidate)
+	deleted := make(map[int]time.Time)
+	// folders are listed newest first, so on equal times the newest folder wins
+	for _, folder := range folders {
+		manifest, err := LoadManifest(ctx, store, folder.Name)
+		var entries []model.ManifestEntry
+		switch {
+		case err == nil:
+			entries = manifest.Entries
+		case errors.Is(err, ErrObjectNotFound):
+			entries, err = LoadFolderEntries(ctx, store, folder.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", folder.Name, err)
+		}
+		if manifest != nil {
+			for _, t := range manifest.Tombstones {
+				if !t.DetectedAt.After(at) && t.DetectedAt.After(deleted[t.ID]) {
+					deleted[t.ID] = t.DetectedAt
+				}
+			}
+		}
+		for _, e := range entries {
+			entry := PointInTimeEntry{ManifestEntry: e, Folder: folder.Name}
+			t := entry.versionTime(folder)
+			if t.After(at) {
+				continue
+			}
+			if current, ok := latest[e.ID]; ok && !t.After(current.time) {
+				continue
+			}
+			latest[e.ID] = candidate{entry: entry, time: t}
+		}
+		if manifest != nil && manifest.Type == model.BackupFull && !manifest.CreatedAt.After(at) {
+			break
+		}
+	}
+
+	resolved := make([]PointInTimeEntry, 0, len(latest))
+	for id, c := range latest {
+		if c.time.Before(deleted[id]) {
+			continue
+		}
+		resolved = append(resolved, c.entry)
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].ID < resolved[j].ID
+	})
+	return resolved, nil
+}
+
+// LoadPointInTimeBlocks returns the blocks of the versions resolved by ResolvePointInTime
+func LoadPointInTimeBlocks(ctx context.Context, store ObjectStore, entries []PointInTimeEntry) ([]model.ContentBlock, error) {
+	blocks := make([]model.ContentBlock, 0, len(entries))
+	for _, entry := range entries {
+		block, err := LoadEntryBlock(ctx, store, entry.ManifestEntry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d from %s: %w", entry.ID, entry.Folder, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// ExportBlocks writes blocks to a local directory with the given layout
+func ExportBlocks(dir string, layout Layout, blocks []model.ContentBlock) error {
+	for _, block := range blocks {
+		files, err := layout.Files(block)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			filePath := filepath.Join(dir, filepath.FromSlash(file.Path))
+			if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+				return fmt.Errorf("failed to create export directory: %v", err)
+			}
+			if err := os.WriteFile(filePath, file.Data, 0644); err != nil {
+				return fmt.Errorf("failed to export block %d: %v", block.ID, err)
+			}
+		}
+	}
+	return nil
+}
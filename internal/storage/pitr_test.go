@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePointInTime(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	day := func(d, h int) time.Time { return time.Date(2026, 9, d, h, 0, 0, 0, time.UTC) }
+
+	save := func(dedup bool, folder string, blocks ...model.ContentBlock) {
+		if dedup {
+			dedupStorage := NewDedupStorage(store)
+			require.NoError(t, dedupStorage.SaveContentBlocks(ctx, blocks, folder))
+			require.NoError(t, dedupStorage.FinalizeFolder(ctx, folder))
+			return
+		}
+		require.NoError(t, store.SaveContentBlocks(ctx, blocks, folder))
+		require.NoError(t, store.FinalizeFolder(ctx, folder))
+	}
+	// each night's run backs up the changes of the previous day
+	save(false, "backup_20260901",
+		model.ContentBlock{ID: 1, Name: "Welcome v1", ModifiedDate: day(1, 9)},
+		model.ContentBlock{ID: 2, Name: "Footer v1", ModifiedDate: day(1, 9)})
+	save(true, "backup_20260902",
+		model.ContentBlock{ID: 1, Name: "Welcome v2", ModifiedDate: day(1, 18)},
+		model.ContentBlock{ID: 3, Name: "Header v1", ModifiedDate: day(1, 20)})
+	save(true, "backup_20260903",
+		model.ContentBlock{ID: 1, Name: "Welcome v3", ModifiedDate: day(2, 10)},
+		model.ContentBlock{ID: 4, Name: "Undated"})
+
+	names := func(entries []PointInTimeEntry) map[int]string {
+		byID := make(map[int]string)
+		for _, e := range entries {
+			byID[e.ID] = e.Name + " @ " + e.Folder
+		}
+		return byID
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected map[int]string
+	}{
+		{name: "Before the first backup", at: day(1, 8), expected: map[int]string{}},
+		{name: "First versions", at: day(1, 12), expected: map[int]string{1: "Welcome v1 @ backup_20260901", 2: "Footer v1 @ backup_20260901"}},
+		{name: "Change backed up the next day", at: day(1, 23), expected: map[int]string{
+			1: "Welcome v2 @ backup_20260902", 2: "Footer v1 @ backup_20260901", 3: "Header v1 @ backup_20260902",
+		}},
+		{name: "Latest", at: day(4, 0), expected: map[int]string{
+			1: "Welcome v3 @ backup_20260903", 2: "Footer v1 @ backup_20260901", 3: "Header v1 @ backup_20260902", 4: "Undated @ backup_20260903",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ResolvePointInTime(ctx, store, tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, names(entries))
+			for i := 1; i < len(entries); i++ {
+				assert.Less(t, entries[i-1].ID, entries[i].ID)
+			}
+		})
+	}
+
+	entries, err := ResolvePointInTime(ctx, store, day(1, 23))
+	require.NoError(t, err)
+	blocks, err := LoadPointInTimeBlocks(ctx, store, entries)
+	require.NoError(t, err)
+	require.Len(t, blocks, 3)
+	assert.Equal(t, "Welcome v2", blocks[0].Name)
+	assert.Equal(t, "Header v1", blocks[2].Name)
+}
+
+func TestExportBlocks(t *testing.T) {
+	dir := t.TempDir()
+	blocks := []model.ContentBlock{
+		{ID: 1, Name: "Welcome", AssetType: &model.AssetType{Name: "htmlemail"}, Content: "<p>Hi</p>", Category: &model.Category{Path: []string{"Content Builder", "Emails"}}},
+	}
+
+	require.NoError(t, ExportBlocks(dir, RawLayout{}, blocks))
+	require.NoError(t, ExportBlocks(filepath.Join(dir, "human"), HumanLayout{}, blocks))
+
+	assert.FileExists(t, filepath.Join(dir, "1.json"))
+	content, err := os.ReadFile(filepath.Join(dir, "human", "Content Builder", "Emails", "Welcome_1.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Hi</p>", string(content))
+}
+
+func TestResolvePointInTime_FullSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+	modified := time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	save := func(ctx context.Context, folder string, blocks ...model.ContentBlock) {
+		require.NoError(t, dedup.SaveContentBlocks(ctx, blocks, folder))
+		require.NoError(t, dedup.FinalizeFolder(ctx, folder))
+	}
+	save(ctx, "backup_20241120", model.ContentBlock{ID: 1, Name: "Deleted", ModifiedDate: modified})
+	// asset 1 was deleted before the snapshot
+	save(model.WithBackupType(ctx, model.BackupFull), "backup_20241121", model.ContentBlock{ID: 2, Name: "Welcome", ModifiedDate: modified})
+	save(ctx, "backup_20241122", model.ContentBlock{ID: 2, Name: "Welcome v2", ModifiedDate: modified.Add(36 * time.Hour)})
+
+	entries, err := ResolvePointInTime(ctx, store, time.Now())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Welcome v2", entries[0].Name)
+
+	// before the snapshot was taken the older folders are still read
+	entries, err = ResolvePointInTime(ctx, store, modified.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Deleted", entries[0].Name)
+}
+
+func TestResolvePointInTime_Tombstones(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+	modified := time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC)
+	deletedAt := modified.Add(24 * time.Hour)
+
+	require.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{{ID: 1, Name: "Welcome", ModifiedDate: modified}, {ID: 2, Name: "Footer", ModifiedDate: modified}}, "backup_20241120"))
+	require.NoError(t, dedup.FinalizeFolder(ctx, "backup_20241120"))
+	// a run that found asset 1 deleted and no changes still records the tombstone
+	report := &model.RunReport{}
+	report.AddDeleted([]model.Tombstone{{AssetRef: model.AssetRef{ID: 1, Name: "Welcome"}, DetectedAt: deletedAt}})
+	require.NoError(t, dedup.FinalizeFolder(model.WithRunReport(ctx, report), "backup_20241121"))
+
+	manifest, err := LoadManifest(ctx, store, "backup_20241121")
+	require.NoError(t, err)
+	assert.Empty(t, manifest.Entries)
+	require.Len(t, manifest.Tombstones, 1)
+
+	entries, err := ResolvePointInTime(ctx, store, deletedAt)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 2, entries[0].ID)
+
+	entries, err = ResolvePointInTime(ctx, store, deletedAt.Add(-time.Second))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
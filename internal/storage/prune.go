@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// DefaultGCGracePeriod is how long an unreferenced deduplicated object is kept after it was
+// written, so a backup running in another process can finish its manifest
+const DefaultGCGracePeriod = 24 * time.Hour
+
+// Pruner deletes backup folders that are not kept by a retention policy
+type Pruner struct {
+	store  ObjectStore
+	policy RetentionPolicy
+	now    func() time.Time
+	// GracePeriod keeps unreferenced objects written more recently
+	GracePeriod time.Duration
+}
+
+func NewPruner(store ObjectStore, policy RetentionPolicy) *Pruner {
+	return &Pruner{store: store, policy: policy, now: time.Now, GracePeriod: DefaultGCGracePeriod}
+}
+
+// Plan evaluates the retention policy over the backup folders without deleting anything
+func (p *Pruner) Plan(ctx context.Context) (*PrunePlan, error) {
+	if p.policy.IsZero() {
+		return nil, fmt.Errorf("no retention rules configured")
+	}
+	folders, err := ListBackupFolders(ctx, p.store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup folders: %w", err)
+	}
+
+	entries := make(map[string][]model.ManifestEntry, len(folders))
+	for _, f := range folders {
+		e, err := LoadFolderEntries(ctx, p.store, f.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index folder %s: %w", f.Name, err)
+		}
+		entries[f.Name] = e
+	}
+
+	keep := PlanRetention(folders, p.policy, p.now().UTC())
+	protectLatestVersions(folders, entries, keep)
+
+	plan := &PrunePlan{}
+	deleted := make(map[string]bool)
+	for _, f := range folders {
+		if reason, ok := keep[f.Name]; ok {
+			plan.Keep = append(plan.Keep, RetainedFolder{Name: f.Name, Reason: reason})
+			continue
+		}
+		// a folder is deleted as a whole or not at all, so one locked object keeps it
+		locked, err := p.folderLocked(ctx, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			plan.Locked = append(plan.Locked, f.Name)
+			continue
+		}
+		plan.Delete = append(plan.Delete, f.Name)
+		deleted[f.Name] = true
+	}
+
+	refs, err := CountReferences(ctx, p.store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count references: %w", err)
+	}
+	for name := range deleted {
+		for _, e := range entries[name] {
+			if _, ok := refs[e.Object]; ok {
+				refs[e.Object]--
+			}
+		}
+	}
+	for key, count := range refs {
+		if count > 0 || !strings.HasPrefix(key, ObjectsPrefix+"/") {
+			continue
+		}
+		locked, err := objectLocked(ctx, p.store, key)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			plan.Locked = append(plan.Locked, key)
+			continue
+		}
+		recent, err := objectRecent(ctx, p.store, key, p.GracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		if !recent {
+			plan.Objects = append(plan.Objects, key)
+		}
+	}
+	sort.Strings(plan.Objects)
+	sort.Strings(plan.Locked)
+	return plan, nil
+}
+
+// folderLocked reports whether any object of a folder is write-protected
+func (p *Pruner) folderLocked(ctx context.Context, folder string) (bool, error) {
+	if _, ok := p.store.(LockChecker); !ok {
+		return false, nil
+	}
+	keys, err := p.store.ListObjects(ctx, folder+"/")
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		locked, err := objectLocked(ctx, p.store, key)
+		if err != nil || locked {
+			return locked, err
+		}
+	}
+	return false, nil
+}
+
+// Prune deletes the folders not kept by the retention policy followed by the deduplicated
+// objects they alone referenced. With dryRun set it only returns the plan.
+func (p *Pruner) Prune(ctx context.Context, dryRun bool) (*PrunePlan, error) {
+	plan, err := p.Plan(ctx)
+	if err != nil || dryRun {
+		return plan, err
+	}
+
+	logger := logging.FromContext(ctx)
+	for _, folder := range plan.Delete {
+		keys, err := p.store.ListObjects(ctx, folder+"/")
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			err := p.store.DeleteObject(ctx, key)
+			if errors.Is(err, ErrObjectLocked) {
+				// locked after the plan was made; leave it for a later prune
+				logger.InfoContext(ctx, "Skipping locked object", "key", key)
+				plan.Locked = append(plan.Locked, key)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		logger.InfoContext(ctx, "Pruned backup folder", "folder", folder, "objects", len(keys))
+	}
+
+	objects, err := CollectGarbage(ctx, p.store, false, p.GracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	plan.Objects = objects
+	return plan, nil
+}
+
+// objectLocked reports whether a key is write-protected in stores that support locking
+func objectLocked(ctx context.Context, store ObjectStore, key string) (bool, error) {
+	checker, ok := store.(LockChecker)
+	if !ok {
+		return false, nil
+	}
+	locked, err := checker.ObjectLocked(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check lock of %s: %w", key, err)
+	}
+	return locked, nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+)
+
+// CountReferences returns how many manifests point to each deduplicated object.
+// Objects that are stored but not referenced by any manifest are reported with a count of zero.
+func CountReferences(ctx context.Context, store ObjectStore) (map[string]int, error) {
+	objects, err := store.ListObjects(ctx, ObjectsPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]int, len(objects))
+	for _, key := range objects {
+		refs[key] = 0
+	}
+
+	keys, err := store.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, ObjectsPrefix+"/") || !strings.HasSuffix(key, "/"+ManifestFile) {
+			continue
+		}
+		manifest, err := LoadManifest(ctx, store, strings.TrimSuffix(key, "/"+ManifestFile))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range manifest.Entries {
+			refs[entry.Object]++
+		}
+	}
+	return refs, nil
+}
+
+// CollectGarbage deletes deduplicated objects that are no longer referenced by any manifest
+// and returns their keys. Locked objects are skipped, and so are objects written less than
+// grace ago, which a backup still in progress may have stored without a manifest yet. With
+// dryRun set nothing is deleted.
+func CollectGarbage(ctx context.Context, store ObjectStore, dryRun bool, grace time.Duration) ([]string, error) {
+	refs, err := CountReferences(ctx, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count references: %w", err)
+	}
+	logger := logging.FromContext(ctx)
+	var unreferenced []string
+	for key, count := range refs {
+		if count > 0 || !strings.HasPrefix(key, ObjectsPrefix+"/") {
+			continue
+		}
+		locked, err := objectLocked(ctx, store, key)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			logger.InfoContext(ctx, "Skipping locked unreferenced object", "key", key)
+			continue
+		}
+		recent, err := objectRecent(ctx, store, key, grace)
+		if err != nil {
+			return nil, err
+		}
+		if recent {
+			logger.InfoContext(ctx, "Skipping recent unreferenced object", "key", key)
+			continue
+		}
+		unreferenced = append(unreferenced, key)
+	}
+	sort.Strings(unreferenced)
+
+	if dryRun {
+		return unreferenced, nil
+	}
+	deleted := unreferenced[:0]
+	for _, key := range unreferenced {
+		err := store.DeleteObject(ctx, key)
+		if errors.Is(err, ErrObjectLocked) {
+			logger.InfoContext(ctx, "Skipping locked unreferenced object", "key", key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, key)
+		logger.InfoContext(ctx, "Deleted unreferenced object", "key", key)
+	}
+	return deleted, nil
+}
+
+// objectRecent reports whether an object was written less than grace ago, in stores that
+// report modification times
+func objectRecent(ctx context.Context, store ObjectStore, key string, grace time.Duration) (bool, error) {
+	timer, ok := store.(ModTimer)
+	if !ok || grace <= 0 {
+		return false, nil
+	}
+	modTime, err := timer.ObjectModTime(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return time.Since(modTime) < grace, nil
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// ReportFile is the name of the run report written to every backup folder
+const ReportFile = "report.json"
+
+// ReportHTMLFile is the name of the optional HTML rendering of the run report
+const ReportHTMLFile = "report.html"
+
+// ReportKey returns the key of the run report of a backup folder
+func ReportKey(folder string) string {
+	return path.Join(folder, ReportFile)
+}
+
+// LoadReport reads the report of the last run that wrote to a backup folder
+func LoadReport(ctx context.Context, store ObjectStore, folder string) (*model.RunReport, error) {
+	data, err := store.GetObject(ctx, ReportKey(folder))
+	if err != nil {
+		return nil, err
+	}
+	var report model.RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode report of %s: %v", folder, err)
+	}
+	return &report, nil
+}
+
+// ReportWriter writes the report of each run to its backup folder, compared with the
+// report of the previous run found in the same storage
+type ReportWriter struct {
+	store ObjectStore
+	html  bool
+}
+
+// NewReportWriter creates a report writer, also rendering report.html when html is set
+func NewReportWriter(store ObjectStore, html bool) *ReportWriter {
+	return &ReportWriter{store: store, html: html}
+}
+
+// WriteReport writes report.json, and report.html if enabled, to the folder of the run.
+// A run that failed before anything was written to the folder gets no report, as the
+// report alone would look like an empty backup to retention and diff.
+func (w *ReportWriter) WriteReport(ctx context.Context, report *model.RunReport) error {
+	if report.Folder == "" {
+		return fmt.Errorf("report of run %s has no backup folder", report.RunID)
+	}
+	keys, err := w.store.ListObjects(ctx, report.Folder+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list backup folder %s: %w", report.Folder, err)
+	}
+	if len(keys) == 0 {
+		logging.FromContext(ctx).DebugContext(ctx, "Backup folder was not written, skipping run report", "folder", report.Folder)
+		return nil
+	}
+	previous, err := w.previousReport(ctx, report)
+	if err != nil {
+		// the comparison is informative, the report is still worth writing without it
+		logging.FromContext(ctx).WarnContext(ctx, "Unable to load the previous run report", "error", err)
+	}
+	report.Compare(previous)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run report: %v", err)
+	}
+	if err := w.store.PutObject(ctx, ReportKey(report.Folder), data); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	if !w.html {
+		return nil
+	}
+	var html bytes.Buffer
+	if err := reportTemplate.Execute(&html, newReportView(report)); err != nil {
+		return fmt.Errorf("failed to render run report: %v", err)
+	}
+	if err := w.store.PutObject(ctx, path.Join(report.Folder, ReportHTMLFile), html.Bytes()); err != nil {
+		return fmt.Errorf("failed to write HTML run report: %w", err)
+	}
+	return nil
+}
+
+// previousReport returns the report of the run before report: an earlier run that wrote
+// to the same folder, or the newest older folder with a report. Folders written before
+// reports existed are skipped.
+func (w *ReportWriter) previousReport(ctx context.Context, report *model.RunReport) (*model.RunReport, error) {
+	previous, err := LoadReport(ctx, w.store, report.Folder)
+	if err == nil && previous.RunID != report.RunID {
+		return previous, nil
+	}
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	folders, err := ListBackupFolders(ctx, w.store)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		if f.Name >= report.Folder {
+			continue
+		}
+		previous, err := LoadReport(ctx, w.store, f.Name)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		return previous, err
+	}
+	return nil, nil
+}
+
+// reportView is the data rendered by reportTemplate
+type reportView struct {
+	*model.RunReport
+	Duration   string
+	AssetTypes []assetTypeView
+}
+
+type assetTypeView struct {
+	Name string
+	model.Progress
+}
+
+func newReportView(report *model.RunReport) reportView {
+	view := reportView{RunReport: report, Duration: report.FinishedAt.Sub(report.StartedAt).Round(time.Second).String()}
+	for name, stats := range report.AssetTypes {
+		view.AssetTypes = append(view.AssetTypes, assetTypeView{Name: name, Progress: *stats})
+	}
+	sort.Slice(view.AssetTypes, func(i, j int) bool {
+		return view.AssetTypes[i].Name < view.AssetTypes[j].Name
+	})
+	return view
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Backup run {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #E01E5A; }
+.succeeded { color: #2EB67D; }
+</style>
+</head>
+<body>
+<h1>Backup run {{.RunID}}</h1>
+<p class="{{if .Success}}succeeded">Succeeded{{else}}failed">Failed{{with .Error}}: {{.}}{{end}}{{end}}</p>
+<p>Folder <code>{{.Folder}}</code>, started {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, took {{.Duration}}.
+Fetched {{.Progress.Fetched}}, saved {{.Progress.Saved}}, failed {{.Progress.Failed}}.
+{{.Retries}} retries, {{.TokenRefreshes}} token refreshes.</p>
+{{with .Previous}}
+<h2>Compared with the previous run</h2>
+<p>Run {{.RunID}} {{if .Success}}succeeded{{else}}failed{{end}} at {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}}
+after {{printf "%.0f" .DurationSeconds}}s, fetching {{.Progress.Fetched}} assets.
+Fetched {{printf "%+d" .FetchedChange}}, failed {{printf "%+d" .FailedChange}}, duration {{printf "%+.0f" .DurationChange}}s.</p>
+{{end}}
+{{with .AssetTypes}}
+<h2>Asset types</h2>
+<table>
+<tr><th>Type</th><th>Fetched</th><th>Saved</th><th>Failed</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Fetched}}</td><td>{{.Saved}}</td><td>{{.Failed}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Failures}}
+<h2>Failures</h2>
+<table>
+<tr><th>Asset</th><th>Name</th><th>Type</th><th>Reason</th></tr>
+{{range .}}<tr><td>{{.AssetID}}</td><td>{{.Name}}</td><td>{{.AssetType}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Destinations}}
+<h2>Destinations</h2>
+<table>
+<tr><th>Destination</th><th>Saved</th><th>Failed</th><th>Result</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Saved}}</td><td>{{.Failed}}</td><td>{{if .OK}}ok{{else}}{{.Error}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Timeline</h2>
+<table>
+<tr><th>Time</th><th>Event</th><th>Detail</th></tr>
+{{range .Timeline}}<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Event}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func finishedReport(runID, folder string, start time.Time, duration time.Duration, fetched int) *model.RunReport {
+	report := &model.RunReport{RunID: runID, Folder: folder, StartedAt: start}
+	report.AddFetched(fetched)
+	report.Finish(nil)
+	report.FinishedAt = start.Add(duration)
+	return report
+}
+
+func TestReportWriter(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	writer := NewReportWriter(store, true)
+	day1 := time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	// a folder that was never written gets no report
+	require.NoError(t, writer.WriteReport(ctx, finishedReport("run-0", "backup_20241119", day1, time.Minute, 0)))
+	folders, err := ListBackupFolders(ctx, store)
+	require.NoError(t, err)
+	assert.Empty(t, folders)
+
+	require.NoError(t, store.PutObject(ctx, "backup_20241120/1.json", []byte(`{"id":1,"name":"Block1"}`)))
+	require.NoError(t, writer.WriteReport(ctx, finishedReport("run-1", "backup_20241120", day1, time.Minute, 10)))
+
+	first, err := LoadReport(ctx, store, "backup_20241120")
+	require.NoError(t, err)
+	assert.Equal(t, "run-1", first.RunID)
+	assert.Nil(t, first.Previous)
+
+	// the report is not mistaken for a content block
+	entries, err := LoadFolderEntries(ctx, store, "backup_20241120")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, store.PutObject(ctx, "backup_20241121/2.json", []byte(`{"id":2,"name":"Block2"}`)))
+	second := finishedReport("run-2", "backup_20241121", day2, 90*time.Second, 4)
+	second.AddSavedBlock(model.ContentBlock{ID: 3, Name: "Broken", AssetType: &model.AssetType{Name: "htmlblock"}}, errors.New("disk full"))
+	require.NoError(t, writer.WriteReport(ctx, second))
+
+	loaded, err := LoadReport(ctx, store, "backup_20241121")
+	require.NoError(t, err)
+	if assert.NotNil(t, loaded.Previous) {
+		assert.Equal(t, "run-1", loaded.Previous.RunID)
+		assert.Equal(t, "backup_20241120", loaded.Previous.Folder)
+		assert.Equal(t, -6, loaded.Previous.FetchedChange)
+		assert.Equal(t, 1, loaded.Previous.FailedChange)
+		assert.Equal(t, 60.0, loaded.Previous.DurationSeconds)
+		assert.Equal(t, 30.0, loaded.Previous.DurationChange)
+	}
+	assert.Equal(t, []model.Failure{{AssetID: 3, Name: "Broken", AssetType: "htmlblock", Reason: "disk full"}}, loaded.Failures)
+
+	html, err := store.GetObject(ctx, "backup_20241121/report.html")
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "<h1>Backup run run-2</h1>")
+	assert.Contains(t, string(html), "<td>htmlblock</td><td>0</td><td>0</td><td>1</td>")
+	assert.Contains(t, string(html), "<td>3</td><td>Broken</td><td>htmlblock</td><td>disk full</td>")
+	assert.Contains(t, string(html), "Fetched -6, failed &#43;1, duration &#43;30s.")
+
+	// a later run on the same day is compared with the earlier run of that day
+	third := finishedReport("run-3", "backup_20241121", day2.Add(time.Hour), time.Minute, 1)
+	require.NoError(t, writer.WriteReport(ctx, third))
+	loaded, err = LoadReport(ctx, store, "backup_20241121")
+	require.NoError(t, err)
+	assert.Equal(t, "run-3", loaded.RunID)
+	assert.Equal(t, "run-2", loaded.Previous.RunID)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+)
+
+// FolderPrefix is the prefix of every backup folder created by a run
+const FolderPrefix = "backup_"
+
+// folderDateLayout is the time layout of backup folder names
+const folderDateLayout = "backup_20060102"
+
+// FolderName returns the backup folder name for a run started at t
+func FolderName(t time.Time) string {
+	return t.Format(folderDateLayout)
+}
+
+// BackupFolder is a backup folder found in a storage
+type BackupFolder struct {
+	Name string
+	Date time.Time
+}
+
+// ListBackupFolders returns the backup_* folders of a storage, newest first
+func ListBackupFolders(ctx context.Context, store ObjectStore) ([]BackupFolder, error) {
+	keys, err := store.ListObjects(ctx, FolderPrefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var folders []BackupFolder
+	for _, key := range keys {
+		name, _, found := strings.Cut(key, "/")
+		if !found || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if len(name) < len(folderDateLayout) {
+			continue
+		}
+		date, err := time.Parse(folderDateLayout, name[:len(folderDateLayout)])
+		if err != nil {
+			continue
+		}
+		folders = append(folders, BackupFolder{Name: name, Date: date})
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if !folders[i].Date.Equal(folders[j].Date) {
+			return folders[i].Date.After(folders[j].Date)
+		}
+		return folders[i].Name > folders[j].Name
+	})
+	return folders, nil
+}
+
+// RetentionPolicy describes which backup folders to keep, grandfather-father-son style
+type RetentionPolicy struct {
+	KeepLast    int // newest N folders
+	KeepDaily   int // newest folder of each day for the last D days
+	KeepWeekly  int // newest folder of each ISO week for the last W weeks
+	KeepMonthly int // newest folder of each month for the last M months
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 && p.KeepMonthly <= 0
+}
+
+// RetainedFolder is a folder kept by a prune together with the rule that kept it
+type RetainedFolder struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// PrunePlan lists the folders kept and deleted by a prune. Locked holds the folders and
+// objects that are due for deletion but write-protected, which are left in place.
+type PrunePlan struct {
+	Keep    []RetainedFolder `json:"keep"`
+	Delete  []string         `json:"delete"`
+	Objects []string         `json:"objects,omitempty"`
+	Locked  []string         `json:"locked,omitempty"`
+}
+
+// PlanRetention decides which folders the policy keeps. folders must be sorted newest first.
+func PlanRetention(folders []BackupFolder, policy RetentionPolicy, now time.Time) map[string]string {
+	keep := make(map[string]string)
+	mark := func(name, reason string) {
+		if _, ok := keep[name]; !ok {
+			keep[name] = reason
+		}
+	}
+
+	for i := 0; i < policy.KeepLast && i < len(folders); i++ {
+		mark(folders[i].Name, fmt.Sprintf("keep last %d", policy.KeepLast))
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	buckets := []struct {
+		count  int
+		cutoff time.Time
+		key    func(time.Time) string
+		reason string
+	}{
+		{policy.KeepDaily, today.AddDate(0, 0, -policy.KeepDaily+1), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}, "daily"},
+		{policy.KeepWeekly, today.AddDate(0, 0, -7*policy.KeepWeekly), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}, "weekly"},
+		{policy.KeepMonthly, today.AddDate(0, -policy.KeepMonthly, 0), func(t time.Time) string {
+			return t.Format("2006-01")
+		}, "monthly"},
+	}
+	for _, b := range buckets {
+		if b.count <= 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, f := range folders {
+			if f.Date.Before(b.cutoff) {
+				continue
+			}
+			k := b.key(f.Date)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			mark(f.Name, fmt.Sprintf("%s %s", b.reason, k))
+		}
+	}
+	return keep
+}
+
+// protectLatestVersions keeps, for every asset, at least one folder holding its latest version.
+// folders must be sorted newest first.
+func protectLatestVersions(folders []BackupFolder, entries map[string][]model.ManifestEntry, keep map[string]string) {
+	type version struct {
+		entry   model.ManifestEntry
+		folders []string
+	}
+	latest := make(map[int]*version)
+	for _, f := range folders {
+		for _, e := range entries[f.Name] {
+			v, ok := latest[e.ID]
+			switch {
+			case !ok || e.ModifiedDate.After(v.entry.ModifiedDate):
+				latest[e.ID] = &version{entry: e, folders: []string{f.Name}}
+			case e.SHA256 == v.entry.SHA256:
+				v.folders = append(v.folders, f.Name)
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		v := latest[id]
+		kept := false
+		for _, name := range v.folders {
+			if _, ok := keep[name]; ok {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			keep[v.folders[0]] = fmt.Sprintf("only copy of latest version of asset %d", id)
+		}
+	}
+}
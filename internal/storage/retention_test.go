@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dailyFolders(from time.Time, days int) []BackupFolder {
+	var folders []BackupFolder
+	for i := 0; i < days; i++ {
+		date := from.AddDate(0, 0, -i)
+		folders = append(folders, BackupFolder{Name: FolderName(date), Date: date})
+	}
+	return folders
+}
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Date(2024, 11, 30, 12, 0, 0, 0, time.UTC)
+	folders := dailyFolders(time.Date(2024, 11, 30, 0, 0, 0, 0, time.UTC), 90)
+
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		expected []string
+	}{
+		{
+			name:     "Keep last",
+			policy:   RetentionPolicy{KeepLast: 2},
+			expected: []string{"backup_20241130", "backup_20241129"},
+		},
+		{
+			name:     "Keep daily",
+			policy:   RetentionPolicy{KeepDaily: 3},
+			expected: []string{"backup_20241130", "backup_20241129", "backup_20241128"},
+		},
+		{
+			name:     "Keep weekly",
+			policy:   RetentionPolicy{KeepWeekly: 2},
+			expected: []string{"backup_20241130", "backup_20241124", "backup_20241117"},
+		},
+		{
+			name:     "Keep monthly",
+			policy:   RetentionPolicy{KeepMonthly: 2},
+			expected: []string{"backup_20241130", "backup_20241031", "backup_20240930"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := PlanRetention(folders, tt.policy, now)
+
+			var names []string
+			for _, f := range folders {
+				if _, ok := keep[f.Name]; ok {
+					names = append(names, f.Name)
+				}
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestPruner_Prune(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+
+	write := func(folder string, block model.ContentBlock) {
+		data, _ := json.Marshal(block)
+		assert.NoError(t, store.PutObject(ctx, fmt.Sprintf("%s/%d.json", folder, block.ID), data))
+	}
+	day := func(d int) time.Time { return time.Date(2024, 11, d, 0, 0, 0, 0, time.UTC) }
+
+	// asset 1 was only backed up once, asset 2 changed on every run
+	write("backup_20241101", model.ContentBlock{ID: 1, Content: "a", ModifiedDate: day(1)})
+	write("backup_20241101", model.ContentBlock{ID: 2, Content: "v1", ModifiedDate: day(1)})
+	write("backup_20241102", model.ContentBlock{ID: 2, Content: "v2", ModifiedDate: day(2)})
+	write("backup_20241103", model.ContentBlock{ID: 2, Content: "v3", ModifiedDate: day(3)})
+
+	pruner := NewPruner(store, RetentionPolicy{KeepLast: 1})
+	pruner.now = func() time.Time { return day(3) }
+
+	plan, err := pruner.Prune(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241102"}, plan.Delete)
+	assert.Equal(t, []RetainedFolder{
+		{Name: "backup_20241103", Reason: "keep last 1"},
+		{Name: "backup_20241101", Reason: "only copy of latest version of asset 1"},
+	}, plan.Keep)
+
+	exists, err := store.ObjectExists(ctx, "backup_20241102/2.json")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = pruner.Prune(ctx, false)
+	assert.NoError(t, err)
+
+	folders, err := ListBackupFolders(ctx, store)
+	assert.NoError(t, err)
+	assert.Len(t, folders, 2)
+
+	_, err = NewPruner(store, RetentionPolicy{}).Prune(ctx, true)
+	assert.Error(t, err)
+}
+
+func TestPruner_PruneDedup(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStorage(t.TempDir())
+	dedup := NewDedupStorage(store)
+
+	for d, content := range []string{"v1", "v2"} {
+		folder := fmt.Sprintf("backup_2024110%d", d+1)
+		block := model.ContentBlock{ID: 1, Content: content, ModifiedDate: time.Date(2024, 11, d+1, 0, 0, 0, 0, time.UTC)}
+		assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{block}, folder))
+		assert.NoError(t, dedup.FinalizeFolder(ctx, folder))
+	}
+
+	pruner := NewPruner(store, RetentionPolicy{KeepLast: 1})
+	// objects written within the grace period may belong to a backup in progress
+	plan, err := pruner.Prune(ctx, true)
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Objects)
+
+	objects, err := store.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	written := time.Now().Add(-2 * DefaultGCGracePeriod)
+	for _, key := range objects {
+		require.NoError(t, os.Chtimes(store.path(key), written, written))
+	}
+
+	plan, err = pruner.Prune(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241101"}, plan.Delete)
+	assert.Len(t, plan.Objects, 1)
+
+	executed, err := pruner.Prune(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.Objects, executed.Objects)
+
+	objects, err = store.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+}
+
+// lockedStore marks some keys of a store as write-protected
+type lockedStore struct {
+	ObjectStore
+	locked map[string]bool
+}
+
+func (s *lockedStore) ObjectLocked(ctx context.Context, key string) (bool, error) {
+	return s.locked[key], nil
+}
+
+func (s *lockedStore) DeleteObject(ctx context.Context, key string) error {
+	if s.locked[key] {
+		return fmt.Errorf("%s: %w", key, ErrObjectLocked)
+	}
+	return s.ObjectStore.DeleteObject(ctx, key)
+}
+
+func TestPruner_PruneLocked(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	store := &lockedStore{ObjectStore: local, locked: map[string]bool{}}
+	dedup := NewDedupStorage(local)
+
+	for d := 1; d <= 3; d++ {
+		folder := fmt.Sprintf("backup_2024110%d", d)
+		block := model.ContentBlock{ID: 1, Content: fmt.Sprintf("v%d", d), ModifiedDate: time.Date(2024, 11, d, 0, 0, 0, 0, time.UTC)}
+		assert.NoError(t, dedup.SaveContentBlocks(ctx, []model.ContentBlock{block}, folder))
+		assert.NoError(t, dedup.FinalizeFolder(ctx, folder))
+	}
+	store.locked["backup_20241101/"+ManifestFile] = true
+
+	pruner := NewPruner(store, RetentionPolicy{KeepLast: 1})
+	plan, err := pruner.Prune(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241102"}, plan.Delete)
+	assert.Equal(t, []string{"backup_20241101"}, plan.Locked)
+	assert.Len(t, plan.Objects, 1)
+
+	// the locked folder still references its object, so only the pruned folder's object is gone
+	objects, err := local.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	// once the objects of deleted folders are locked too, they are reported and kept
+	store.locked["backup_20241101/"+ManifestFile] = false
+	for _, key := range objects {
+		store.locked[key] = true
+	}
+	plan, err = pruner.Prune(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241101"}, plan.Delete)
+	assert.Len(t, plan.Locked, 1)
+	assert.Empty(t, plan.Objects)
+
+	objects, err = local.ListObjects(ctx, ObjectsPrefix+"/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+}
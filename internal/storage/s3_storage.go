@@ -3,23 +3,102 @@ package storage
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sync"
+	"time"
 
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
 	"github.com/Feride3d/backup-creator/internal/model"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type Uploader interface {
-	Upload(input *s3manager.UploadInput) (*s3manager.UploadOutput, error)
+	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+// S3API is the subset of the S3 client used to read, list and delete backup objects
+type S3API interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
+	ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+	GetObjectLockConfigurationWithContext(ctx aws.Context, input *s3.GetObjectLockConfigurationInput, opts ...request.Option) (*s3.GetObjectLockConfigurationOutput, error)
+	PutObjectRetentionWithContext(ctx aws.Context, input *s3.PutObjectRetentionInput, opts ...request.Option) (*s3.PutObjectRetentionOutput, error)
+	PutObjectLegalHoldWithContext(ctx aws.Context, input *s3.PutObjectLegalHoldInput, opts ...request.Option) (*s3.PutObjectLegalHoldOutput, error)
 }
 
 type S3Storage struct {
 	Uploader Uploader
+	Client   S3API
 	Bucket   string
+	Layout   Layout
+	Options  S3Options
+
+	credentials *rotatingCredentials
+}
+
+// S3Options controls how backup objects are stored
+type S3Options struct {
+	ServerSideEncryption string // "", "AES256" (SSE-S3) or "aws:kms" (SSE-KMS)
+	KMSKeyID             string // KMS key for SSE-KMS, the bucket default when empty
+	StorageClass         string // e.g. STANDARD_IA or GLACIER_IR, the bucket default when empty
+
+	ObjectLockMode      string        // "", "GOVERNANCE" or "COMPLIANCE"
+	ObjectLockRetention time.Duration // how long uploaded objects stay locked
+	LegalHold           bool          // place a legal hold on uploaded objects
+}
+
+// ObjectLockEnabled reports whether uploads request object lock retention or a legal hold
+func (o S3Options) ObjectLockEnabled() bool {
+	return o.ObjectLockMode != "" || o.LegalHold
+}
+
+// Validate checks the options against the values accepted by S3
+func (o S3Options) Validate() error {
+	switch o.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q", o.ServerSideEncryption)
+	}
+	if o.KMSKeyID != "" && o.ServerSideEncryption != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("a KMS key ID requires %s server-side encryption", s3.ServerSideEncryptionAwsKms)
+	}
+	switch o.ObjectLockMode {
+	case "":
+		if o.ObjectLockRetention > 0 {
+			return fmt.Errorf("an object lock retention period requires an object lock mode")
+		}
+	case s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance:
+		if o.ObjectLockRetention <= 0 {
+			return fmt.Errorf("object lock mode %s requires a retention period", o.ObjectLockMode)
+		}
+	default:
+		return fmt.Errorf("unsupported object lock mode %q", o.ObjectLockMode)
+	}
+	if o.StorageClass != "" {
+		for _, class := range s3.StorageClass_Values() {
+			if class == o.StorageClass {
+				return nil
+			}
+		}
+		return fmt.Errorf("unsupported storage class %q", o.StorageClass)
+	}
+	return nil
 }
 
 type S3Uploader struct {
@@ -30,8 +109,8 @@ func NewS3Uploader(uploader *s3manager.Uploader) *S3Uploader {
 	return &S3Uploader{uploader: uploader}
 }
 
-func (a *S3Uploader) Upload(input *s3manager.UploadInput) (*s3manager.UploadOutput, error) {
-	return a.uploader.Upload(input)
+func (a *S3Uploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return a.uploader.UploadWithContext(ctx, input, opts...)
 }
 
 func NewS3Storage(region, bucket, accessKey, secretKey string) (*S3Storage, error) {
@@ -45,9 +124,11 @@ func NewS3Storage(region, bucket, accessKey, secretKey string) (*S3Storage, erro
 		return nil, fmt.Errorf("secretKey cannot be empty")
 	}
 
+	creds := &rotatingCredentials{}
+	creds.set(accessKey, secretKey)
 	sess, err := session.NewSession(&aws.Config{
 		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		Credentials: credentials.NewCredentials(creds),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
@@ -55,30 +136,339 @@ func NewS3Storage(region, bucket, accessKey, secretKey string) (*S3Storage, erro
 	realUploader := s3manager.NewUploader(sess)
 
 	return &S3Storage{
-		Uploader: NewS3Uploader(realUploader),
-		Bucket:   bucket,
+		Uploader:    NewS3Uploader(realUploader),
+		Client:      s3.New(sess),
+		Bucket:      bucket,
+		credentials: creds,
 	}, nil
 }
 
+// SetCredentials replaces rotated access keys, used from the next request on
+func (s *S3Storage) SetCredentials(accessKey, secretKey string) {
+	if s.credentials != nil {
+		s.credentials.set(accessKey, secretKey)
+	}
+}
+
+// rotatingCredentials are static credentials that can be replaced while in use. They
+// report being expired once replaced, so that the SDK retrieves them again.
+type rotatingCredentials struct {
+	mu      sync.Mutex
+	value   credentials.Value
+	updated bool
+}
+
+func (c *rotatingCredentials) set(accessKey, secretKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = credentials.Value{AccessKeyID: accessKey, SecretAccessKey: secretKey, ProviderName: credentials.StaticProviderName}
+	c.updated = true
+}
+
+func (c *rotatingCredentials) Retrieve() (credentials.Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updated = false
+	return c.value, nil
+}
+
+func (c *rotatingCredentials) IsExpired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updated
+}
+
 // SaveContentBlocks uploads content blocks to S3
 func (s *S3Storage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	layout := s.Layout
+	if layout == nil {
+		layout = RawLayout{}
+	}
 	for _, block := range blocks {
-		data, err := json.Marshal(block)
+		files, err := layout.Files(block)
 		if err != nil {
-			return fmt.Errorf("failed to marshal block %d: %v", block.ID, err)
+			return err
+		}
+		for _, file := range files {
+			key := path.Join(folder, file.Path)
+			_, err = s.Uploader.UploadWithContext(ctx, s.uploadInput(ctx, key, file, &block))
+			if err != nil {
+				return fmt.Errorf("failed to upload block %d: %w", block.ID, err)
+			}
+			metrics.BytesWritten.WithLabelValues("s3").Add(float64(len(file.Data)))
+
+			logging.FromContext(ctx).DebugContext(ctx, "Uploaded content block to S3", "bucket", s.Bucket, "key", key)
+		}
+	}
+	return nil
+}
+
+// uploadInput builds the upload of a file with the configured encryption and storage class,
+// tags for the run ID and asset type and metadata for the modified date and checksum
+func (s *S3Storage) uploadInput(ctx context.Context, key string, file File, block *model.ContentBlock) *s3manager.UploadInput {
+	sha256Sum := sha256.Sum256(file.Data)
+	md5Sum := md5.Sum(file.Data)
+	return s.streamInput(ctx, key, file.ContentType, bytes.NewReader(file.Data), sha256Sum[:], md5Sum[:], block)
+}
+
+// streamInput builds the upload of body like uploadInput, with checksums computed by the
+// caller; the metadata and MD5 are left out when they are nil
+func (s *S3Storage) streamInput(ctx context.Context, key, contentType string, body io.Reader, sha256Sum, md5Sum []byte, block *model.ContentBlock) *s3manager.UploadInput {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+		Metadata:    map[string]*string{},
+	}
+	if sha256Sum != nil {
+		input.Metadata["sha256"] = aws.String(hex.EncodeToString(sha256Sum))
+	}
+
+	tags := url.Values{}
+	if runID := model.RunIDFromContext(ctx); runID != "" {
+		tags.Set("run-id", runID)
+	}
+	if block != nil {
+		input.Metadata["modified-date"] = aws.String(block.ModifiedDate.UTC().Format(time.RFC3339))
+		if block.AssetType != nil && block.AssetType.Name != "" {
+			tags.Set("asset-type", block.AssetType.Name)
 		}
+	}
+	if len(tags) > 0 {
+		input.Tagging = aws.String(tags.Encode())
+	}
 
-		key := fmt.Sprintf("%s/%d.json", folder, block.ID)
-		_, err = s.Uploader.Upload(&s3manager.UploadInput{
+	if s.Options.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.Options.ServerSideEncryption)
+	}
+	if s.Options.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.Options.KMSKeyID)
+	}
+	if s.Options.StorageClass != "" {
+		input.StorageClass = aws.String(s.Options.StorageClass)
+	}
+	if s.Options.ObjectLockMode != "" {
+		input.ObjectLockMode = aws.String(s.Options.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(s.Options.ObjectLockRetention).UTC())
+	}
+	if s.Options.LegalHold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+	if s.Options.ObjectLockEnabled() && md5Sum != nil {
+		// S3 requires an integrity checksum on uploads to buckets with object lock
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(md5Sum))
+	}
+	return input
+}
+
+// CheckObjectLock fails if the bucket does not have object lock enabled, in which case
+// uploads requesting retention or a legal hold would be rejected
+func (s *S3Storage) CheckObjectLock(ctx context.Context) error {
+	out, err := s.Client.GetObjectLockConfigurationWithContext(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration of bucket %s: %v", s.Bucket, err)
+	}
+	if out.ObjectLockConfiguration == nil ||
+		aws.StringValue(out.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return fmt.Errorf("object lock is not enabled on bucket %s", s.Bucket)
+	}
+	return nil
+}
+
+// ObjectLocked reports whether an object is under an active retention period or legal hold
+func (s *S3Storage) ObjectLocked(ctx context.Context, key string) (bool, error) {
+	out, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to head %s: %v", key, err)
+	}
+	if aws.StringValue(out.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn {
+		return true, nil
+	}
+	return out.ObjectLockRetainUntilDate != nil && out.ObjectLockRetainUntilDate.After(time.Now()), nil
+}
+
+// ExtendRetention moves the retention of an existing object to the end of a retention
+// period starting now, never shortening it, and places the legal hold if configured
+func (s *S3Storage) ExtendRetention(ctx context.Context, key string) error {
+	if !s.Options.ObjectLockEnabled() {
+		return nil
+	}
+	out, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to head %s: %v", key, err)
+	}
+
+	until := time.Now().Add(s.Options.ObjectLockRetention).UTC()
+	if s.Options.ObjectLockMode != "" && (out.ObjectLockRetainUntilDate == nil || out.ObjectLockRetainUntilDate.Before(until)) {
+		mode := s.Options.ObjectLockMode
+		// compliance mode cannot be relaxed to governance
+		if aws.StringValue(out.ObjectLockMode) == s3.ObjectLockModeCompliance {
+			mode = s3.ObjectLockModeCompliance
+		}
+		_, err := s.Client.PutObjectRetentionWithContext(ctx, &s3.PutObjectRetentionInput{
 			Bucket: aws.String(s.Bucket),
 			Key:    aws.String(key),
-			Body:   bytes.NewReader(data),
+			Retention: &s3.ObjectLockRetention{
+				Mode:            aws.String(mode),
+				RetainUntilDate: aws.Time(until),
+			},
 		})
 		if err != nil {
-			return fmt.Errorf("failed to upload block %d: %v", block.ID, err)
+			return fmt.Errorf("failed to extend the retention of %s: %v", key, err)
 		}
+	}
+	if s.Options.LegalHold && aws.StringValue(out.ObjectLockLegalHoldStatus) != s3.ObjectLockLegalHoldStatusOn {
+		_, err := s.Client.PutObjectLegalHoldWithContext(ctx, &s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(s.Bucket),
+			Key:       aws.String(key),
+			LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(s3.ObjectLockLegalHoldStatusOn)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to place a legal hold on %s: %v", key, err)
+		}
+	}
+	return nil
+}
 
-		fmt.Printf("Uploaded content block %d to S3 as %s\n", block.ID, key)
+func (s *S3Storage) PutObject(ctx context.Context, key string, data []byte) error {
+	_, err := s.Uploader.UploadWithContext(ctx, s.uploadInput(ctx, key, File{Data: data}, nil))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
 	}
+	metrics.BytesWritten.WithLabelValues("s3").Add(float64(len(data)))
 	return nil
 }
+
+// PutObjectFrom uploads an object read from r without holding it in memory; the uploader
+// sends large objects in parts. A seekable r is read once beforehand for the checksums.
+func (s *S3Storage) PutObjectFrom(ctx context.Context, key string, r io.Reader) error {
+	var sha256Sum, md5Sum []byte
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		sha256Hash, md5Hash := sha256.New(), md5.New()
+		if _, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), seeker); err != nil {
+			return fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		sha256Sum, md5Sum = sha256Hash.Sum(nil), md5Hash.Sum(nil)
+	}
+
+	body := &countingReader{r: r}
+	_, err := s.Uploader.UploadWithContext(ctx, s.streamInput(ctx, key, "", body, sha256Sum, md5Sum, nil))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	metrics.BytesWritten.WithLabelValues("s3").Add(float64(body.n))
+	return nil
+}
+
+func (s *S3Storage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return nil, fmt.Errorf("%s: %w", key, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return data, nil
+}
+
+func (s *S3Storage) ObjectExists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to head %s: %v", key, err)
+	}
+	return true, nil
+}
+
+func (s *S3Storage) ObjectModTime(ctx context.Context, key string) (time.Time, error) {
+	out, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to head %s: %v", key, err)
+	}
+	return aws.TimeValue(out.LastModified), nil
+}
+
+func (s *S3Storage) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects with prefix %q: %v", prefix, err)
+	}
+	return keys, nil
+}
+
+// DeleteObject deletes an object. Locked objects are refused with ErrObjectLocked, since in a
+// versioned bucket the delete would only hide the locked version behind a delete marker.
+func (s *S3Storage) DeleteObject(ctx context.Context, key string) error {
+	if s.Options.ObjectLockEnabled() {
+		locked, err := s.ObjectLocked(ctx, key)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return fmt.Errorf("%s: %w", key, ErrObjectLocked)
+		}
+	}
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	return nil
+}
+
+func isS3NotFound(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+}
@@ -3,22 +3,34 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Feride3d/backup-creator/internal/model"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type MockUploader struct {
 	mock.Mock
 }
 
-func (m *MockUploader) Upload(input *s3manager.UploadInput) (*s3manager.UploadOutput, error) {
+func (m *MockUploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	args := m.Called(input)
 	return args.Get(0).(*s3manager.UploadOutput), args.Error(1)
 }
@@ -43,10 +55,16 @@ func TestS3Storage_SaveContentBlocks(t *testing.T) {
 
 	for _, block := range blocks {
 		data, _ := json.Marshal(block)
-		mockUploader.On("Upload", &s3manager.UploadInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(fmt.Sprintf("%s/%d.json", folder, block.ID)),
-			Body:   bytes.NewReader(data),
+		sum := sha256.Sum256(data)
+		mockUploader.On("UploadWithContext", &s3manager.UploadInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(fmt.Sprintf("%s/%d.json", folder, block.ID)),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+			Metadata: map[string]*string{
+				"sha256":        aws.String(hex.EncodeToString(sum[:])),
+				"modified-date": aws.String("0001-01-01T00:00:00Z"),
+			},
 		}).Return(&s3manager.UploadOutput{}, nil)
 	}
 
@@ -70,7 +88,7 @@ func TestS3Storage_SaveContentBlocks_ErrorScenarios(t *testing.T) {
 				{ID: 2, Name: "ValidBlock", Content: "Some content"},
 			},
 			mockSetup: func(mockUploader *MockUploader) {
-				mockUploader.On("Upload", mock.Anything).Return(&s3manager.UploadOutput{}, fmt.Errorf("mocked upload error"))
+				mockUploader.On("UploadWithContext", mock.Anything).Return(&s3manager.UploadOutput{}, fmt.Errorf("mocked upload error"))
 			},
 			expectedError: "failed to upload block 2",
 		},
@@ -114,7 +132,62 @@ func TestS3Storage_SaveContentBlocks_MarshalError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to marshal block 1")
 
-	mockUploader.AssertNotCalled(t, "Upload", mock.Anything)
+	mockUploader.AssertNotCalled(t, "UploadWithContext", mock.Anything)
+}
+
+func TestS3Storage_SaveContentBlocks_Options(t *testing.T) {
+	mockUploader := new(MockUploader)
+	storage := NewTestS3Storage(mockUploader, "test-bucket")
+	storage.Options = S3Options{
+		ServerSideEncryption: "aws:kms",
+		KMSKeyID:             "alias/backups",
+		StorageClass:         "STANDARD_IA",
+	}
+
+	block := model.ContentBlock{
+		ID:           1,
+		Name:         "Block1",
+		AssetType:    &model.AssetType{ID: 197, Name: "htmlblock"},
+		ModifiedDate: time.Date(2024, 11, 21, 9, 30, 0, 0, time.UTC),
+		Content:      "Content1",
+	}
+
+	var input *s3manager.UploadInput
+	mockUploader.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input = args.Get(0).(*s3manager.UploadInput)
+	}).Return(&s3manager.UploadOutput{}, nil).Once()
+
+	ctx := model.WithRunID(context.Background(), "20241121T000000Z")
+	err := storage.SaveContentBlocks(ctx, []model.ContentBlock{block}, "backup_20241121")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "aws:kms", aws.StringValue(input.ServerSideEncryption))
+	assert.Equal(t, "alias/backups", aws.StringValue(input.SSEKMSKeyId))
+	assert.Equal(t, "STANDARD_IA", aws.StringValue(input.StorageClass))
+	assert.Equal(t, "application/json", aws.StringValue(input.ContentType))
+	assert.Equal(t, "asset-type=htmlblock&run-id=20241121T000000Z", aws.StringValue(input.Tagging))
+	assert.Equal(t, "2024-11-21T09:30:00Z", aws.StringValue(input.Metadata["modified-date"]))
+	assert.Len(t, aws.StringValue(input.Metadata["sha256"]), 64)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = storage.SaveContentBlocks(cancelled, []model.ContentBlock{block}, "backup_20241121")
+	assert.ErrorIs(t, err, context.Canceled)
+	mockUploader.AssertExpectations(t)
+}
+
+func TestS3Options_Validate(t *testing.T) {
+	assert.NoError(t, S3Options{}.Validate())
+	assert.NoError(t, S3Options{ServerSideEncryption: "AES256", StorageClass: "GLACIER_IR"}.Validate())
+	assert.NoError(t, S3Options{ServerSideEncryption: "aws:kms", KMSKeyID: "key"}.Validate())
+	assert.Error(t, S3Options{ServerSideEncryption: "rot13"}.Validate())
+	assert.Error(t, S3Options{ServerSideEncryption: "AES256", KMSKeyID: "key"}.Validate())
+	assert.Error(t, S3Options{StorageClass: "COLD"}.Validate())
+	assert.NoError(t, S3Options{ObjectLockMode: "COMPLIANCE", ObjectLockRetention: 24 * time.Hour}.Validate())
+	assert.NoError(t, S3Options{LegalHold: true}.Validate())
+	assert.Error(t, S3Options{ObjectLockMode: "GOVERNANCE"}.Validate())
+	assert.Error(t, S3Options{ObjectLockRetention: time.Hour}.Validate())
+	assert.Error(t, S3Options{ObjectLockMode: "FOREVER", ObjectLockRetention: time.Hour}.Validate())
 }
 
 func TestNewS3Storage(t *testing.T) {
@@ -176,3 +249,239 @@ func TestNewS3Storage(t *testing.T) {
 		})
 	}
 }
+
+type MockS3Client struct {
+	mock.Mock
+}
+
+func (m *MockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.GetObjectOutput)
+	return out, args.Error(1)
+}
+
+func (m *MockS3Client) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.HeadObjectOutput)
+	return out, args.Error(1)
+}
+
+func (m *MockS3Client) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	args := m.Called(input)
+	if page, ok := args.Get(0).(*s3.ListObjectsV2Output); ok {
+		fn(page, true)
+	}
+	return args.Error(1)
+}
+
+func (m *MockS3Client) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.DeleteObjectOutput)
+	return out, args.Error(1)
+}
+
+func (m *MockS3Client) GetObjectLockConfigurationWithContext(ctx aws.Context, input *s3.GetObjectLockConfigurationInput, opts ...request.Option) (*s3.GetObjectLockConfigurationOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.GetObjectLockConfigurationOutput)
+	return out, args.Error(1)
+}
+
+func (m *MockS3Client) PutObjectRetentionWithContext(ctx aws.Context, input *s3.PutObjectRetentionInput, opts ...request.Option) (*s3.PutObjectRetentionOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.PutObjectRetentionOutput)
+	return out, args.Error(1)
+}
+
+func (m *MockS3Client) PutObjectLegalHoldWithContext(ctx aws.Context, input *s3.PutObjectLegalHoldInput, opts ...request.Option) (*s3.PutObjectLegalHoldOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*s3.PutObjectLegalHoldOutput)
+	return out, args.Error(1)
+}
+
+func TestS3Storage_SetCredentials(t *testing.T) {
+	s3Storage, err := NewS3Storage("us-east-1", "bucket", "AKIA1", "secret1")
+	assert.NoError(t, err)
+	creds := s3Storage.Client.(*s3.S3).Config.Credentials
+
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA1", value.AccessKeyID)
+
+	// rotated keys are used without recreating the client
+	s3Storage.SetCredentials("AKIA2", "secret2")
+	value, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA2", value.AccessKeyID)
+	assert.Equal(t, "secret2", value.SecretAccessKey)
+}
+
+func TestS3Storage_ObjectLock(t *testing.T) {
+	mockUploader := new(MockUploader)
+	mockClient := new(MockS3Client)
+	storage := &S3Storage{Uploader: mockUploader, Client: mockClient, Bucket: "test-bucket"}
+	storage.Options = S3Options{ObjectLockMode: "COMPLIANCE", ObjectLockRetention: 24 * time.Hour, LegalHold: true}
+	ctx := context.Background()
+
+	var input *s3manager.UploadInput
+	mockUploader.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input = args.Get(0).(*s3manager.UploadInput)
+	}).Return(&s3manager.UploadOutput{}, nil).Once()
+
+	assert.NoError(t, storage.PutObject(ctx, "backup_20241121/1.json", []byte("one")))
+	assert.Equal(t, "COMPLIANCE", aws.StringValue(input.ObjectLockMode))
+	assert.Equal(t, "ON", aws.StringValue(input.ObjectLockLegalHoldStatus))
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), aws.TimeValue(input.ObjectLockRetainUntilDate), time.Minute)
+	assert.Equal(t, "+XxdKZQb+xsv2rCHSQargg==", aws.StringValue(input.ContentMD5))
+
+	lockInput := &s3.GetObjectLockConfigurationInput{Bucket: aws.String("test-bucket")}
+	mockClient.On("GetObjectLockConfigurationWithContext", lockInput).Return(&s3.GetObjectLockConfigurationOutput{
+		ObjectLockConfiguration: &s3.ObjectLockConfiguration{ObjectLockEnabled: aws.String("Enabled")},
+	}, nil).Once()
+	assert.NoError(t, storage.CheckObjectLock(ctx))
+	mockClient.On("GetObjectLockConfigurationWithContext", lockInput).Return(&s3.GetObjectLockConfigurationOutput{}, nil).Once()
+	assert.Error(t, storage.CheckObjectLock(ctx))
+
+	head := func(key string) *s3.HeadObjectInput {
+		return &s3.HeadObjectInput{Bucket: aws.String("test-bucket"), Key: aws.String(key)}
+	}
+	mockClient.On("HeadObjectWithContext", head("retained")).Return(&s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String("COMPLIANCE"),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(time.Hour)),
+	}, nil)
+	mockClient.On("HeadObjectWithContext", head("held")).Return(&s3.HeadObjectOutput{
+		ObjectLockLegalHoldStatus: aws.String("ON"),
+	}, nil)
+	mockClient.On("HeadObjectWithContext", head("expired")).Return(&s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String("COMPLIANCE"),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(-time.Hour)),
+	}, nil)
+	mockClient.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("expired"),
+	}).Return(&s3.DeleteObjectOutput{}, nil)
+
+	assert.ErrorIs(t, storage.DeleteObject(ctx, "retained"), ErrObjectLocked)
+	assert.ErrorIs(t, storage.DeleteObject(ctx, "held"), ErrObjectLocked)
+	assert.NoError(t, storage.DeleteObject(ctx, "expired"))
+
+	mockUploader.AssertExpectations(t)
+	mockClient.AssertExpectations(t)
+}
+
+func TestS3Storage_PutObjectFrom(t *testing.T) {
+	mockUploader := new(MockUploader)
+	storage := &S3Storage{Uploader: mockUploader, Bucket: "test-bucket"}
+	storage.Options = S3Options{ServerSideEncryption: "AES256", ObjectLockMode: "GOVERNANCE", ObjectLockRetention: time.Hour}
+
+	var input *s3manager.UploadInput
+	var body []byte
+	mockUploader.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input = args.Get(0).(*s3manager.UploadInput)
+		body, _ = io.ReadAll(input.Body)
+	}).Return(&s3manager.UploadOutput{}, nil).Twice()
+
+	rows := "Code\nFR\nDE\n"
+	sum := sha256.Sum256([]byte(rows))
+	ctx := model.WithRunID(context.Background(), "run-1")
+	require.NoError(t, storage.PutObjectFrom(ctx, "backup_20241121/data_extensions/regions.csv", strings.NewReader(rows)))
+	assert.Equal(t, rows, string(body))
+	assert.Equal(t, hex.EncodeToString(sum[:]), aws.StringValue(input.Metadata["sha256"]))
+	assert.Equal(t, "AES256", aws.StringValue(input.ServerSideEncryption))
+	assert.Equal(t, "GOVERNANCE", aws.StringValue(input.ObjectLockMode))
+	assert.Equal(t, "run-id=run-1", aws.StringValue(input.Tagging))
+	assert.NotEmpty(t, aws.StringValue(input.ContentMD5))
+	// the uploader reads the body itself rather than getting it in memory
+	_, inMemory := input.Body.(*bytes.Reader)
+	assert.False(t, inMemory)
+
+	// a reader that cannot be read twice is uploaded without checksums
+	require.NoError(t, storage.PutObjectFrom(ctx, "backup_20241121/data_extensions/stores.csv", io.MultiReader(strings.NewReader(rows))))
+	assert.Equal(t, rows, string(body))
+	assert.NotContains(t, input.Metadata, "sha256")
+	assert.Nil(t, input.ContentMD5)
+	mockUploader.AssertExpectations(t)
+}
+
+func TestS3Storage_ExtendRetentionOfReusedObjects(t *testing.T) {
+	mockUploader := new(MockUploader)
+	mockClient := new(MockS3Client)
+	storage := &S3Storage{Uploader: mockUploader, Client: mockClient, Bucket: "test-bucket"}
+	storage.Options = S3Options{ObjectLockMode: "GOVERNANCE", ObjectLockRetention: 24 * time.Hour, LegalHold: true}
+	ctx := context.Background()
+
+	blocks := []model.ContentBlock{{ID: 1, Name: "Reused"}, {ID: 2, Name: "Locked long enough"}}
+	keys := make([]string, len(blocks))
+	for i, block := range blocks {
+		data, err := json.Marshal(block)
+		require.NoError(t, err)
+		sum := sha256.Sum256(data)
+		keys[i] = ObjectKey(hex.EncodeToString(sum[:]))
+	}
+	head := func(key string) *s3.HeadObjectInput {
+		return &s3.HeadObjectInput{Bucket: aws.String("test-bucket"), Key: aws.String(key)}
+	}
+	// the first object was uploaded by an older backup and its retention is about to end
+	mockClient.On("HeadObjectWithContext", head(keys[0])).Return(&s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String("COMPLIANCE"),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(time.Hour)),
+	}, nil)
+	mockClient.On("HeadObjectWithContext", head(keys[1])).Return(&s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String("GOVERNANCE"),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(48 * time.Hour)),
+		ObjectLockLegalHoldStatus: aws.String("ON"),
+	}, nil)
+
+	var retention *s3.PutObjectRetentionInput
+	mockClient.On("PutObjectRetentionWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		retention = args.Get(0).(*s3.PutObjectRetentionInput)
+	}).Return(&s3.PutObjectRetentionOutput{}, nil).Once()
+	mockClient.On("PutObjectLegalHoldWithContext", &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String("test-bucket"),
+		Key:       aws.String(keys[0]),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String("ON")},
+	}).Return(&s3.PutObjectLegalHoldOutput{}, nil).Once()
+
+	require.NoError(t, NewDedupStorage(storage).SaveContentBlocks(ctx, blocks, "backup_20241121"))
+	require.NotNil(t, retention)
+	assert.Equal(t, keys[0], aws.StringValue(retention.Key))
+	assert.Equal(t, "COMPLIANCE", aws.StringValue(retention.Retention.Mode))
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), aws.TimeValue(retention.Retention.RetainUntilDate), time.Minute)
+	mockUploader.AssertNotCalled(t, "UploadWithContext", mock.Anything)
+	mockClient.AssertExpectations(t)
+}
+
+func TestS3Storage_Objects(t *testing.T) {
+	mockClient := new(MockS3Client)
+	storage := &S3Storage{Client: mockClient, Bucket: "test-bucket"}
+	ctx := context.Background()
+
+	mockClient.On("GetObjectWithContext", &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("backup_20241121/1.json"),
+	}).Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("one"))}, nil)
+	mockClient.On("HeadObjectWithContext", &s3.HeadObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("objects/sha256/missing"),
+	}).Return(nil, awserr.New("NotFound", "Not Found", nil))
+	mockClient.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+		Bucket: aws.String("test-bucket"),
+		Prefix: aws.String("backup_"),
+	}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+		{Key: aws.String("backup_20241121/1.json")},
+		{Key: aws.String("backup_20241121/2.json")},
+	}}, nil)
+
+	data, err := storage.GetObject(ctx, "backup_20241121/1.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+
+	exists, err := storage.ObjectExists(ctx, "objects/sha256/missing")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	keys, err := storage.ListObjects(ctx, "backup_")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup_20241121/1.json", "backup_20241121/2.json"}, keys)
+
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feride3d/backup-creator/internal/logging"
+	"github.com/Feride3d/backup-creator/internal/metrics"
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPOptions configures the connection to an SFTP server
+type SFTPOptions struct {
+	Addr           string // host:port of the server
+	User           string
+	PrivateKey     []byte // PEM encoded private key used to authenticate
+	Passphrase     []byte // optional passphrase of the private key
+	KnownHostsFile string // known_hosts file the server's host key is verified against
+	BasePath       string // remote directory backups are written under
+	PoolSize       int    // maximum number of open connections, defaults to 4
+	Timeout        time.Duration
+}
+
+// SFTPStorage writes backups to a remote directory over SFTP. Files are uploaded under a
+// temporary name and renamed into place, so readers never see partially written files.
+// Connections are pooled and shared by concurrent writers.
+type SFTPStorage struct {
+	basePath string
+	layout   Layout
+	pool     *sftpPool
+}
+
+// NewSFTPStorage connects to the server once to verify the host key and credentials
+func NewSFTPStorage(ctx context.Context, opts SFTPOptions) (*SFTPStorage, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("SFTP address cannot be empty")
+	}
+	if opts.BasePath == "" {
+		return nil, fmt.Errorf("SFTP base path cannot be empty")
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	sshConfig, err := sshClientConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SFTPStorage{
+		basePath: opts.BasePath,
+		layout:   RawLayout{Indent: true},
+		pool:     newSFTPPool(opts.Addr, sshConfig, opts.PoolSize),
+	}
+	err = s.withClient(ctx, func(c *sftp.Client) error {
+		return c.MkdirAll(s.basePath)
+	})
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to prepare SFTP base path %s: %w", s.basePath, err)
+	}
+	return s, nil
+}
+
+func sshClientConfig(opts SFTPOptions) (*ssh.ClientConfig, error) {
+	if opts.KnownHostsFile == "" {
+		return nil, fmt.Errorf("a known_hosts file is required to verify the SFTP server")
+	}
+	hostKeyCallback, err := knownhosts.New(opts.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %v", err)
+	}
+
+	var signer ssh.Signer
+	if len(opts.Passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(opts.PrivateKey, opts.Passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(opts.PrivateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SFTP private key: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            opts.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         opts.Timeout,
+	}, nil
+}
+
+// SetLayout changes how content blocks are written to backup folders
+func (s *SFTPStorage) SetLayout(layout Layout) {
+	s.layout = layout
+}
+
+// Close closes all pooled connections
+func (s *SFTPStorage) Close() error {
+	return s.pool.close()
+}
+
+// SaveContentBlocks uploads the files of each block into the backup folder
+func (s *SFTPStorage) SaveContentBlocks(ctx context.Context, blocks []model.ContentBlock, folder string) error {
+	return s.withClient(ctx, func(c *sftp.Client) error {
+		for _, block := range blocks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			files, err := s.layout.Files(block)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := s.upload(c, path.Join(folder, file.Path), bytes.NewReader(file.Data)); err != nil {
+					return fmt.Errorf("failed to upload block %d: %w", block.ID, err)
+				}
+			}
+		}
+		logging.FromContext(ctx).DebugContext(ctx, "Saved content blocks to SFTP directory", "path", path.Join(s.basePath, folder), "count", len(blocks))
+		return nil
+	})
+}
+
+// upload copies r to a temporary file next to key and renames it into place
+func (s *SFTPStorage) upload(c *sftp.Client, key string, r io.Reader) error {
+	target := s.remotePath(key)
+	if err := c.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(target), "."+path.Base(target)+".tmp-"+hex.EncodeToString(suffix))
+
+	f, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	if err := replaceFile(c, tmp, target); err != nil {
+		c.Remove(tmp)
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, target, err)
+	}
+	metrics.BytesWritten.WithLabelValues("sftp").Add(float64(n))
+	return nil
+}
+
+const posixRenameExtension = "posix-rename@openssh.com"
+
+// fileRenamer is the part of an SFTP client that moves an uploaded file into place
+type fileRenamer interface {
+	HasExtension(name string) (string, bool)
+	PosixRename(oldname, newname string) error
+	Rename(oldname, newname string) error
+	Remove(path string) error
+}
+
+// replaceFile renames tmp to target, replacing target if it exists. Servers without the
+// posix-rename extension refuse to rename over an existing file, so the old file is moved
+// aside first and put back if tmp cannot take its place.
+func replaceFile(c fileRenamer, tmp, target string) error {
+	if _, ok := c.HasExtension(posixRenameExtension); ok {
+		return c.PosixRename(tmp, target)
+	}
+
+	old := tmp + ".old"
+	if err := c.Rename(target, old); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to move %s aside: %w", target, err)
+		}
+		return c.Rename(tmp, target)
+	}
+	if err := c.Rename(tmp, target); err != nil {
+		if restoreErr := c.Rename(old, target); restoreErr != nil {
+			return fmt.Errorf("%w, and failed to restore %s from %s: %v", err, target, old, restoreErr)
+		}
+		return err
+	}
+	c.Remove(old)
+	return nil
+}
+
+func (s *SFTPStorage) remotePath(key string) string {
+	return path.Join(s.basePath, key)
+}
+
+// withClient runs fn on a pooled connection. Connections that fail with anything other
+// than an error reported by the server are discarded.
+func (s *SFTPStorage) withClient(ctx context.Context, fn func(*sftp.Client) error) error {
+	conn, err := s.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(conn.sftp)
+	s.pool.put(conn, connBroken(err))
+	return err
+}
+
+func (s *SFTPStorage) PutObject(ctx context.Context, key string, data []byte) error {
+	return s.withClient(ctx, func(c *sftp.Client) error {
+		return s.upload(c, key, bytes.NewReader(data))
+	})
+}
+
+// PutObjectFrom writes an object read from r without holding it in memory
+func (s *SFTPStorage) PutObjectFrom(ctx context.Context, key string, r io.Reader) error {
+	return s.withClient(ctx, func(c *sftp.Client) error {
+		return s.upload(c, key, r)
+	})
+}
+
+func (s *SFTPStorage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		f, err := c.Open(s.remotePath(key))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err = io.ReadAll(f)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *SFTPStorage) ObjectExists(ctx context.Context, key string) (bool, error) {
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		_, err := c.Stat(s.remotePath(key))
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (s *SFTPStorage) ObjectModTime(ctx context.Context, key string) (time.Time, error) {
+	var modTime time.Time
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		info, err := c.Stat(s.remotePath(key))
+		if err != nil {
+			return err
+		}
+		modTime = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return modTime, nil
+}
+
+// ListObjects returns the keys under prefix, skipping hidden files such as unfinished uploads
+func (s *SFTPStorage) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	root := s.basePath
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = s.remotePath(prefix[:i])
+	}
+
+	var keys []string
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		walker := c.Walk(root)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				if errors.Is(err, fs.ErrNotExist) && walker.Path() == root {
+					return nil
+				}
+				return err
+			}
+			name := path.Base(walker.Path())
+			if walker.Path() != root && strings.HasPrefix(name, ".") {
+				if walker.Stat().IsDir() {
+					walker.SkipDir()
+				}
+				continue
+			}
+			if walker.Stat().IsDir() {
+				continue
+			}
+			key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.basePath), "/")
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *SFTPStorage) DeleteObject(ctx context.Context, key string) error {
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		return c.Remove(s.remotePath(key))
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// connBroken reports whether err means the connection itself failed, as opposed to the
+// server rejecting a single request
+func connBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+	var status *sftp.StatusError
+	return !errors.As(err, &status) &&
+		!errors.Is(err, fs.ErrNotExist) &&
+		!errors.Is(err, fs.ErrExist) &&
+		!errors.Is(err, fs.ErrPermission) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func (c *sftpConn) close() error {
+	c.sftp.Close()
+	return c.ssh.Close()
+}
+
+// sftpPool hands out up to size connections, reusing idle ones and dialing new ones on demand
+type sftpPool struct {
+	addr   string
+	config *ssh.ClientConfig
+	slots  chan struct{}
+	idle   chan *sftpConn
+}
+
+func newSFTPPool(addr string, config *ssh.ClientConfig, size int) *sftpPool {
+	return &sftpPool{
+		addr:   addr,
+		config: config,
+		slots:  make(chan struct{}, size),
+		idle:   make(chan *sftpConn, size),
+	}
+}
+
+func (p *sftpPool) get(ctx context.Context) (*sftpConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *sftpPool) put(conn *sftpConn, broken bool) {
+	if broken {
+		conn.close()
+	} else {
+		p.idle <- conn
+	}
+	<-p.slots
+}
+
+func (p *sftpPool) dial(ctx context.Context) (*sftpConn, error) {
+	dialer := net.Dialer{Timeout: p.config.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server %s: %w", p.addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, p.addr, p.config)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", p.addr, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to start SFTP session on %s: %w", p.addr, err)
+	}
+	return &sftpConn{ssh: sshClient, sftp: sftpClient}, nil
+}
+
+func (p *sftpPool) close() error {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.close()
+		default:
+			return nil
+		}
+	}
+}
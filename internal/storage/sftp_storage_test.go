@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Feride3d/backup-creator/internal/model"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSFTPServer is an in-process SFTP server accepting a single client key
+type testSFTPServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+	active  atomic.Int32
+	peak    atomic.Int32
+}
+
+func newTestKey(t *testing.T) (ssh.Signer, []byte) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	return signer, pem.EncodeToMemory(block)
+}
+
+func startSFTPServer(t *testing.T, clientKey ssh.PublicKey) *testSFTPServer {
+	hostSigner, _ := newTestKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testSFTPServer{addr: listener.Addr().String(), hostKey: hostSigner.PublicKey()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *testSFTPServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	if n := s.active.Add(1); n > s.peak.Load() {
+		s.peak.Store(n)
+	}
+	defer s.active.Add(-1)
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					server.Close()
+				}
+			}
+		}()
+	}
+}
+
+func writeKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(file, []byte(knownhosts.Line([]string{addr}, key)+"\n"), 0600))
+	return file
+}
+
+func TestSFTPStorage(t *testing.T) {
+	ctx := context.Background()
+	clientSigner, clientKey := newTestKey(t)
+	server := startSFTPServer(t, clientSigner.PublicKey())
+	basePath := filepath.ToSlash(filepath.Join(t.TempDir(), "archive"))
+
+	storage, err := NewSFTPStorage(ctx, SFTPOptions{
+		Addr:           server.addr,
+		User:           "backup",
+		PrivateKey:     clientKey,
+		KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey),
+		BasePath:       basePath,
+		PoolSize:       2,
+	})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	// concurrent workers share at most PoolSize connections
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			block := model.ContentBlock{ID: id, Name: fmt.Sprintf("Block%d", id), Content: "content"}
+			assert.NoError(t, storage.SaveContentBlocks(ctx, []model.ContentBlock{block}, "backup_20241121"))
+		}(i)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, server.peak.Load(), int32(2))
+
+	keys, err := storage.ListObjects(ctx, "backup_20241121/")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 8)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(filepath.FromSlash(basePath), "backup_20241121"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 8)
+
+	blocks, err := LoadFolderBlocks(ctx, storage, "backup_20241121")
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 8)
+
+	assert.NoError(t, storage.PutObject(ctx, "backup_20241121/1.json", []byte("replaced")))
+	data, err := storage.GetObject(ctx, "backup_20241121/1.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "replaced", string(data))
+
+	assert.NoError(t, storage.PutObjectFrom(ctx, "backup_20241121/data_extensions/regions.csv", strings.NewReader("Code\nFR\n")))
+	data, err = storage.GetObject(ctx, "backup_20241121/data_extensions/regions.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "Code\nFR\n", string(data))
+
+	assert.NoError(t, storage.DeleteObject(ctx, "backup_20241121/1.json"))
+	exists, err := storage.ObjectExists(ctx, "backup_20241121/1.json")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = storage.GetObject(ctx, "backup_20241121/1.json")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+
+	keys, err = storage.ListObjects(ctx, "missing/")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// fakeRenamer is a server without the posix-rename extension, holding file contents by path
+type fakeRenamer struct {
+	files map[string]string
+	// failRename fails renames of this path
+	failRename string
+}
+
+func (f *fakeRenamer) HasExtension(name string) (string, bool) {
+	return "", false
+}
+
+func (f *fakeRenamer) PosixRename(oldname, newname string) error {
+	return fmt.Errorf("posix-rename is not supported")
+}
+
+func (f *fakeRenamer) Rename(oldname, newname string) error {
+	if oldname == f.failRename {
+		return fmt.Errorf("permission denied")
+	}
+	data, ok := f.files[oldname]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if _, ok := f.files[newname]; ok {
+		return fmt.Errorf("%s already exists", newname)
+	}
+	delete(f.files, oldname)
+	f.files[newname] = data
+	return nil
+}
+
+func (f *fakeRenamer) Remove(path string) error {
+	delete(f.files, path)
+	return nil
+}
+
+func TestReplaceFile_WithoutPosixRename(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		failRename string
+		expected   map[string]string
+		err        string
+	}{
+		{
+			name:     "New file",
+			files:    map[string]string{".1.json.tmp": "new"},
+			expected: map[string]string{"1.json": "new"},
+		},
+		{
+			name:     "Replaced file",
+			files:    map[string]string{".1.json.tmp": "new", "1.json": "old"},
+			expected: map[string]string{"1.json": "new"},
+		},
+		{
+			name:       "Failed swap keeps the old file",
+			files:      map[string]string{".1.json.tmp": "new", "1.json": "old"},
+			failRename: ".1.json.tmp",
+			expected:   map[string]string{".1.json.tmp": "new", "1.json": "old"},
+			err:        "permission denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renamer := &fakeRenamer{files: tt.files, failRename: tt.failRename}
+			err := replaceFile(renamer, ".1.json.tmp", "1.json")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, renamer.files)
+		})
+	}
+}
+
+func TestNewSFTPStorage_Errors(t *testing.T) {
+	ctx := context.Background()
+	clientSigner, clientKey := newTestKey(t)
+	_, otherKey := newTestKey(t)
+	otherHost, _ := newTestKey(t)
+	server := startSFTPServer(t, clientSigner.PublicKey())
+	basePath := filepath.ToSlash(t.TempDir())
+
+	tests := []struct {
+		name string
+		opts SFTPOptions
+	}{
+		{
+			name: "Unknown host key",
+			opts: SFTPOptions{
+				Addr:           server.addr,
+				PrivateKey:     clientKey,
+				KnownHostsFile: writeKnownHosts(t, server.addr, otherHost.PublicKey()),
+				BasePath:       basePath,
+			},
+		},
+		{
+			name: "Rejected client key",
+			opts: SFTPOptions{
+				Addr:           server.addr,
+				PrivateKey:     otherKey,
+				KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey),
+				BasePath:       basePath,
+			},
+		},
+		{
+			name: "Missing known_hosts",
+			opts: SFTPOptions{Addr: server.addr, PrivateKey: clientKey, BasePath: basePath},
+		},
+		{
+			name: "Invalid private key",
+			opts: SFTPOptions{
+				Addr:           server.addr,
+				PrivateKey:     []byte("not a key"),
+				KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey),
+				BasePath:       basePath,
+			},
+		},
+		{
+			name: "Missing base path",
+			opts: SFTPOptions{Addr: server.addr, PrivateKey: clientKey},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSFTPStorage(ctx, tt.opts)
+			assert.Error(t, err)
+		})
+	}
+}
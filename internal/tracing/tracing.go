@@ -0,0 +1,97 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are created through the global
+// tracer provider, which records nothing unless Setup installs an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Feride3d/backup-creator"
+
+// Options configures the exporter installed by Setup
+type Options struct {
+	Exporter    string  // "none" (default) or "otlp"
+	Endpoint    string  // OTLP/HTTP collector host:port, the exporter default when empty
+	Insecure    bool    // send to the collector over plain HTTP
+	SampleRatio float64 // fraction of runs traced, all when 0
+	Propagate   bool    // send the trace context to Marketing Cloud in request headers
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and propagator and returns a function that
+// flushes and stops the exporter
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Propagate {
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+
+	switch strings.ToLower(opts.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none or otlp", opts.Exporter)
+	}
+
+	var clientOpts []otlptracehttp.Option
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = "backup-creator"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(opts.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed when err is set and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport wraps next so that each request gets a client span and, when propagation is
+// enabled, carries the trace context
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next)
+}